@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Registered accounts. A registered @nick requires a password to /login and
+// keeps its settings (ignore list) between sessions.
+// Accounts are stored in a json file, passwords can be created by the
+// operator with bin/create_passwd or by the user with /register.
+
+type Account struct {
+	Name     string   `json:"name"`
+	Password string   `json:"password"` // bcrypt hash
+	Ignored  []string `json:"ignored,omitempty"`
+}
+
+type Accounts struct {
+	accounts     map[string]*Account
+	file         string // where we persist the accounts. Empty: in memory only
+	sync.RWMutex        // for reading/updating accounts
+}
+
+// load the accounts from file. If file doesn't exist we start empty.
+func NewAccounts(file string) (*Accounts, error) {
+
+	accounts := &Accounts{
+		accounts: make(map[string]*Account),
+		file:     file,
+	}
+
+	if no(file) {
+		return accounts, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return accounts, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*Account
+
+	err = json.Unmarshal(data, &list)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, account := range list {
+		accounts.accounts[account.Name] = account
+	}
+
+	return accounts, nil
+}
+
+func init_accounts(file string) error {
+
+	accounts, err := NewAccounts(file)
+	if err != nil {
+		return err
+	}
+
+	ACCOUNTS = accounts
+
+	INFO.Printf("%d registered accounts loaded", len(accounts.accounts))
+
+	return nil
+}
+
+// is name a registered account?
+func (a *Accounts) isRegistered(name string) bool {
+	a.RLock()
+	defer a.RUnlock()
+
+	_, ok := a.accounts[name]
+
+	return ok
+}
+
+// check the password of a registered account
+func (a *Accounts) checkPassword(name string, password string) bool {
+	a.RLock()
+	account, ok := a.accounts[name]
+	a.RUnlock()
+
+	if !ok {
+		return false
+	}
+
+	return bcrypt.CompareHashAndPassword([]byte(account.Password), []byte(password)) == nil
+}
+
+// register name with password, keeping the ignore list
+func (a *Accounts) register(name string, password string, ignored []string) error {
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	if _, ok := a.accounts[name]; ok {
+		return errors.New("already registered")
+	}
+
+	a.accounts[name] = &Account{
+		Name:     name,
+		Password: string(hash),
+		Ignored:  ignored,
+	}
+
+	return a.save()
+}
+
+// add an account registered in a linked server, returns if it was new
+func (a *Accounts) add(name string, hash string) bool {
+
+	if _, err := ValidUsername(name); err != nil || no(hash) {
+		return false
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	if _, ok := a.accounts[name]; ok {
+		return false
+	}
+
+	a.accounts[name] = &Account{Name: name, Password: hash}
+
+	err := a.save()
+	if err != nil {
+		ERROR.Printf("unable to save the accounts (%s)", err)
+	}
+
+	return true
+}
+
+// register again the accounts of a snapshot, the ones we have are kept
+func (a *Accounts) restore(accounts []Account) {
+	a.Lock()
+	defer a.Unlock()
+
+	for i := range accounts {
+		if _, ok := a.accounts[accounts[i].Name]; ok {
+			continue
+		}
+
+		a.accounts[accounts[i].Name] = &accounts[i]
+	}
+
+	err := a.save()
+	if err != nil {
+		ERROR.Printf("unable to save the accounts (%s)", err)
+	}
+}
+
+// return the password hash of a registered account
+func (a *Accounts) passwordHash(name string) string {
+	a.RLock()
+	defer a.RUnlock()
+
+	account, ok := a.accounts[name]
+	if !ok {
+		return ""
+	}
+
+	return account.Password
+}
+
+// return a copy of all the registered accounts
+func (a *Accounts) list() (accounts []Account) {
+	a.RLock()
+	defer a.RUnlock()
+
+	for _, account := range a.accounts {
+		accounts = append(accounts, *account)
+	}
+
+	return accounts
+}
+
+// return the ignore list of a registered account
+func (a *Accounts) ignored(name string) []string {
+	a.RLock()
+	defer a.RUnlock()
+
+	account, ok := a.accounts[name]
+	if !ok {
+		return nil
+	}
+
+	return append([]string{}, account.Ignored...)
+}
+
+// update the ignore list of name, only if it's a registered account
+func (a *Accounts) setIgnored(name string, ignored []string) error {
+	a.Lock()
+	defer a.Unlock()
+
+	account, ok := a.accounts[name]
+	if !ok {
+		return nil
+	}
+
+	account.Ignored = ignored
+
+	return a.save()
+}
+
+// write all the accounts to file. Must be called with the lock held.
+func (a *Accounts) save() error {
+
+	if no(a.file) {
+		return nil
+	}
+
+	list := make([]*Account, 0, len(a.accounts))
+	for _, account := range a.accounts {
+		list = append(list, account)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	data, err := json.MarshalIndent(list, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	// write and rename so a crash never leaves half a file
+	tmp := a.file + ".tmp"
+
+	err = os.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, a.file)
+}
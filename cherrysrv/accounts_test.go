@@ -0,0 +1,65 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestAccountsPersistence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "accounts.json")
+
+	accounts, err := NewAccounts(file)
+	if err != nil {
+		t.Fatalf("NewAccounts() on a missing file error = %v", err)
+	}
+
+	if err := accounts.register("@john", "secret", []string{"@spammer"}); err != nil {
+		t.Fatalf("register() error = %v", err)
+	}
+
+	if err := accounts.register("@john", "other", nil); err == nil {
+		t.Errorf("register() twice should fail")
+	}
+
+	if err := accounts.setIgnored("@john", []string{"@spammer", "@troll"}); err != nil {
+		t.Fatalf("setIgnored() error = %v", err)
+	}
+
+	// not registered, nothing to save
+	if err := accounts.setIgnored("@anon", []string{"@john"}); err != nil {
+		t.Fatalf("setIgnored() error = %v", err)
+	}
+
+	reloaded, err := NewAccounts(file)
+	if err != nil {
+		t.Fatalf("NewAccounts() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		want     bool
+	}{
+		{"right password", "@john", "secret", true},
+		{"wrong password", "@john", "Secret", false},
+		{"empty password", "@john", "", false},
+		{"not registered", "@anon", "secret", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := reloaded.checkPassword(tt.username, tt.password); got != tt.want {
+				t.Errorf("checkPassword() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if got, want := reloaded.ignored("@john"), []string{"@spammer", "@troll"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ignored() = %v, want %v", got, want)
+	}
+
+	if reloaded.isRegistered("@anon") {
+		t.Errorf("@anon should not be registered")
+	}
+}
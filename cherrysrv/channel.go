@@ -60,7 +60,19 @@ func (c *Channel) isHidden() bool {
 	return c.hidden
 }
 
+// "1" for hidden channels, "0" otherwise. Used by the server links.
+func (c *Channel) hiddenFlag() string {
+	if c.hidden {
+		return "1"
+	}
+
+	return "0"
+}
+
 func (c *Channel) Count() int {
+	c.RLock()
+	defer c.RUnlock()
+
 	return len(c.clients)
 }
 
@@ -81,6 +93,14 @@ func (c *Channel) ClientNames() (output []string) {
 	return output
 }
 
+// return a copy of the clients currently in this channel
+func (c *Channel) members() []*Client {
+	c.RLock()
+	defer c.RUnlock()
+
+	return append([]*Client{}, c.clients...)
+}
+
 // find if a certain client is in this channel
 func (c *Channel) contains(client *Client) bool {
 	c.RLock()
@@ -156,6 +176,25 @@ func (channel *Channel) removeClient(client *Client) bool {
 	return false
 }
 
+// close the channel if nobody is in it, like a channel restored from a
+// snapshot that nobody came back to. Returns true if it was closed
+func (channel *Channel) closeIfEmpty() bool {
+	channel.Lock()
+	defer channel.Unlock()
+
+	if !channel.closeOnEmpty || channel.Status == CHANNEL_SHUTTINGDOWN || len(channel.clients) > 0 {
+		return false
+	}
+
+	channel.Status = CHANNEL_SHUTTINGDOWN
+
+	DEBUG.Printf("%s is still empty, removing it from the directory", channel)
+
+	CHANNELS.Delete(channel.Name)
+
+	return true
+}
+
 func (channel *Channel) Say(from *Client, format string, args ...interface{}) {
 
 	message := fmt.Sprintf(format, args...)
@@ -165,6 +204,22 @@ func (channel *Channel) Say(from *Client, format string, args ...interface{}) {
 	}
 
 	channel.write(from, ">"+channel.Name+">"+from.Name+">"+message+"\n")
+
+	if from.isLocal() {
+		send_links(nil, "SAY %s %s %s", channel, from, message)
+	}
+}
+
+// send a server event (>#channel>!event>text) to everyone in the channel
+func (channel *Channel) Event(event string, format string, args ...interface{}) {
+
+	message := fmt.Sprintf(format, args...)
+
+	if len(message) == 0 {
+		return
+	}
+
+	channel.write(nil, ">"+channel.Name+">!"+event+">"+message+"\n")
 }
 
 func (c *Channel) write(from *Client, message string) {
@@ -178,6 +233,10 @@ func (c *Channel) write(from *Client, message string) {
 	}
 
 	for i := 0; i < len; i++ {
+		if c.clients[i].isIgnoring(from) {
+			continue
+		}
+
 		if c.clients[i].isLogged() { // TODO: we should be able to remove this check. If is in a channel IT MUST be logged.
 			c.clients[i].write(message)
 		}
@@ -4,8 +4,12 @@ import (
 	"bufio"
 	"fmt"
 	"net"
+	"sort"
 	"strings"
 	"sync/atomic"
+	"time"
+
+	"github.com/lrita/cmap"
 )
 
 // client status
@@ -15,11 +19,19 @@ const (
 	USER_LOGGINOUT = 4 // Player being cleaned up, it won't accept any string sent to them.
 )
 
+// max number of @names a client can ignore
+const MAX_IGNORED = 32
+
 // Client connection storing basic client data
 type Client struct {
-	conn   net.Conn // network connection interface.
-	Name   string   // Name of the user.
-	Status atomic.Int32
+	conn     net.Conn      // network connection interface. nil for users in linked servers.
+	reader   *bufio.Reader // buffered reads from conn, kept between lines.
+	Name     string        // Name of the user.
+	Status   atomic.Int32
+	ignored  cmap.Map[string, bool] // @names this client doesn't want to read
+	link     *Link                  // link to reach the user when logged in another server, nil if local
+	home     string                 // server where the user is logged in
+	loggedOn time.Time              // when the user logged in
 }
 
 func (c *Client) String() string {
@@ -29,8 +41,9 @@ func (c *Client) String() string {
 func newClient(conn net.Conn) *Client {
 
 	client := &Client{
-		conn: conn,
-		Name: gensym("@Anon"),
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+		Name:   gensym("@Anon"),
 	}
 	client.Status.Store(USER_NOTLOGGED)
 
@@ -45,12 +58,45 @@ func (c *Client) Key() string {
 	return c.Name
 }
 
+// check if the client is connected to this server
+func (clt *Client) isLocal() bool {
+	return clt.link == nil
+}
+
 // Close a client connection following ws protocol plus removing the internal handlers in the mud.
 func (clt *Client) Close() {
 
 	clt.RemoveMeFromAllChannels()
 	clt.conn.Close()
-	CLIENTS.Delete(clt.Name)
+	clt.forget()
+
+	if !clt.loggedOn.IsZero() {
+		send_links(nil, "QUIT %s %s", clt, clt.home)
+	}
+}
+
+// Disconnect a client from another goroutine, when a linked server says it's gone
+// or it lost its nick to a user in a linked server. Nothing is told to the links.
+func (clt *Client) Kill() {
+
+	clt.Status.Store(USER_LOGGINOUT)
+	clt.RemoveMeFromAllChannels()
+
+	if clt.isLocal() {
+		clt.conn.Close()
+	}
+
+	clt.forget()
+}
+
+// remove the client from CLIENTS, unless its name is already used by someone else
+func (clt *Client) forget() {
+
+	current, ok := CLIENTS.Load(clt.Name)
+
+	if ok && current == clt {
+		CLIENTS.Delete(clt.Name)
+	}
 }
 
 // main client loop that process client's messages
@@ -67,6 +113,9 @@ func (clt *Client) clientLoop() {
 		}
 
 		line, err := clt.read()
+		if err != nil && clt.Status.Load() == USER_LOGGINOUT {
+			return // killed from another goroutine, already cleaned up
+		}
 		if err != nil {
 			INFO.Printf("%s disconnected (%s)", clt, clt.conn.RemoteAddr())
 			clt.UpdateInMain(">!disconnect>%s disconnected", clt)
@@ -139,7 +188,7 @@ func (clt *Client) write(line string) (n int, err error) {
 // writeNoLimit a message to the client. Unlimited length.
 func (clt *Client) writeNoLimit(line string) (n int, err error) {
 
-	if len(line) == 0 {
+	if len(line) == 0 || !clt.isLocal() {
 		return
 	}
 
@@ -157,13 +206,39 @@ func (clt *Client) isLogged() bool {
 	return clt.Status.Load() == USER_LOGGED
 }
 
+// check if client doesn't want to read messages from other
+func (clt *Client) isIgnoring(other *Client) bool {
+	if other == nil {
+		return false
+	}
+
+	_, ok := clt.ignored.Load(other.Name)
+
+	return ok
+}
+
+// the @names ignored by the client, sorted
+func (clt *Client) ignoredNames() (names []string) {
+
+	clt.ignored.Range(func(name string, _ bool) bool {
+		names = append(names, name)
+		return true
+	})
+
+	sort.Strings(names)
+
+	return names
+}
+
 // Read message sent by client, limited to 255 chars
 func (client *Client) read() (string, error) {
 
-	netData, err := bufio.NewReader(client.conn).ReadString('\n')
+	netData, err := client.reader.ReadString('\n')
 
 	if err != nil {
 		DEBUG.Printf("%s.read() failed with err: %s", client, err)
+
+		return "", err
 	}
 
 	netData = shorten255(netData)
@@ -202,6 +277,10 @@ func (clt *Client) UpdateInMain(format string, args ...interface{}) {
 	}
 
 	CLIENTS.Range(broadcast)
+
+	if clt.isLocal() && !clt.loggedOn.IsZero() {
+		send_links(nil, "EVENT %s", line)
+	}
 }
 
 // delete me from all the channels. This can be optimised in the future.
@@ -3,6 +3,7 @@ package main
 import (
 	"runtime"
 	"sort"
+	"time"
 )
 
 func init_commands() {
@@ -21,12 +22,20 @@ func init_commands() {
 	COMMANDS["leave"] = do_leave
 	COMMANDS["list"] = do_list
 	COMMANDS["license"] = do_license
+	COMMANDS["games"] = do_games
+	COMMANDS["invite"] = do_invite
+	COMMANDS["register"] = do_register
+	COMMANDS["ignore"] = do_ignore
+	COMMANDS["unignore"] = do_unignore
+	COMMANDS["ignorelist"] = do_ignorelist
 }
 
 func do_help(clt *Client, args string) {
 
 	clt.SayN(">/help>",
 		[]string{"/login <nick> - login to cherry server",
+			"/login <nick> <password>   - login to a registered nick",
+			"/register <password>       - register my nick",
 			"/who                       - show my nickname",
 			"/help                      - this command",
 			"/users                     - who is logged?",
@@ -37,6 +46,11 @@ func do_help(clt *Client, args string) {
 			"/hlist                     - show available hidden channels",
 			"/join <#channel>           - join/create a channel",
 			"/hjoin <#channel>          - join/create hidden channel",
+			"/games                     - show online game servers",
+			"/invite @nick <serverurl>  - invite a user to a game",
+			"/ignore @nick              - stop reading messages from @nick",
+			"/unignore @nick            - read messages from @nick again",
+			"/ignorelist                - show who I'm ignoring",
 			"/license                   - view license agreement",
 			"/logoff                    - logoff"})
 
@@ -240,7 +254,7 @@ func channel_users(clt *Client, channelName string) {
 
 }
 
-// login user. No password required
+// login user. Password only required for registered accounts
 func do_login(clt *Client, args string) {
 
 	/* Check params */
@@ -257,18 +271,36 @@ func do_login(clt *Client, args string) {
 		return
 	}
 
-	username, err := ValidUsername(args)
+	login, password := split2(args, " ")
+
+	username, err := ValidUsername(login)
 
 	if err != nil {
-		clt.Say(">/login>0>%s is not a valid username because %s", args, err.Error())
-		WARN.Printf("user %s unable to login due to: %s", args, err.Error())
+		clt.Say(">/login>0>%s is not a valid username because %s", login, err.Error())
+		WARN.Printf("user %s unable to login due to: %s", login, err.Error())
 
 		return
 	}
 
-	_, ok := CLIENTS.Load(username)
+	registered := ACCOUNTS.isRegistered(username)
 
-	if ok {
+	if registered && !ACCOUNTS.checkPassword(username, password) {
+		clt.Say(">/login>0>%s is registered, /login %s <password>", username, username)
+		WARN.Printf("user %s unable to login due to a wrong password", username)
+
+		return
+	}
+
+	if !registered && !no(password) {
+		clt.Say(">/login>0>%s is not registered, /login %s", username, username)
+
+		return
+	}
+
+	// reserve the name in one step, two clients may be asking for it
+	_, taken := CLIENTS.LoadOrStore(username, clt)
+
+	if taken {
 		clt.Say(">/login>0>%s is already taken, please select another @name", username)
 		return
 	}
@@ -278,19 +310,29 @@ func do_login(clt *Client, args string) {
 	oldName := clt.Name
 
 	clt.Name = username
+	clt.home = SRVNAME
+	clt.loggedOn = time.Now()
+
+	for _, name := range ACCOUNTS.ignored(username) {
+		clt.ignored.Store(name, true)
+	}
+
 	clt.Status.Store(USER_LOGGED)
-	CLIENTS.Store(clt.Name, clt)
 	CLIENTS.Delete(oldName)
 
 	mainChannel, _ := CHANNELS.Load("#main")
 
 	mainChannel.addClient(clt)
 
+	send_links(nil, "NICK %s %d %s", clt, clt.loggedOn.UnixNano(), clt.home)
+
 	/* Update player */
 
 	clt.Say(">/login>0>you're now %s", clt)
 	clt.UpdateInMain(">!login>%s has joined the server", clt)
 
+	rejoin_channels(clt)
+
 	INFO.Printf("%s has logged in as %s", oldName, clt)
 }
 
@@ -334,12 +376,7 @@ func do_join(clt *Client, args string) {
 	channel, ok := CHANNELS.Load(channelName)
 
 	if ok {
-		if channel.addClient(clt) {
-			channel.Say(clt, "joined the channel")
-			return
-		}
-
-		channel.Say(clt, "unable to join, channel shutting down")
+		join_channel(clt, channel, "joined")
 
 		return
 	}
@@ -356,9 +393,19 @@ func do_join(clt *Client, args string) {
 	NewChannel := newChannel(channelName, false)
 	NewChannel.addClient(clt)
 
-	CHANNELS.Store(NewChannel.Key(), NewChannel)
+	// somebody else may have created it in the meantime, then we join theirs
+	channel, ok = CHANNELS.LoadOrStore(NewChannel.Key(), NewChannel)
+
+	if ok {
+		join_channel(clt, channel, "joined")
+
+		return
+	}
+
 	DEBUG.Printf("adding %s to CHANNELS", NewChannel)
 
+	send_links(nil, "JOIN %s %s %s", NewChannel, clt, NewChannel.hiddenFlag())
+
 	clt.Say(">/join>0>%s joined %s", clt, NewChannel)
 }
 
@@ -381,12 +428,7 @@ func do_hjoin(clt *Client, args string) {
 	channel, ok := CHANNELS.Load(channelName)
 
 	if ok {
-		if channel.addClient(clt) {
-			channel.Say(clt, "hjoined the channel")
-			return
-		}
-
-		channel.Say(clt, "unable to join, hchannel shutting down")
+		join_channel(clt, channel, "hjoined")
 
 		return
 	}
@@ -403,13 +445,35 @@ func do_hjoin(clt *Client, args string) {
 	NewChannel := newChannel(channelName, true)
 	NewChannel.addClient(clt)
 
-	CHANNELS.Store(NewChannel.Key(), NewChannel)
+	// somebody else may have created it in the meantime, then we join theirs
+	channel, ok = CHANNELS.LoadOrStore(NewChannel.Key(), NewChannel)
+
+	if ok {
+		join_channel(clt, channel, "hjoined")
+
+		return
+	}
+
 	DEBUG.Printf("adding %s to CHANNELS", NewChannel)
 
+	send_links(nil, "JOIN %s %s %s", NewChannel, clt, NewChannel.hiddenFlag())
+
 	clt.Say(">/hjoin>0>%s hjoined %s", clt, NewChannel)
 
 }
 
+// add clt to an existing channel and tell everyone in it
+func join_channel(clt *Client, channel *Channel, joined string) {
+
+	if channel.addClient(clt) {
+		send_links(nil, "JOIN %s %s %s", channel, clt, channel.hiddenFlag())
+		channel.Say(clt, "%s the channel", joined)
+		return
+	}
+
+	clt.Say(">%s>%s>unable to join, channel shutting down", channel, clt)
+}
+
 func do_leave(clt *Client, args string) {
 
 	if !clt.isLogged() {
@@ -438,6 +502,8 @@ func do_leave(clt *Client, args string) {
 		channel.Say(clt, "left the channel")
 		channel.removeClient(clt)
 
+		send_links(nil, "LEAVE %s %s", channel, clt)
+
 		return
 	}
 
@@ -470,3 +536,216 @@ func do_list(clt *Client, args string) {
 
 	clt.SayN(">/list>", out)
 }
+
+// show the game servers online in the lobby
+func do_games(clt *Client, args string) {
+
+	if !clt.isLogged() {
+		clt.Say(">/games>0>/games requires you to be logged")
+
+		return
+	}
+
+	var out []string
+
+	for _, server := range online_games() {
+		out = append(out, server.String())
+	}
+
+	if no(out) {
+		clt.Say(">/games>0>no game servers online")
+
+		return
+	}
+
+	clt.SayN(">/games>", out)
+}
+
+// invite a user to play in a game server
+func do_invite(clt *Client, args string) {
+
+	if !clt.isLogged() {
+		clt.Say(">/invite>0>/invite requires you to be logged")
+
+		return
+	}
+
+	username, serverurl := split2(args, " ")
+	serverurl = trim(serverurl)
+
+	if no(username) || no(serverurl) {
+		clt.Say(">/invite>0>/invite @nick <serverurl>")
+
+		return
+	}
+
+	friend, ok := CLIENTS.Load(username)
+
+	if !ok || !friend.isLogged() {
+		clt.Say(">/invite>0>%s is not logged", username)
+		return
+	}
+
+	if !friend.isLocal() {
+		clt.Say(">/invite>0>%s is logged in %s, only users in %s can be invited", friend, friend.home, SRVNAME)
+		return
+	}
+
+	if friend == clt {
+		clt.Say(">/invite>0>you cannot invite yourself")
+		return
+	}
+
+	server, ok := GAMES.Load(serverurl)
+
+	if !ok || !server.isOnline() {
+		clt.Say(">/invite>0>%s is not an online game server, see /games", serverurl)
+		return
+	}
+
+	/* Do command */
+
+	// users ignoring us don't get the invite, but we don't tell
+	if !friend.isIgnoring(clt) {
+		friend.Say(">#main>!invite>%s invites you to %s", clt, server)
+	}
+
+	clt.Say(">/invite>0>%s has been invited to %s", friend, server.Server)
+}
+
+// register the nick of the user with a password
+func do_register(clt *Client, args string) {
+
+	if !clt.isLogged() {
+		clt.Say(">/register>0>/register requires you to be logged")
+
+		return
+	}
+
+	if no(args) {
+		clt.Say(">/register>0>/register <password>")
+
+		return
+	}
+
+	err := ACCOUNTS.register(clt.Name, args, clt.ignoredNames())
+
+	if err != nil {
+		clt.Say(">/register>0>unable to register %s because %s", clt, err.Error())
+		WARN.Printf("user %s unable to register due to: %s", clt, err.Error())
+
+		return
+	}
+
+	send_links(nil, "REG %s %s", clt, ACCOUNTS.passwordHash(clt.Name))
+
+	clt.Say(">/register>0>%s is now registered", clt)
+
+	INFO.Printf("%s has registered", clt)
+}
+
+// stop receiving messages from a user
+func do_ignore(clt *Client, args string) {
+
+	if !clt.isLogged() {
+		clt.Say(">/ignore>0>/ignore requires you to be logged")
+
+		return
+	}
+
+	if no(args) {
+		clt.Say(">/ignore>0>/ignore @nick")
+
+		return
+	}
+
+	username, _ := split2(args, " ")
+
+	username, err := ValidUsername(username)
+
+	if err != nil {
+		clt.Say(">/ignore>0>%s is not a valid username because %s", args, err.Error())
+
+		return
+	}
+
+	if username == clt.Name {
+		clt.Say(">/ignore>0>you cannot ignore yourself")
+
+		return
+	}
+
+	if clt.ignored.Count() >= MAX_IGNORED {
+		clt.Say(">/ignore>0>you cannot ignore more than %d users", MAX_IGNORED)
+
+		return
+	}
+
+	/* Do command */
+
+	clt.ignored.Store(username, true)
+	save_ignored(clt)
+
+	clt.Say(">/ignore>0>you're now ignoring %s", username)
+}
+
+// receive messages from an ignored user again
+func do_unignore(clt *Client, args string) {
+
+	if !clt.isLogged() {
+		clt.Say(">/unignore>0>/unignore requires you to be logged")
+
+		return
+	}
+
+	if no(args) {
+		clt.Say(">/unignore>0>/unignore @nick")
+
+		return
+	}
+
+	username, _ := split2(args, " ")
+
+	if _, ok := clt.ignored.Load(username); !ok {
+		clt.Say(">/unignore>0>you're not ignoring %s", username)
+
+		return
+	}
+
+	/* Do command */
+
+	clt.ignored.Delete(username)
+	save_ignored(clt)
+
+	clt.Say(">/unignore>0>you're no longer ignoring %s", username)
+}
+
+// show the users being ignored
+func do_ignorelist(clt *Client, args string) {
+
+	if !clt.isLogged() {
+		clt.Say(">/ignorelist>0>/ignorelist requires you to be logged")
+
+		return
+	}
+
+	ignored := clt.ignoredNames()
+
+	if no(ignored) {
+		clt.Say(">/ignorelist>0>you're not ignoring anyone")
+
+		return
+	}
+
+	clt.SayN(">/ignorelist>", ignored)
+}
+
+// persist the ignore list if the user is registered
+func save_ignored(clt *Client) {
+
+	err := ACCOUNTS.setIgnored(clt.Name, clt.ignoredNames())
+
+	if err != nil {
+		ERROR.Printf("unable to save the ignore list of %s (%s)", clt, err)
+	}
+}
@@ -0,0 +1,512 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dchest/uniuri"
+)
+
+/*
+	Server to server links.
+
+	Linked servers share users, channels, messages, registered nicks and
+	server events, so users connected to any of them chat together.
+	Links must form a tree: every line received from a link is applied
+	locally and forwarded to all the other links.
+
+	A link is a tcp line based protocol. Both ends start sending
+
+	 HELLO <srvname> <nonce>
+
+	then the server that dialed proves it knows the shared secret with
+
+	 AUTH <hex hmac-sha256(linkpass, "dial <accepting nonce> <dialing nonce> <dialing srvname> <accepting srvname>")>
+
+	and only once it is checked the server that accepted answers with
+
+	 AUTH <hex hmac-sha256(linkpass, "accept <accepting nonce> <dialing nonce> <dialing srvname> <accepting srvname>")>
+
+	The role, both nonces and both names are signed, so an AUTH cannot be
+	reflected back or replayed in another link, and the server that accepts
+	links never signs anything for a peer that has not authenticated.
+
+	After both ends are authenticated they send everything they know (burst)
+	and from then on every change:
+
+	 NICK @name <logged since, unix nano> <home srvname>
+	 QUIT @name <home srvname>
+	 JOIN #channel @name <1 if hidden, 0 otherwise>
+	 LEAVE #channel @name
+	 SAY #channel @name text
+	 EVENT >!event>text
+	 REG @name <bcrypt hash>
+*/
+
+const (
+	LINK_HANDSHAKE_TIMEOUT = 10 * time.Second // max time to authenticate a link
+	LINK_RETRY_INTERVAL    = 10 * time.Second // wait before reconnecting an outgoing link
+)
+
+// A link to another server
+type Link struct {
+	conn       net.Conn
+	reader     *bufio.Reader
+	Name       string // Name of the server at the other end
+	sync.Mutex        // one line at a time
+}
+
+var (
+	SRVNAME  string // name of this server in the links
+	LINKPASS string // shared secret to authenticate the links
+)
+
+func (l *Link) String() string {
+	return l.Name
+}
+
+// start listening for links in linkaddr and connect to every server in links
+// (comma separated). Any of them can be empty.
+func init_links(linkaddr string, links string) error {
+
+	if no(linkaddr) && no(links) {
+		return nil
+	}
+
+	if no(LINKPASS) {
+		return errors.New("server links require -linkpass")
+	}
+
+	if len(linkaddr) > 0 {
+		listener, err := net.Listen("tcp4", linkaddr)
+		if err != nil {
+			return err
+		}
+
+		INFO.Printf("Ready to link with other servers on tcp://%s as %s", linkaddr, SRVNAME)
+
+		go serve_links(listener)
+	}
+
+	for _, addr := range strings.Split(links, ",") {
+		if addr = trim(addr); len(addr) > 0 {
+			go dial_link(addr)
+		}
+	}
+
+	return nil
+}
+
+// accept links until the listener is closed
+func serve_links(listener net.Listener) {
+
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			WARN.Printf("Unable to accept link on %s (%s)", listener.Addr(), err)
+			continue
+		}
+
+		go func() {
+			if err := runLink(conn, false); err != nil {
+				WARN.Printf("link from %s closed (%s)", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// keep an outgoing link to addr, reconnecting when it drops
+func dial_link(addr string) {
+
+	for {
+		conn, err := net.DialTimeout("tcp4", addr, LINK_HANDSHAKE_TIMEOUT)
+
+		if err == nil {
+			err = runLink(conn, true)
+		}
+
+		WARN.Printf("link to %s closed (%s), retrying in %s", addr, err, LINK_RETRY_INTERVAL)
+
+		time.Sleep(LINK_RETRY_INTERVAL)
+	}
+}
+
+// authenticate conn, share the state and process its lines until it drops.
+// dialed is true when we connected to the other end.
+func runLink(conn net.Conn, dialed bool) error {
+	defer conn.Close()
+
+	link := &Link{conn: conn, reader: bufio.NewReader(conn)}
+
+	err := link.handshake(dialed)
+	if err != nil {
+		return err
+	}
+
+	if _, loaded := LINKS.LoadOrStore(link.Name, link); loaded {
+		return fmt.Errorf("%s is already linked", link.Name)
+	}
+
+	INFO.Printf("linked with %s (%s)", link, conn.RemoteAddr())
+
+	link.burst()
+
+	for {
+		line, err := link.reader.ReadString('\n')
+		if err != nil {
+			link.drop()
+			return err
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+
+		if no(line) {
+			continue
+		}
+
+		if link.apply(line) {
+			send_links(link, "%s", line)
+		}
+	}
+}
+
+// sign the handshake of a link with the shared secret. role is the one of the
+// signing server: "dial" or "accept".
+func linkSignature(secret string, role string, acceptNonce string, dialNonce string, dialName string, acceptName string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strings.Join([]string{role, acceptNonce, dialNonce, dialName, acceptName}, " ")))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// both ends prove they know the shared secret, the dialing one first
+func (link *Link) handshake(dialed bool) error {
+
+	link.conn.SetDeadline(time.Now().Add(LINK_HANDSHAKE_TIMEOUT))
+	defer link.conn.SetDeadline(time.Time{})
+
+	nonce := uniuri.NewLen(uniuri.UUIDLen)
+
+	link.send("HELLO %s %s", SRVNAME, nonce)
+
+	fields, err := link.expect("HELLO", 3)
+	if err != nil {
+		return err
+	}
+
+	if fields[1] == SRVNAME {
+		return fmt.Errorf("%s is our own name", fields[1])
+	}
+
+	if fields[2] == nonce {
+		return fmt.Errorf("%s reflected our nonce", fields[1])
+	}
+
+	link.Name = fields[1]
+
+	// what each end signs
+	var ours, theirs string
+	if dialed {
+		ours = linkSignature(LINKPASS, "dial", fields[2], nonce, SRVNAME, link.Name)
+		theirs = linkSignature(LINKPASS, "accept", fields[2], nonce, SRVNAME, link.Name)
+
+		link.send("AUTH %s", ours)
+	} else {
+		ours = linkSignature(LINKPASS, "accept", nonce, fields[2], link.Name, SRVNAME)
+		theirs = linkSignature(LINKPASS, "dial", nonce, fields[2], link.Name, SRVNAME)
+	}
+
+	fields, err = link.expect("AUTH", 2)
+	if err != nil {
+		return err
+	}
+
+	if !hmac.Equal([]byte(fields[1]), []byte(theirs)) {
+		return fmt.Errorf("%s failed to authenticate", link)
+	}
+
+	if !dialed {
+		link.send("AUTH %s", ours)
+	}
+
+	return nil
+}
+
+// read a handshake line: command followed by its arguments, count fields in total
+func (link *Link) expect(command string, count int) ([]string, error) {
+
+	line, err := link.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) != count || fields[0] != command {
+		return nil, fmt.Errorf("expected %s, got %q", command, trim(line))
+	}
+
+	return fields, nil
+}
+
+// send a line to this link
+func (link *Link) send(format string, args ...interface{}) {
+	link.Lock()
+	defer link.Unlock()
+
+	_, err := link.conn.Write([]byte(fmt.Sprintf(format, args...) + "\n"))
+
+	if err != nil {
+		DEBUG.Printf("link %s.send() failed with err: %s", link, err)
+	}
+}
+
+// send a line to every link but from. from == nil sends it to all of them
+func send_links(from *Link, format string, args ...interface{}) {
+
+	send := func(name string, link *Link) bool {
+		if link != from {
+			link.send(format, args...)
+		}
+		return true
+	}
+
+	LINKS.Range(send)
+}
+
+// tell the new link everything we know: users, channels and accounts
+func (link *Link) burst() {
+
+	var users []*Client
+
+	CLIENTS.Range(func(key string, clt *Client) bool {
+		if clt.isLogged() && clt.link != link {
+			users = append(users, clt)
+		}
+		return true
+	})
+
+	for _, clt := range users {
+		link.send("NICK %s %d %s", clt, clt.loggedOn.UnixNano(), clt.home)
+	}
+
+	CHANNELS.Range(func(key string, channel *Channel) bool {
+		if key == "#main" {
+			return true
+		}
+
+		for _, clt := range channel.members() {
+			if clt.isLogged() && clt.link != link {
+				link.send("JOIN %s %s %s", channel, clt, channel.hiddenFlag())
+			}
+		}
+		return true
+	})
+
+	for _, account := range ACCOUNTS.list() {
+		link.send("REG %s %s", account.Name, account.Password)
+	}
+}
+
+// apply a line received from the link. Returns if it has to be forwarded.
+func (link *Link) apply(line string) bool {
+
+	command, args := split2(line, " ")
+
+	switch command {
+
+	case "NICK":
+		fields := strings.Fields(args)
+		if len(fields) != 3 {
+			break
+		}
+
+		since, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			break
+		}
+
+		return link.nick(fields[0], time.Unix(0, since), fields[2])
+
+	case "QUIT":
+		name, home := split2(args, " ")
+
+		clt, ok := CLIENTS.Load(name)
+		if !ok || clt.link == nil || clt.home != home {
+			return false
+		}
+
+		clt.Kill()
+
+		return true
+
+	case "JOIN":
+		fields := strings.Fields(args)
+		if len(fields) != 3 {
+			break
+		}
+
+		clt, ok := CLIENTS.Load(fields[1])
+		if !ok || clt.link != link {
+			return false
+		}
+
+		channel, _ := CHANNELS.LoadOrStore(fields[0], newChannel(fields[0], fields[2] == "1"))
+
+		if !channel.contains(clt) && !channel.addClient(clt) {
+			// this copy was shutting down, start a new one
+			channel = newChannel(fields[0], fields[2] == "1")
+			channel.addClient(clt)
+			CHANNELS.Store(channel.Key(), channel)
+		}
+
+		return true
+
+	case "LEAVE":
+		channelName, name := split2(args, " ")
+
+		clt, ok := CLIENTS.Load(name)
+		channel, found := CHANNELS.Load(channelName)
+
+		if !ok || !found || clt.link != link {
+			return false
+		}
+
+		channel.removeClient(clt)
+
+		return true
+
+	case "SAY":
+		channelName, rest := split2(args, " ")
+		name, message := split2(rest, " ")
+
+		clt, ok := CLIENTS.Load(name)
+		channel, found := CHANNELS.Load(channelName)
+
+		if !ok || !found || clt.link != link || !channel.contains(clt) {
+			return false
+		}
+
+		channel.Say(clt, "%s", message)
+
+		return true
+
+	case "EVENT":
+		update_local_clients("%s", args)
+
+		return true
+
+	case "REG":
+		name, hash := split2(args, " ")
+
+		return ACCOUNTS.add(name, hash)
+	}
+
+	WARN.Printf("ignoring invalid line from link %s: %q", link, line)
+
+	return false
+}
+
+// a user logged in a linked server. If the nick is already in use, the first
+// to login keeps it (and the server name breaks ties) in all the servers.
+func (link *Link) nick(name string, since time.Time, home string) bool {
+
+	if _, err := ValidUsername(name); err != nil {
+		return false
+	}
+
+	remote := &Client{
+		Name:     name,
+		link:     link,
+		home:     home,
+		loggedOn: since,
+	}
+	remote.Status.Store(USER_LOGGED)
+
+	for {
+		existing, taken := CLIENTS.LoadOrStore(name, remote)
+
+		if !taken {
+			break
+		}
+
+		if !existing.isLogged() || nickWins(existing, remote) {
+			if existing.isLogged() {
+				WARN.Printf("nick collision: %s from %s keeps the nick over %s", existing, existing.home, home)
+			}
+
+			return false
+		}
+
+		WARN.Printf("nick collision: %s from %s keeps the nick over %s", name, home, existing.home)
+
+		// the other one wins, we drop ours
+		if existing.link == nil {
+			existing.Say(">#main>!collision>%s is also logged in %s since before, please reconnect and /login with another nick", existing, home)
+		}
+
+		existing.Kill()
+	}
+
+	mainChannel, _ := CHANNELS.Load("#main")
+	mainChannel.addClient(remote)
+
+	return true
+}
+
+// send a server event (>!event>text) to everyone connected to this server
+func update_local_clients(format string, args ...interface{}) {
+
+	line := fmt.Sprintf(format, args...)
+
+	broadcast := func(key string, client *Client) bool {
+		client.write(">#main" + line + "\n") // no-op for users in linked servers
+		return true
+	}
+
+	CLIENTS.Range(broadcast)
+}
+
+// decide if a keeps the nick over b
+func nickWins(a *Client, b *Client) bool {
+
+	if !a.loggedOn.Equal(b.loggedOn) {
+		return a.loggedOn.Before(b.loggedOn)
+	}
+
+	return a.home < b.home
+}
+
+// the link dropped, all the users behind it are gone
+func (link *Link) drop() {
+
+	LINKS.Delete(link.Name)
+
+	var gone []*Client
+
+	CLIENTS.Range(func(key string, clt *Client) bool {
+		if clt.link == link {
+			gone = append(gone, clt)
+		}
+		return true
+	})
+
+	for _, clt := range gone {
+		clt.Kill()
+		send_links(link, "QUIT %s %s", clt, clt.home)
+		update_local_clients(">!disconnect>%s disconnected (%s split)", clt, link)
+	}
+
+	WARN.Printf("link with %s lost, %d users gone", link, len(gone))
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// a scripted linked server
+type testPeer struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// start accepting links and return the address. We are server "us".
+func startTestLinks(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen on an ephemeral port: %s", err)
+	}
+
+	go serve_links(listener)
+
+	t.Cleanup(func() { listener.Close() })
+
+	return listener.Addr().String()
+}
+
+// connect to addr and return the HELLO nonce of "us"
+func connectPeer(t *testing.T, addr string) (*testPeer, string) {
+	t.Helper()
+
+	conn, err := net.Dial("tcp4", addr)
+	if err != nil {
+		t.Fatalf("unable to connect to %s: %s", addr, err)
+	}
+
+	t.Cleanup(func() { conn.Close() })
+
+	peer := &testPeer{t: t, conn: conn, reader: bufio.NewReader(conn)}
+
+	hello := strings.Fields(peer.readLine())
+	if len(hello) != 3 || hello[0] != "HELLO" || hello[1] != "us" {
+		t.Fatalf("unexpected hello %v", hello)
+	}
+
+	return peer, hello[2]
+}
+
+// connect as server name, authenticating with secret. Only a peer with the
+// right secret gets the AUTH of "us" back.
+func dialPeer(t *testing.T, addr string, name string, secret string) *testPeer {
+	t.Helper()
+
+	peer, nonce := connectPeer(t, addr)
+
+	peer.send("HELLO %s %s", name, "nonce")
+
+	// sign with the secret we were given, not necessarily the right one
+	peer.send("AUTH %s", linkSignature(secret, "dial", nonce, "nonce", name, "us"))
+
+	if secret == LINKPASS {
+		if auth := peer.readLine(); auth != "AUTH "+linkSignature(LINKPASS, "accept", nonce, "nonce", name, "us") {
+			t.Errorf("unexpected auth %q", auth)
+		}
+	}
+
+	return peer
+}
+
+// expectClosed checks the link was closed without an answer
+func (peer *testPeer) expectClosed(name string) {
+	peer.t.Helper()
+
+	peer.conn.SetReadDeadline(time.Now().Add(E2E_TIMEOUT))
+
+	if line, err := peer.reader.ReadString('\n'); err == nil {
+		peer.t.Errorf("%s should be closed, got %q", name, line)
+	}
+}
+
+func (peer *testPeer) send(format string, args ...interface{}) {
+	fmt.Fprintf(peer.conn, format+"\n", args...)
+}
+
+func (peer *testPeer) readLine() string {
+	peer.conn.SetReadDeadline(time.Now().Add(E2E_TIMEOUT))
+
+	line, err := peer.reader.ReadString('\n')
+	if err != nil {
+		peer.t.Errorf("peer expected a line, got error %s", err)
+		return ""
+	}
+
+	return strings.TrimSuffix(line, "\n")
+}
+
+func (peer *testPeer) expect(expected ...string) {
+	peer.t.Helper()
+
+	for _, ex := range expected {
+		if got := peer.readLine(); got != ex {
+			peer.t.Errorf("peer got %q, expected %q", got, ex)
+		}
+	}
+}
+
+func TestLinkAuthentication(t *testing.T) {
+	startTestServer(t)
+	addr := startTestLinks(t)
+
+	peer := dialPeer(t, addr, "eu", "wrong")
+	peer.expectClosed("link with a wrong secret")
+
+	if _, ok := LINKS.Load("eu"); ok {
+		t.Errorf("eu should not be linked")
+	}
+}
+
+func TestLinkReplay(t *testing.T) {
+	startTestServer(t)
+	addr := startTestLinks(t)
+
+	// our own nonce reflected back in the HELLO
+	reflected, nonce := connectPeer(t, addr)
+	reflected.send("HELLO fr %s", nonce)
+	reflected.expectClosed("link reflecting our nonce")
+
+	// an AUTH seen in a link does not authenticate another one
+	seen, nonce := connectPeer(t, addr)
+	seen.send("HELLO fr nonce")
+	auth := linkSignature(LINKPASS, "dial", nonce, "nonce", "fr", "us")
+	seen.send("AUTH %s", auth)
+	seen.readLine()
+
+	replayed, _ := connectPeer(t, addr)
+	replayed.send("HELLO fr nonce")
+	replayed.send("AUTH %s", auth)
+	replayed.expectClosed("link replaying an AUTH")
+
+	// the AUTH of a dialing server cannot be reflected back to it
+	ours, theirs := net.Pipe()
+	defer theirs.Close()
+
+	result := make(chan error, 1)
+	go func() { result <- runLink(ours, true) }()
+
+	reader := bufio.NewReader(theirs)
+	reader.ReadString('\n')
+	fmt.Fprintf(theirs, "HELLO de nonce\n")
+
+	line, _ := reader.ReadString('\n')
+	fmt.Fprint(theirs, line)
+
+	if err := <-result; err == nil || !strings.Contains(err.Error(), "failed to authenticate") {
+		t.Errorf("reflected AUTH got %v, expected it to fail", err)
+	}
+
+	if _, ok := LINKS.Load("de"); ok {
+		t.Errorf("de should not be linked")
+	}
+}
+
+func TestLinkFederation(t *testing.T) {
+	srvaddr := startTestServer(t)
+	addr := startTestLinks(t)
+
+	resetAccounts()
+
+	alice := dial(t, srvaddr)
+	alice.login("@alice")
+
+	alice.send("/join #room")
+	alice.expect(">/join>0>@alice joined #room")
+
+	bob := dial(t, srvaddr)
+	bob.login("@bob")
+	alice.expect(">#main>!login>@bob has joined the server")
+
+	// the burst tells eu about our users and channels
+	peer := dialPeer(t, addr, "eu", "secret")
+
+	burst := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		burst[strings.Join(strings.Fields(peer.readLine())[0:2], " ")] = true
+	}
+
+	for _, line := range []string{"NICK @alice", "NICK @bob", "JOIN #room"} {
+		if !burst[line] {
+			t.Errorf("burst %v is missing %s", burst, line)
+		}
+	}
+
+	// a user in eu logs in and joins #room
+	peer.send("NICK @pierre %d eu", time.Now().UnixNano())
+	peer.send("EVENT >!login>@pierre has joined the server")
+	alice.expect(">#main>!login>@pierre has joined the server")
+	bob.expect(">#main>!login>@pierre has joined the server")
+
+	peer.send("JOIN #room @pierre 0")
+	peer.send("SAY #room @pierre joined the channel")
+	alice.expect(">#room>@pierre>joined the channel")
+
+	alice.send("/users #room")
+	alice.expect(">/users #room>1>@alice", ">/users #room>0>@pierre")
+
+	peer.send("SAY #room @pierre bonjour")
+	alice.expect(">#room>@pierre>bonjour")
+
+	alice.send("#room hello pierre")
+	alice.expect(">#room>@alice>hello pierre")
+	peer.expect("SAY #room @alice hello pierre")
+
+	// nobody in this server can take a nick logged in eu
+	carol := dial(t, srvaddr)
+	carol.send("/login @pierre")
+	carol.expect(">/login>0>@pierre is already taken, please select another @name")
+
+	carol.login("@carol")
+	peer.expect(
+		fmt.Sprintf("NICK @carol %d us", mustClient(t, "@carol").loggedOn.UnixNano()),
+		"EVENT >!login>@carol has joined the server")
+	alice.expect(">#main>!login>@carol has joined the server")
+	bob.expect(">#main>!login>@carol has joined the server")
+
+	// registrations are shared
+	carol.send("/register secret")
+	carol.expect(">/register>0>@carol is now registered")
+	peer.expect("REG @carol " + ACCOUNTS.passwordHash("@carol"))
+
+	peer.send("REG @pierre $2a$10$hash")
+	eventually(t, "@pierre registration should be shared", func() bool { return ACCOUNTS.isRegistered("@pierre") })
+
+	// nick collision: a @bob logged in eu later loses, a @alice logged before wins
+	peer.send("NICK @bob %d eu", time.Now().UnixNano())
+	peer.send("NICK @alice %d eu", time.Unix(0, 0).UnixNano())
+	alice.expect(">#main>!collision>@alice is also logged in eu since before, please reconnect and /login with another nick")
+	alice.expectClosed()
+
+	if clt := mustClient(t, "@bob"); !clt.isLocal() {
+		t.Errorf("@bob should still be the local one")
+	}
+
+	if clt := mustClient(t, "@alice"); clt.isLocal() || clt.home != "eu" {
+		t.Errorf("@alice should be the one in eu")
+	}
+
+	// eu users can quit, but not ours
+	peer.send("QUIT @bob eu")
+	peer.send("QUIT @pierre eu")
+	eventually(t, "@pierre should quit", func() bool { _, ok := CLIENTS.Load("@pierre"); return !ok })
+
+	expectUsers(bob, "@alice", "@bob", "@carol")
+
+	// a netsplit drops every user behind the link
+	peer.conn.Close()
+	bob.expect(">#main>!disconnect>@alice disconnected (eu split)")
+	carol.expect(">#main>!disconnect>@alice disconnected (eu split)")
+
+	expectUsers(bob, "@bob", "@carol")
+
+	bob.send("/logoff")
+	bob.expect(">/logoff>0>Goodbye @bob")
+	carol.expect(">#main>!logoff>@bob is leaving")
+	carol.send("/logoff")
+	carol.expect(">/logoff>0>Goodbye @carol")
+}
+
+// wait for a condition that depends on lines sent by the peer
+func eventually(t *testing.T, message string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(E2E_TIMEOUT)
+
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal(message)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// /users counts down in no particular order, compare the sorted names
+func expectUsers(clt *e2eClient, expected ...string) {
+	clt.t.Helper()
+
+	clt.send("/users")
+
+	var users []string
+	for {
+		line := clt.readLine()
+		if line == "" {
+			return // readLine already failed the test
+		}
+
+		fields := strings.SplitN(line, ">", 4)
+		if len(fields) != 4 || fields[1] != "/users" {
+			clt.t.Errorf("%s got unexpected %q", clt.name, line)
+			continue
+		}
+
+		users = append(users, fields[3])
+		if fields[2] == "0" {
+			break
+		}
+	}
+
+	sort.Strings(users)
+
+	if strings.Join(users, " ") != strings.Join(expected, " ") {
+		clt.t.Errorf("%s got users %v, expected %v", clt.name, users, expected)
+	}
+}
+
+func mustClient(t *testing.T, name string) *Client {
+	t.Helper()
+
+	clt, ok := CLIENTS.Load(name)
+	if !ok {
+		t.Fatalf("%s should be in CLIENTS", name)
+	}
+
+	return clt
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/madflojo/tasks"
+)
+
+// game servers as reported by the FujiNet lobby
+const (
+	LOBBY_POLL_INTERVAL = 30 * time.Second // how often we ask the lobby for the game servers
+	LOBBY_HTTP_TIMEOUT  = 10 * time.Second // max time to wait for the lobby to answer
+	LOBBY_EVENT_MAXSIZE = 64 * 1024        // max size of a webhook event
+)
+
+// with a shared secret, every webhook event must be signed: the header holds
+// the hex HMAC-SHA256 of the body. Without one, unsigned events are taken,
+// but only on a webhook that listens on localhost.
+const LOBBY_SIGNATURE_HEADER = "X-Lobby-Signature"
+
+var EVTSECRET string // shared secret to authenticate the lobby webhook
+
+// GameServer mirrors the server description published by the FujiNet lobby
+// (/viewFull and the event webhook).
+type GameServer struct {
+	Game       string       `json:"game"`
+	Appkey     int          `json:"appkey"`
+	Server     string       `json:"server"`
+	Region     string       `json:"region"`
+	Serverurl  string       `json:"serverurl"`
+	Status     string       `json:"status"`
+	Maxplayers int          `json:"maxplayers"`
+	Curplayers int          `json:"curplayers"`
+	Clients    []GameClient `json:"clients"`
+}
+
+type GameClient struct {
+	Platform string `json:"platform"`
+	Url      string `json:"url"`
+}
+
+// sent by the lobby webhook when a server is deleted
+type GameServerDelete struct {
+	Serverurl string `json:"serverurl"`
+}
+
+// true once we have a first picture of the lobby, so we don't announce every
+// table with players when the server starts.
+var LOBBY_PRIMED atomic.Bool
+
+// return the key to index the game server
+func (s *GameServer) Key() string {
+	return s.Serverurl
+}
+
+func (s *GameServer) isOnline() bool {
+	return s.Status == "online"
+}
+
+// one line description of the game server, used by /games and the events
+func (s *GameServer) String() string {
+	return fmt.Sprintf("%s - %s (%d/%d) %s", s.Game, s.Server, s.Curplayers, s.Maxplayers, s.Serverurl)
+}
+
+// start polling the lobby and/or listening to its event webhook.
+// Any of them can be empty and then that part is not started.
+func init_lobby(lobbyurl string, evtaddr string) error {
+
+	if len(lobbyurl) > 0 {
+		_, err := SCHEDULER.Add(&tasks.Task{
+			Interval: LOBBY_POLL_INTERVAL,
+			TaskFunc: poll_lobby(lobbyurl),
+			ErrFunc: func(err error) {
+				WARN.Printf("unable to poll the lobby at %s (%s)", lobbyurl, err)
+			},
+		})
+
+		if err != nil {
+			return err
+		}
+
+		INFO.Printf("%s will be polled for game servers every %s", lobbyurl, LOBBY_POLL_INTERVAL)
+	}
+
+	if len(evtaddr) > 0 {
+		// only the local lobby can reach the webhook unless a host is given
+		host, port, err := net.SplitHostPort(evtaddr)
+		if err != nil {
+			return err
+		}
+		if no(host) {
+			host = "127.0.0.1"
+			evtaddr = net.JoinHostPort(host, port)
+		}
+
+		if no(EVTSECRET) && !is_loopback(host) {
+			return errors.New("the lobby webhook requires -evtsecret unless it listens on localhost")
+		}
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/", lobby_webhook)
+
+		go func() {
+			err := http.ListenAndServe(evtaddr, mux)
+			ERROR.Printf("lobby webhook on http://%s stopped (%s)", evtaddr, err)
+		}()
+
+		// with a webhook every update counts
+		LOBBY_PRIMED.Store(true)
+
+		INFO.Printf("Ready to receive lobby events on http://%s", evtaddr)
+	}
+
+	return nil
+}
+
+// returns a task that reads all the game servers from the lobby
+func poll_lobby(lobbyurl string) func() error {
+
+	client := &http.Client{Timeout: LOBBY_HTTP_TIMEOUT}
+
+	return func() error {
+
+		resp, err := client.Get(lobbyurl)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		var servers []GameServer
+
+		switch resp.StatusCode {
+		case http.StatusOK:
+			err = json.NewDecoder(resp.Body).Decode(&servers)
+			if err != nil {
+				return err
+			}
+		case http.StatusNotFound: // the lobby answers 404 when there are no servers
+		default:
+			return fmt.Errorf("lobby answered %s", resp.Status)
+		}
+
+		update_games(servers, LOBBY_PRIMED.Load())
+		LOBBY_PRIMED.Store(true)
+
+		return nil
+	}
+}
+
+// replace the known game servers with servers
+func update_games(servers []GameServer, announce bool) {
+
+	seen := make(map[string]bool)
+
+	for i := range servers {
+		update_game(&servers[i], announce)
+		seen[servers[i].Key()] = true
+	}
+
+	remove := func(key string, server *GameServer) bool {
+		if !seen[key] {
+			GAMES.Delete(key)
+		}
+		return true
+	}
+
+	GAMES.Range(remove)
+}
+
+// store a game server and tell #main if a table gained players
+func update_game(server *GameServer, announce bool) {
+
+	if no(server.Serverurl) {
+		return
+	}
+
+	previous := 0
+
+	old, ok := GAMES.Load(server.Key())
+	if ok && old.isOnline() {
+		previous = old.Curplayers
+	}
+
+	GAMES.Store(server.Key(), server)
+
+	if !announce || !server.isOnline() || server.Curplayers <= previous {
+		return
+	}
+
+	mainChannel, ok := CHANNELS.Load("#main")
+	if !ok {
+		return
+	}
+
+	mainChannel.Event("game", "%s", server)
+}
+
+// return the signature of a webhook event body
+func lobbySignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// true if the host only takes connections from this machine
+func is_loopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// receives the lobby event webhook: POST for updates and DELETE for removals
+func lobby_webhook(w http.ResponseWriter, r *http.Request) {
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, LOBBY_EVENT_MAXSIZE))
+	if err != nil {
+		http.Error(w, "invalid event", http.StatusBadRequest)
+		return
+	}
+
+	signature := r.Header.Get(LOBBY_SIGNATURE_HEADER)
+	if !no(EVTSECRET) && !hmac.Equal([]byte(signature), []byte(lobbySignature(EVTSECRET, body))) {
+		WARN.Printf("lobby event from %s with an invalid signature", r.RemoteAddr)
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+
+	case http.MethodPost:
+		server := GameServer{}
+
+		err := json.Unmarshal(body, &server)
+		if err != nil || no(server.Serverurl) {
+			WARN.Printf("invalid game server received from the lobby (%v)", err)
+			http.Error(w, "invalid game server", http.StatusBadRequest)
+			return
+		}
+
+		update_game(&server, LOBBY_PRIMED.Load())
+
+	case http.MethodDelete:
+		server := GameServerDelete{}
+
+		err := json.Unmarshal(body, &server)
+		if err != nil || no(server.Serverurl) {
+			WARN.Printf("invalid game server deletion received from the lobby (%v)", err)
+			http.Error(w, "invalid game server", http.StatusBadRequest)
+			return
+		}
+
+		GAMES.Delete(server.Serverurl)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// return the online game servers, sorted with the busiest tables first
+func online_games() (games []*GameServer) {
+
+	collect := func(key string, server *GameServer) bool {
+		if server.isOnline() {
+			games = append(games, server)
+		}
+		return true
+	}
+
+	GAMES.Range(collect)
+
+	sort.SliceStable(games, func(i, j int) bool {
+		if games[i].Curplayers != games[j].Curplayers {
+			return games[i].Curplayers > games[j].Curplayers
+		}
+		return games[i].Serverurl < games[j].Serverurl
+	})
+
+	return games
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type testConn struct {
+	out net.Conn
+	in  *bufio.Reader
+}
+
+// readWhile reads from all conns while action runs, so a write to a client
+// that is not the one sending the command does not block the net.Pipe
+func readWhile(action func(), conns ...testConn) [][]string {
+	rets := make([]chan []string, len(conns))
+
+	for i, conn := range conns {
+		rets[i] = make(chan []string)
+		go fullRead(conn.in, conn.out, rets[i])
+	}
+
+	action()
+
+	res := make([][]string, len(conns))
+	for i := range rets {
+		res[i] = <-rets[i]
+	}
+
+	return res
+}
+
+// webhookRequest returns a lobby event signed with secret
+func webhookRequest(method string, body string, secret string) *http.Request {
+	req := httptest.NewRequest(method, "/", strings.NewReader(body))
+	req.Header.Set(LOBBY_SIGNATURE_HEADER, lobbySignature(secret, []byte(body)))
+	return req
+}
+
+// TestGameInvites checks the lobby webhook, the !game event, /games and /invite
+func TestGameInvites(t *testing.T) {
+	initTestWorld()
+	init_channels()
+	LOBBY_PRIMED.Store(true)
+	EVTSECRET = "secret"
+
+	_, out1, in1 := genClient()
+	_, out2, in2 := genClient()
+	gamer1 := testConn{out1, in1}
+	gamer2 := testConn{out2, in2}
+
+	readWhile(func() { out1.Write([]byte("/login @gamer1\n")) }, gamer1, gamer2)
+	readWhile(func() { out2.Write([]byte("/login @gamer2\n")) }, gamer1, gamer2)
+
+	url := "https://5card.carr-designs.com/?table=basement"
+	body := `{"game":"5 Card Stud","appkey":1,"server":"The Basement","region":"us","serverurl":"` + url +
+		`","status":"online","maxplayers":8,"curplayers":1,"clients":[]}`
+	event := ">#main>!game>5 Card Stud - The Basement (1/8) " + url
+
+	rec := httptest.NewRecorder()
+	res := readWhile(func() {
+		lobby_webhook(rec, webhookRequest(http.MethodPost, body, EVTSECRET))
+	}, gamer1, gamer2)
+
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("webhook answered %d, expected %d", rec.Code, http.StatusNoContent)
+	}
+
+	for _, lines := range res {
+		if len(lines) != 1 || lines[0] != event {
+			t.Errorf("game event got %v, expected %s", lines, event)
+		}
+	}
+
+	// same number of players, no event
+	res = readWhile(func() {
+		lobby_webhook(httptest.NewRecorder(), webhookRequest(http.MethodPost, body, EVTSECRET))
+	}, gamer1, gamer2)
+
+	if len(res[0]) != 0 || len(res[1]) != 0 {
+		t.Errorf("repeated update got %v, expected no event", res)
+	}
+
+	invite := ">#main>!invite>@gamer1 invites you to 5 Card Stud - The Basement (1/8) " + url
+
+	clientTests := []struct {
+		name      string
+		input     string
+		expected  []string
+		expected2 []string // what @gamer2 receives
+	}{
+		{"Games Test", "/games\n", []string{">/games>0>5 Card Stud - The Basement (1/8) " + url}, nil},
+		{"Invite Help Test", "/invite @gamer2\n", []string{">/invite>0>/invite @nick <serverurl>"}, nil},
+		{"Invite Unknown User Test", "/invite @nobody " + url + "\n", []string{">/invite>0>@nobody is not logged"}, nil},
+		{"Invite Myself Test", "/invite @gamer1 " + url + "\n", []string{">/invite>0>you cannot invite yourself"}, nil},
+		{"Invite Unknown Server Test", "/invite @gamer2 https://nowhere\n", []string{">/invite>0>https://nowhere is not an online game server, see /games"}, nil},
+		{"Invite Test", "/invite @gamer2 " + url + "\n", []string{">/invite>0>@gamer2 has been invited to The Basement"}, []string{invite}},
+	}
+
+	for _, test := range clientTests {
+		res := readWhile(func() { out1.Write([]byte(test.input)) }, gamer1, gamer2)
+
+		if strings.Join(res[0], "\n") != strings.Join(test.expected, "\n") {
+			t.Errorf("%s got %v, expected %v", test.name, res[0], test.expected)
+		}
+
+		if strings.Join(res[1], "\n") != strings.Join(test.expected2, "\n") {
+			t.Errorf("%s sent %v to @gamer2, expected %v", test.name, res[1], test.expected2)
+		}
+	}
+
+	readWhile(func() {
+		lobby_webhook(httptest.NewRecorder(), webhookRequest(http.MethodDelete, `{"serverurl":"`+url+`"}`, EVTSECRET))
+	}, gamer1, gamer2)
+
+	res = readWhile(func() { out1.Write([]byte("/games\n")) }, gamer1)
+
+	if len(res[0]) != 1 || res[0][0] != ">/games>0>no game servers online" {
+		t.Errorf("games after delete got %v", res[0])
+	}
+
+	readWhile(func() { out1.Write([]byte("/logoff\n")) }, gamer1, gamer2)
+	readWhile(func() { out2.Write([]byte("/logoff\n")) }, gamer2)
+}
+
+// TestWebhookSignature checks that only events signed with the secret are accepted
+func TestWebhookSignature(t *testing.T) {
+	initTestWorld()
+	init_channels()
+	EVTSECRET = "secret"
+
+	url := "https://5card.carr-designs.com/?table=fake"
+	body := `{"game":"5 Card Stud","server":"Fake","serverurl":"` + url + `","status":"online","maxplayers":8,"curplayers":8}`
+
+	unsigned := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	tampered := webhookRequest(http.MethodPost, body, EVTSECRET)
+	tampered.Body = io.NopCloser(strings.NewReader(strings.Replace(body, "Fake", "Spam", 1)))
+
+	requests := []struct {
+		name string
+		req  *http.Request
+	}{
+		{"Unsigned Test", unsigned},
+		{"Wrong Secret Test", webhookRequest(http.MethodPost, body, "guess")},
+		{"Tampered Body Test", tampered},
+		{"Unsigned Delete Test", httptest.NewRequest(http.MethodDelete, "/", strings.NewReader(`{"serverurl":"`+url+`"}`))},
+	}
+
+	for _, test := range requests {
+		rec := httptest.NewRecorder()
+		lobby_webhook(rec, test.req)
+
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s answered %d, expected %d", test.name, rec.Code, http.StatusUnauthorized)
+		}
+	}
+
+	if _, ok := GAMES.Load(url); ok {
+		t.Errorf("unsigned event stored the game server")
+	}
+
+	// without a secret, unsigned events are only taken on localhost
+	EVTSECRET = ""
+	if err := init_lobby("", "0.0.0.0:0"); err == nil {
+		t.Errorf("webhook started on every address without -evtsecret")
+	}
+	if err := init_lobby("", ":0"); err != nil {
+		t.Errorf("webhook on localhost did not start without -evtsecret (%s)", err)
+	}
+
+	rec := httptest.NewRecorder()
+	lobby_webhook(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+	if _, ok := GAMES.Load(url); rec.Code != http.StatusNoContent || !ok {
+		t.Errorf("unsigned event without a secret answered %d", rec.Code)
+	}
+	GAMES.Delete(url)
+}
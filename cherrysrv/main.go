@@ -19,6 +19,7 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -56,22 +57,42 @@ var (
 	COMMANDS  = make(map[string]do_command)
 	CLIENTS   cmap.Map[string, *Client] // CLIENTS  cmap.Cmap
 	CHANNELS  cmap.Map[string, *Channel]
+	GAMES     cmap.Map[string, *GameServer]                    // game servers known by the lobby, by serverurl
+	LINKS     cmap.Map[string, *Link]                          // linked servers, by server name
+	ACCOUNTS  = &Accounts{accounts: make(map[string]*Account)} // registered @names, see init_accounts
 	SCHEDULER *tasks.Scheduler
 	TIME      uint64
 	STARTEDON time.Time
 )
 
 const (
-	VERSION   = "3.0.2"
+	VERSION   = "3.4.0"
 	STRINGVER = "cherry srv " + VERSION + "/" + runtime.GOOS + " (c) Roger Sen 2023"
 )
 
 func main() {
 
 	var srvaddr string
+	var lobbyurl string
+	var evtaddr string
+	var accounts string
+	var linkaddr string
+	var links string
+	var snapshot string
+	var rejoin time.Duration
 	var help bool
 
 	flag.StringVar(&srvaddr, "srvaddr", "", "<address:port> for tcp4 server")
+	flag.StringVar(&lobbyurl, "lobbyurl", "", "<http> lobby /viewFull url to poll for game servers")
+	flag.StringVar(&evtaddr, "evtaddr", "", "<address:port> to receive the lobby event webhook (localhost if no address)")
+	flag.StringVar(&EVTSECRET, "evtsecret", "", "<secret> the lobby signs the webhook events with (required unless the webhook is on localhost)")
+	flag.StringVar(&accounts, "accounts", "", "<file> to store the registered accounts")
+	flag.StringVar(&SRVNAME, "srvname", "cherry", "<name> of this server for the linked servers")
+	flag.StringVar(&linkaddr, "linkaddr", "", "<address:port> to accept links from other servers")
+	flag.StringVar(&links, "link", "", "<address:port>[,<address:port>] of the servers to link with")
+	flag.StringVar(&LINKPASS, "linkpass", "", "<secret> shared by the linked servers")
+	flag.StringVar(&snapshot, "snapshot", "", "<file> to save the channels and restore them on restart")
+	flag.DurationVar(&rejoin, "rejoin", 10*time.Minute, "<duration> after a restart for users to login and rejoin their channels")
 	flag.BoolVar(&help, "help", false, "show this help")
 
 	flag.Parse()
@@ -87,6 +108,21 @@ func main() {
 	init_scheduler()
 	init_time()
 
+	if err := init_accounts(accounts); err != nil {
+		ERROR.Fatalf("Unable to load the accounts from %s (%s)", accounts, err)
+		return
+	}
+
+	if err := init_snapshot(snapshot, rejoin); err != nil {
+		ERROR.Fatalf("Unable to restore the snapshot from %s (%s)", snapshot, err)
+		return
+	}
+
+	if err := init_lobby(lobbyurl, evtaddr); err != nil {
+		ERROR.Fatalf("Unable to start the lobby integration (%s)", err)
+		return
+	}
+
 	TCPAddr, err := net.ResolveTCPAddr("tcp", srvaddr)
 	if err != nil {
 		ERROR.Fatalf("Unable to resolve address on tcp4://%s (%s)", srvaddr, err)
@@ -103,16 +139,27 @@ func main() {
 	INFO.Printf("Started %s", STRINGVER)
 	INFO.Printf("Ready to serve on tcp://%s (tcp)", srvaddr)
 
-	// We create tha main channel
+	init_channels()
 
-	main_channel := NewChannelMain("#main")
-	CHANNELS.Store(main_channel.Key(), main_channel)
-	DEBUG.Printf("adding %s to CHANNELS", main_channel)
+	if err := init_links(linkaddr, links); err != nil {
+		ERROR.Fatalf("Unable to link with other servers (%s)", err)
+		return
+	}
+
+	serve(server)
+}
+
+// accept connections until the listener is closed
+func serve(server net.Listener) {
 
 	for {
-		conn, err := server.AcceptTCP()
+		conn, err := server.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			INFO.Printf("Stopped serving on tcp://%s", server.Addr())
+			return
+		}
 		if err != nil {
-			WARN.Printf("Unable to accept connection on %s (%s)", srvaddr, err)
+			WARN.Printf("Unable to accept connection on %s (%s)", server.Addr(), err)
 			continue
 		}
 		go newClient(conn).clientLoop()
@@ -180,8 +227,16 @@ func update_log_level(logger string, onoff string) error {
 	return nil
 }
 
+// We create tha main channel
+func init_channels() {
+
+	main_channel := NewChannelMain("#main")
+	CHANNELS.Store(main_channel.Key(), main_channel)
+	DEBUG.Printf("adding %s to CHANNELS", main_channel)
+}
+
 func init_scheduler() error {
-	SCHEDULER := tasks.New()
+	SCHEDULER = tasks.New()
 
 	TIME = 0
 
@@ -229,10 +284,12 @@ func SignalHandler(sigchan chan os.Signal) {
 
 		case syscall.SIGTERM:
 			WARN.Println("Got SIGTERM. Program will terminate cleanly now.")
+			shutdown_snapshot()
 			Broadcast(">#main>!shutdown>Shutting down the server, it will re-start in a few minutes")
 			os.Exit(143)
 		case syscall.SIGINT:
 			WARN.Println("Got SIGINT. Program will terminate cleanly now.")
+			shutdown_snapshot()
 			Broadcast(">#main>!shutdown>Shutting down the server, it will re-start in a few minutes")
 			os.Exit(137)
 		default:
@@ -241,6 +298,18 @@ func SignalHandler(sigchan chan os.Signal) {
 	}
 }
 
+// save the last snapshot before exiting
+func shutdown_snapshot() {
+
+	if no(SNAPSHOT_FILE) {
+		return
+	}
+
+	if err := save_snapshot(SNAPSHOT_FILE); err != nil {
+		ERROR.Printf("unable to save the snapshot to %s (%s)", SNAPSHOT_FILE, err)
+	}
+}
+
 func uptime(start time.Time) string {
 	return time.Since(start).String()
 }
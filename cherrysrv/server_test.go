@@ -0,0 +1,485 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// End to end tests: the server runs in-process on an ephemeral port and
+// scripted clients talk to it over real tcp sockets.
+// Run them with `make test` (go test -race) so locking regressions show up.
+
+const (
+	E2E_CLIENTS = 40              // clients in TestServerConcurrentClients
+	E2E_TIMEOUT = 5 * time.Second // max time to wait for an expected line
+)
+
+var worldOnce sync.Once
+
+// start the world once for all the tests. Goroutines of a finished test may
+// still be running, so it must not change afterwards.
+func initTestWorld() {
+
+	worldOnce.Do(func() {
+		init_logger()
+		init_commands()
+
+		// quiet, we'll have many clients
+		INFO.SetActive(false)
+		DEBUG.SetActive(false)
+
+		SRVNAME = "us"
+		LINKPASS = "secret"
+	})
+}
+
+// forget the registered accounts of previous tests
+func resetAccounts() {
+	ACCOUNTS.Lock()
+	defer ACCOUNTS.Unlock()
+
+	ACCOUNTS.accounts = make(map[string]*Account)
+}
+
+// serve on a new ephemeral port for every test
+func startTestServer(t *testing.T) string {
+	t.Helper()
+
+	initTestWorld()
+
+	// tests in this package share the world, so we always start with a fresh #main
+	init_channels()
+
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen on an ephemeral port: %s", err)
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		serve(listener)
+		close(done)
+	}()
+
+	// the clients are closed first, the next test starts once they are gone
+	t.Cleanup(func() {
+		listener.Close()
+		<-done
+
+		deadline := time.Now().Add(E2E_TIMEOUT)
+		for CLIENTS.Count() > 0 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+
+	return listener.Addr().String()
+}
+
+// a scripted client
+type e2eClient struct {
+	t      *testing.T
+	name   string
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// connect to the server and read the welcome line
+func dial(t *testing.T, addr string) *e2eClient {
+	t.Helper()
+
+	conn, err := net.Dial("tcp4", addr)
+	if err != nil {
+		t.Fatalf("unable to connect to %s: %s", addr, err)
+	}
+
+	t.Cleanup(func() { conn.Close() })
+
+	clt := &e2eClient{t: t, conn: conn, reader: bufio.NewReader(conn)}
+
+	welcome := clt.readLine()
+	if !strings.HasPrefix(welcome, ">#main>!welcome>welcome to cherry server @Anon-") {
+		t.Errorf("unexpected welcome line %q", welcome)
+	}
+
+	return clt
+}
+
+func (clt *e2eClient) send(line string) {
+	if _, err := clt.conn.Write([]byte(line + "\n")); err != nil {
+		clt.t.Errorf("%s unable to send %q: %s", clt.name, line, err)
+	}
+}
+
+// read a line without the trailing "\n", "" on timeout or error
+func (clt *e2eClient) readLine() string {
+	clt.conn.SetReadDeadline(time.Now().Add(E2E_TIMEOUT))
+
+	line, err := clt.reader.ReadString('\n')
+	if err != nil {
+		clt.t.Errorf("%s expected a line, got error %s", clt.name, err)
+		return ""
+	}
+
+	return strings.TrimSuffix(line, "\n")
+}
+
+// check the next lines are exactly the expected ones
+func (clt *e2eClient) expect(expected ...string) {
+	clt.t.Helper()
+
+	for _, ex := range expected {
+		if got := clt.readLine(); got != ex {
+			clt.t.Errorf("%s got %q, expected %q", clt.name, got, ex)
+		}
+	}
+}
+
+// check the server has nothing else to say to us
+func (clt *e2eClient) expectNothing() {
+	clt.t.Helper()
+
+	clt.conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+
+	line, err := clt.reader.ReadString('\n')
+
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		clt.t.Errorf("%s got unexpected %q (%v)", clt.name, line, err)
+	}
+}
+
+// check the server closed the connection
+func (clt *e2eClient) expectClosed() {
+	clt.t.Helper()
+
+	clt.conn.SetReadDeadline(time.Now().Add(E2E_TIMEOUT))
+
+	line, err := clt.reader.ReadString('\n')
+	if err != io.EOF {
+		clt.t.Errorf("%s expected the connection to be closed, got %q (%v)", clt.name, line, err)
+	}
+}
+
+// read until every wanted line has been received, in any order. The lines in
+// between must be accepted by noise, anything else is an error.
+func (clt *e2eClient) collect(wanted []string, noise func(string) bool) {
+	clt.t.Helper()
+
+	pending := make(map[string]int)
+	for _, line := range wanted {
+		pending[line]++
+	}
+
+	for left := len(wanted); left > 0; {
+		line := clt.readLine()
+
+		switch {
+		case pending[line] > 0:
+			pending[line]--
+			left--
+		case line == "":
+			return // readLine already failed the test
+		case !noise(line):
+			clt.t.Errorf("%s got unexpected %q", clt.name, line)
+		}
+	}
+}
+
+func (clt *e2eClient) login(name string) {
+	clt.name = name
+	clt.send("/login " + name)
+	clt.expect(">/login>0>you're now " + name)
+}
+
+func TestServerSingleSession(t *testing.T) {
+	addr := startTestServer(t)
+
+	clt := dial(t, addr)
+
+	clt.send("/join #e2e")
+	clt.expect(">/join>0>/join requires you to be logged")
+
+	clt.send("/nosuchcommand")
+	clt.expect(">/nosuchcommand>0>command nosuchcommand does not exist")
+
+	clt.send("/login john")
+	clt.expect(">/login>0>john is not a valid username because username must start with '@'")
+
+	// the password is never echoed back
+	clt.send("/login john secret")
+	clt.expect(">/login>0>john is not a valid username because username must start with '@'")
+
+	clt.login("@john")
+
+	clt.send("/who")
+	clt.expect(">/who>0>@john")
+
+	// empty lines are ignored
+	clt.send("")
+	clt.send("/join #e2e")
+	clt.expect(">/join>0>@john joined #e2e")
+
+	// several commands in a single packet
+	clt.send("#e2e hello\n/nusers #e2e\n/users #e2e")
+	clt.expect(">#e2e>@john>hello", ">/users #e2e>0>1", ">/users #e2e>0>@john")
+
+	clt.send("/say #nowhere hello")
+	clt.expect(">/say>0>#nowhere is not a valid channel")
+
+	clt.send("/leave #e2e")
+	clt.expect(">#e2e>@john>left the channel")
+
+	clt.send("/list")
+	clt.expect(">/list>0>#main")
+
+	clt.send("/logoff")
+	clt.expect(">/logoff>0>Goodbye @john")
+	clt.expectClosed()
+}
+
+func TestServerConversation(t *testing.T) {
+	addr := startTestServer(t)
+
+	alice := dial(t, addr)
+	bob := dial(t, addr)
+
+	alice.login("@alice")
+	bob.expect(">#main>!login>@alice has joined the server")
+
+	bob.login("@bob")
+	alice.expect(">#main>!login>@bob has joined the server")
+
+	alice.send("/join #room")
+	alice.expect(">/join>0>@alice joined #room")
+
+	bob.send("/join #room")
+	bob.expect(">#room>@bob>joined the channel")
+	alice.expect(">#room>@bob>joined the channel")
+
+	alice.send("#room hi bob")
+	alice.expect(">#room>@alice>hi bob")
+	bob.expect(">#room>@alice>hi bob")
+
+	bob.send("#main hi everyone")
+	bob.expect(">#main>@bob>hi everyone")
+	alice.expect(">#main>@bob>hi everyone")
+
+	bob.send("/users #room")
+	bob.expect(">/users #room>1>@alice", ">/users #room>0>@bob")
+
+	bob.send("/leave #room")
+	bob.expect(">#room>@bob>left the channel")
+	alice.expect(">#room>@bob>left the channel")
+
+	// bob cannot write in a channel he left
+	bob.send("#room still there?")
+	bob.expect(">/say>0>you must /join #room before you can write")
+
+	// bob drops the connection without logging off
+	bob.conn.Close()
+	alice.expect(">#main>!disconnect>@bob disconnected")
+
+	alice.send("/users")
+	alice.expect(">/users>0>@alice")
+
+	alice.expectNothing()
+
+	alice.send("/logoff")
+	alice.expect(">/logoff>0>Goodbye @alice")
+	alice.expectClosed()
+}
+
+// Many clients log in, join the same channel, talk and leave at the same time.
+func TestServerConcurrentClients(t *testing.T) {
+	addr := startTestServer(t)
+
+	clients := make([]*e2eClient, E2E_CLIENTS)
+	for i := range clients {
+		clients[i] = dial(t, addr)
+	}
+
+	// lines caused by the other clients, arriving at any moment
+	noise := func(line string) bool {
+		return strings.HasPrefix(line, ">#main>!login>") ||
+			strings.HasPrefix(line, ">#main>!logoff>") ||
+			strings.HasPrefix(line, ">#main>!disconnect>") ||
+			(strings.HasPrefix(line, ">#crowd>@c") &&
+				(strings.HasSuffix(line, ">joined the channel") || strings.HasSuffix(line, ">left the channel")))
+	}
+
+	var hellos []string
+	for i := range clients {
+		hellos = append(hellos, fmt.Sprintf(">#crowd>@c%d>hello from %d", i, i))
+	}
+
+	// run f for every client at the same time and wait for all of them
+	phase := func(f func(i int, clt *e2eClient)) {
+		var wg sync.WaitGroup
+
+		for i, clt := range clients {
+			wg.Add(1)
+			go func(i int, clt *e2eClient) {
+				defer wg.Done()
+				f(i, clt)
+			}(i, clt)
+		}
+
+		wg.Wait()
+	}
+
+	phase(func(i int, clt *e2eClient) {
+		clt.name = fmt.Sprintf("@c%d", i)
+		clt.send("/login " + clt.name)
+		clt.collect([]string{">/login>0>you're now " + clt.name}, noise)
+
+		clt.send("/join #crowd")
+
+		// only one of us creates the channel, the rest join it
+		for {
+			line := clt.readLine()
+			if line == ">/join>0>"+clt.name+" joined #crowd" || line == ">#crowd>"+clt.name+">joined the channel" {
+				break
+			}
+			if line == "" {
+				return
+			}
+			if !noise(line) {
+				t.Errorf("%s got unexpected %q", clt.name, line)
+			}
+		}
+	})
+
+	if t.Failed() {
+		t.FailNow()
+	}
+
+	phase(func(i int, clt *e2eClient) {
+		clt.send("/nusers #crowd")
+		clt.collect([]string{fmt.Sprintf(">/users #crowd>0>%d", E2E_CLIENTS)}, noise)
+	})
+
+	phase(func(i int, clt *e2eClient) {
+		clt.send(fmt.Sprintf("#crowd hello from %d", i))
+		clt.collect(hellos, noise)
+	})
+
+	phase(func(i int, clt *e2eClient) {
+		switch i % 3 {
+		case 0:
+			clt.send("/leave #crowd")
+			clt.collect([]string{">#crowd>" + clt.name + ">left the channel"}, noise)
+			clt.send("/logoff")
+			clt.collect([]string{">/logoff>0>Goodbye " + clt.name}, noise)
+		case 1:
+			clt.send("/logoff")
+			clt.collect([]string{">/logoff>0>Goodbye " + clt.name}, noise)
+		case 2:
+			clt.conn.Close()
+		}
+	})
+
+	// everybody is gone, and so is #crowd
+	deadline := time.Now().Add(E2E_TIMEOUT)
+
+	for CLIENTS.Count() > 0 || !no(channelNames()) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d clients and channels %v left after everyone left", CLIENTS.Count(), channelNames())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// channels other than #main
+func channelNames() (names []string) {
+
+	CHANNELS.Range(func(key string, channel *Channel) bool {
+		if key != "#main" {
+			names = append(names, key)
+		}
+		return true
+	})
+
+	return names
+}
+
+func TestServerIgnore(t *testing.T) {
+	addr := startTestServer(t)
+
+	resetAccounts()
+
+	alice := dial(t, addr)
+	bob := dial(t, addr)
+
+	alice.login("@alice")
+	bob.expect(">#main>!login>@alice has joined the server")
+
+	bob.login("@bob")
+	alice.expect(">#main>!login>@bob has joined the server")
+
+	alice.send("/ignorelist")
+	alice.expect(">/ignorelist>0>you're not ignoring anyone")
+
+	alice.send("/ignore @alice")
+	alice.expect(">/ignore>0>you cannot ignore yourself")
+
+	alice.send("/ignore @bob")
+	alice.expect(">/ignore>0>you're now ignoring @bob")
+
+	alice.send("/ignore @carol")
+	alice.expect(">/ignore>0>you're now ignoring @carol")
+
+	alice.send("/ignorelist")
+	alice.expect(">/ignorelist>1>@bob", ">/ignorelist>0>@carol")
+
+	bob.send("#main can you read me?")
+	bob.expect(">#main>@bob>can you read me?")
+	alice.expectNothing()
+
+	alice.send("#main I can't hear bob")
+	alice.expect(">#main>@alice>I can't hear bob")
+	bob.expect(">#main>@alice>I can't hear bob")
+
+	alice.send("/unignore @carol")
+	alice.expect(">/unignore>0>you're no longer ignoring @carol")
+
+	alice.send("/unignore @carol")
+	alice.expect(">/unignore>0>you're not ignoring @carol")
+
+	// registered accounts keep their ignore list
+	alice.send("/register secret")
+	alice.expect(">/register>0>@alice is now registered")
+
+	alice.send("/logoff")
+	alice.expect(">/logoff>0>Goodbye @alice")
+	bob.expect(">#main>!logoff>@alice is leaving")
+
+	alice = dial(t, addr)
+
+	alice.send("/login @alice")
+	alice.expect(">/login>0>@alice is registered, /login @alice <password>")
+
+	alice.send("/login @alice wrong")
+	alice.expect(">/login>0>@alice is registered, /login @alice <password>")
+
+	alice.send("/login @bobby secret")
+	alice.expect(">/login>0>@bobby is not registered, /login @bobby")
+
+	alice.send("/login @alice secret")
+	alice.expect(">/login>0>you're now @alice")
+	bob.expect(">#main>!login>@alice has joined the server")
+
+	alice.send("/ignorelist")
+	alice.expect(">/ignorelist>0>@bob")
+
+	bob.send("#main and now?")
+	bob.expect(">#main>@bob>and now?")
+	alice.expectNothing()
+}
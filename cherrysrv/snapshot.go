@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/lrita/cmap"
+	"github.com/madflojo/tasks"
+)
+
+// Snapshots of the server state, so a restart doesn't lose the channels.
+// The snapshot is taken periodically and on shutdown, and it's restored on
+// startup: the channels are back before anyone connects, and a registered
+// user logging back in within the rejoin window is put back in the channels
+// they were in. Anyone could take the nick of an unregistered user, so they
+// rejoin their channels by hand. A channel nobody is back in by the end of
+// the window is closed.
+// Cherry channels have no topics, ops or bans, so a channel is its name,
+// its hidden flag and its members.
+
+const (
+	SNAPSHOT_INTERVAL = 1 * time.Minute // how often the snapshot is taken
+)
+
+type SavedChannel struct {
+	Name    string   `json:"name"`
+	Hidden  bool     `json:"hidden,omitempty"`
+	Members []string `json:"members,omitempty"` // only users logged in this server
+}
+
+type Snapshot struct {
+	Taken    time.Time      `json:"taken"`
+	Channels []SavedChannel `json:"channels"`
+	Accounts []Account      `json:"accounts,omitempty"`
+}
+
+// channels to rejoin when a user logs back in after a restart
+type Rejoin struct {
+	channels []SavedChannel
+	until    time.Time
+}
+
+var (
+	SNAPSHOT_FILE string                    // where the snapshot is saved. Empty: no snapshots
+	REJOINS       cmap.Map[string, *Rejoin] // pending rejoins, by @name
+)
+
+// restore the snapshot in file (if any) and save it periodically
+func init_snapshot(file string, window time.Duration) error {
+
+	if no(file) {
+		return nil
+	}
+
+	SNAPSHOT_FILE = file
+
+	err := restore_snapshot(file, window)
+	if err != nil {
+		return err
+	}
+
+	_, err = SCHEDULER.Add(&tasks.Task{
+		Interval: SNAPSHOT_INTERVAL,
+		TaskFunc: func() error { return save_snapshot(SNAPSHOT_FILE) },
+		ErrFunc: func(err error) {
+			WARN.Printf("unable to save the snapshot to %s (%s)", SNAPSHOT_FILE, err)
+		},
+	})
+
+	return err
+}
+
+// collect the channels, their members and the registered accounts
+func take_snapshot() Snapshot {
+
+	snapshot := Snapshot{
+		Taken:    time.Now(),
+		Accounts: ACCOUNTS.list(),
+	}
+
+	saved := make(map[string]*SavedChannel)
+
+	CHANNELS.Range(func(key string, channel *Channel) bool {
+		if key == "#main" {
+			return true
+		}
+
+		saved[key] = &SavedChannel{Name: channel.Name, Hidden: channel.isHidden()}
+
+		for _, clt := range channel.members() {
+			if clt.isLogged() && clt.isLocal() {
+				saved[key].Members = append(saved[key].Members, clt.Name)
+			}
+		}
+		return true
+	})
+
+	// users that didn't come back yet after the last restart keep their channels
+	REJOINS.Range(func(name string, rejoin *Rejoin) bool {
+		if snapshot.Taken.After(rejoin.until) {
+			return true
+		}
+
+		if _, logged := CLIENTS.Load(name); logged {
+			return true
+		}
+
+		for _, channel := range rejoin.channels {
+			if saved[channel.Name] == nil {
+				saved[channel.Name] = &SavedChannel{Name: channel.Name, Hidden: channel.Hidden}
+			}
+			saved[channel.Name].Members = append(saved[channel.Name].Members, name)
+		}
+		return true
+	})
+
+	for _, channel := range saved {
+		sort.Strings(channel.Members)
+		snapshot.Channels = append(snapshot.Channels, *channel)
+	}
+
+	sort.Slice(snapshot.Channels, func(i, j int) bool { return snapshot.Channels[i].Name < snapshot.Channels[j].Name })
+	sort.Slice(snapshot.Accounts, func(i, j int) bool { return snapshot.Accounts[i].Name < snapshot.Accounts[j].Name })
+
+	return snapshot
+}
+
+// write the snapshot to file
+func save_snapshot(file string) error {
+
+	data, err := json.MarshalIndent(take_snapshot(), "", "\t")
+	if err != nil {
+		return err
+	}
+
+	// write and rename so a crash never leaves half a file
+	tmp := file + ".tmp"
+
+	err = os.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, file)
+}
+
+// read the snapshot in file: the accounts are registered again, the channels
+// are created again and their members can rejoin them if they login within window
+func restore_snapshot(file string, window time.Duration) error {
+
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var snapshot Snapshot
+
+	err = json.Unmarshal(data, &snapshot)
+	if err != nil {
+		return err
+	}
+
+	ACCOUNTS.restore(snapshot.Accounts)
+
+	until := time.Now().Add(window)
+	rejoins := make(map[string]*Rejoin)
+	restored := []*Channel{}
+
+	for _, channel := range snapshot.Channels {
+		if _, err := ValidChannelname(channel.Name); err != nil {
+			WARN.Printf("ignoring channel %s in the snapshot (%s)", channel.Name, err)
+			continue
+		}
+
+		// empty until its members are back, so it's still listed and keeps its hidden flag
+		restoredChannel, _ := CHANNELS.LoadOrStore(channel.Name, newChannel(channel.Name, channel.Hidden))
+		restored = append(restored, restoredChannel)
+
+		for _, name := range channel.Members {
+			if !ACCOUNTS.isRegistered(name) {
+				continue
+			}
+			if rejoins[name] == nil {
+				rejoins[name] = &Rejoin{until: until}
+			}
+			rejoins[name].channels = append(rejoins[name].channels, SavedChannel{Name: channel.Name, Hidden: channel.Hidden})
+		}
+	}
+
+	for name, rejoin := range rejoins {
+		REJOINS.Store(name, rejoin)
+	}
+
+	// nobody will be put back in the channels after the window
+	time.AfterFunc(window, func() {
+		for _, channel := range restored {
+			channel.closeIfEmpty()
+		}
+	})
+
+	INFO.Printf("snapshot from %s restored: %d channels, %d users can rejoin until %s",
+		snapshot.Taken.Format(time.RFC3339), len(snapshot.Channels), len(rejoins), until.Format(time.RFC3339))
+
+	return nil
+}
+
+// put clt back in the channels it was in before the restart
+func rejoin_channels(clt *Client) {
+
+	rejoin, ok := REJOINS.Load(clt.Name)
+	if !ok {
+		return
+	}
+
+	REJOINS.Delete(clt.Name)
+
+	if time.Now().After(rejoin.until) {
+		return
+	}
+
+	for _, saved := range rejoin.channels {
+		channel, _ := CHANNELS.LoadOrStore(saved.Name, newChannel(saved.Name, saved.Hidden))
+
+		if channel.contains(clt) {
+			continue
+		}
+
+		if !channel.addClient(clt) {
+			// this copy was shutting down, start a new one
+			channel = newChannel(saved.Name, saved.Hidden)
+			channel.addClient(clt)
+			CHANNELS.Store(channel.Key(), channel)
+		}
+
+		send_links(nil, "JOIN %s %s %s", channel, clt, channel.hiddenFlag())
+
+		channel.Say(clt, "rejoined the channel")
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	addr := startTestServer(t)
+	file := filepath.Join(t.TempDir(), "snapshot.json")
+
+	resetAccounts()
+
+	alice := dial(t, addr)
+	alice.login("@alice")
+	alice.send("/join #room")
+	alice.expect(">/join>0>@alice joined #room")
+
+	bob := dial(t, addr)
+	bob.login("@bob")
+	alice.expect(">#main>!login>@bob has joined the server")
+
+	bob.send("/hjoin #secret")
+	bob.expect(">/hjoin>0>@bob hjoined #secret")
+	bob.send("/join #room")
+	bob.expect(">#room>@bob>joined the channel")
+	alice.expect(">#room>@bob>joined the channel")
+
+	bob.send("/register secret")
+	bob.expect(">/register>0>@bob is now registered")
+
+	if err := save_snapshot(file); err != nil {
+		t.Fatalf("save_snapshot() error = %v", err)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("unable to read the snapshot: %v", err)
+	}
+
+	var snapshot Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		t.Fatalf("invalid snapshot: %v", err)
+	}
+
+	expected := []SavedChannel{
+		{Name: "#room", Members: []string{"@alice", "@bob"}},
+		{Name: "#secret", Hidden: true, Members: []string{"@bob"}},
+	}
+
+	if !reflect.DeepEqual(snapshot.Channels, expected) {
+		t.Errorf("snapshot channels = %+v, expected %+v", snapshot.Channels, expected)
+	}
+
+	if len(snapshot.Accounts) != 1 || snapshot.Accounts[0].Name != "@bob" {
+		t.Errorf("snapshot accounts = %+v, expected @bob", snapshot.Accounts)
+	}
+
+	// a restart loses everything
+	alice.send("/logoff")
+	alice.expect(">/logoff>0>Goodbye @alice")
+	bob.expect(">#main>!logoff>@alice is leaving")
+	bob.send("/logoff")
+	bob.expect(">/logoff>0>Goodbye @bob")
+
+	resetAccounts()
+
+	eventually(t, "the channels should be gone", func() bool {
+		return len(channelNames()) == 0
+	})
+
+	if err := restore_snapshot(file, time.Minute); err != nil {
+		t.Fatalf("restore_snapshot() error = %v", err)
+	}
+
+	if !ACCOUNTS.isRegistered("@bob") {
+		t.Errorf("@bob should be registered again")
+	}
+
+	// back within the window, back in the channels
+	bob = dial(t, addr)
+	bob.name = "@bob"
+	bob.send("/login @bob secret")
+	bob.expect(">/login>0>you're now @bob", ">#room>@bob>rejoined the channel", ">#secret>@bob>rejoined the channel")
+
+	if channel, ok := CHANNELS.Load("#secret"); !ok || !channel.isHidden() {
+		t.Errorf("#secret should be hidden again")
+	}
+
+	// anyone could login as the unregistered @alice, she is not put back
+	alice = dial(t, addr)
+	alice.login("@alice")
+	bob.expect(">#main>!login>@alice has joined the server")
+	alice.expectNothing()
+
+	// only once
+	bob.send("/logoff")
+	bob.expect(">/logoff>0>Goodbye @bob")
+	alice.expect(">#main>!logoff>@bob is leaving")
+
+	bob = dial(t, addr)
+	bob.name = "@bob"
+	bob.send("/login @bob secret")
+	bob.expect(">/login>0>you're now @bob")
+	alice.expect(">#main>!login>@bob has joined the server")
+	bob.expectNothing()
+
+	// too late
+	REJOINS.Store("@carol", &Rejoin{
+		channels: []SavedChannel{{Name: "#old"}},
+		until:    time.Now().Add(-time.Second),
+	})
+
+	carol := dial(t, addr)
+	carol.login("@carol")
+	alice.expect(">#main>!login>@carol has joined the server")
+	bob.expect(">#main>!login>@carol has joined the server")
+	carol.expectNothing()
+
+	if _, ok := CHANNELS.Load("#old"); ok {
+		t.Errorf("#old should not be restored after the window")
+	}
+
+	for _, clt := range []*e2eClient{alice, bob, carol} {
+		clt.send("/logoff")
+	}
+}
+
+func TestSnapshotRestoreChannels(t *testing.T) {
+	addr := startTestServer(t)
+	file := filepath.Join(t.TempDir(), "snapshot.json")
+
+	resetAccounts()
+
+	snapshot := Snapshot{
+		Taken: time.Now(),
+		Channels: []SavedChannel{
+			{Name: "#lounge", Members: []string{"@dave"}},
+			{Name: "#den", Hidden: true, Members: []string{"@dave"}},
+			{Name: "#quiet"},
+		},
+	}
+
+	data, _ := json.Marshal(snapshot)
+	if err := os.WriteFile(file, data, 0600); err != nil {
+		t.Fatalf("unable to write the snapshot: %v", err)
+	}
+
+	window := time.Second
+
+	if err := restore_snapshot(file, window); err != nil {
+		t.Fatalf("restore_snapshot() error = %v", err)
+	}
+
+	// the channels are back before anyone rejoins them
+	if names := channelNames(); len(names) != 3 {
+		t.Errorf("restored channels = %v, expected #den, #lounge and #quiet", names)
+	}
+
+	if channel, ok := CHANNELS.Load("#den"); !ok || !channel.isHidden() {
+		t.Errorf("#den should be restored hidden")
+	}
+
+	erin := dial(t, addr)
+	erin.login("@erin")
+
+	erin.send("/list")
+	erin.expect(">/list>2>#lounge", ">/list>1>#main", ">/list>0>#quiet")
+
+	// joining a restored channel keeps its settings
+	erin.send("/join #den")
+	erin.expect(">#den>@erin>joined the channel")
+
+	if channel, ok := CHANNELS.Load("#den"); !ok || !channel.isHidden() {
+		t.Errorf("#den should still be hidden")
+	}
+
+	// the channels nobody came back to are closed after the window
+	eventually(t, "the empty channels should be closed", func() bool {
+		names := channelNames()
+		return len(names) == 1 && names[0] == "#den"
+	})
+
+	erin.send("/logoff")
+	erin.expect(">/logoff>0>Goodbye @erin")
+}
@@ -0,0 +1,510 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	_ "embed"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/exp/slices"
+)
+
+// Admin api - lets the operator look inside the tables and fix them without a restart: list every table
+// with its internal state, kick a player, reset a table, set a purse, pause or resume a game, and add or
+// remove bots. /admin is an html dashboard for the same. Every admin path needs the ADMIN_KEY env variable
+// in the X-Admin-Key header, the key field of a POST body, or the cookie the dashboard login sets. The key is
+// never taken from the url, so it stays out of access logs and redirects. If ADMIN_KEY is not set, the admin
+// api is disabled.
+
+const PAUSED_MESSAGE = "Paused by the operator"
+const ADMIN_COOKIE = "admin_key"
+
+var AdminKey string
+
+//go:embed admin.html
+var ADMIN_HTML []byte
+
+var errPlayerNotFound = errors.New("Player not found")
+
+// A table as the operator sees it, internal state included
+type AdminTable struct {
+	Table       string        `json:"table"`
+	Name        string        `json:"name"`
+	Variant     string        `json:"variant"`
+	Stakes      string        `json:"stakes"`
+	Lobby       bool          `json:"lobby"` // Registered with the lobby
+	Custom      bool          `json:"custom"`
+	Private     bool          `json:"private"`
+	Tournament  int           `json:"tournament"`
+	Paused      bool          `json:"paused"`
+	Hand        int           `json:"hand"`
+	Round       int           `json:"round"`
+	Pot         int           `json:"pot"`
+	GameOver    bool          `json:"gameOver"`
+	Active      int           `json:"active"`
+	MoveExpires time.Time     `json:"moveExpires"`
+	Deck        string        `json:"deck"`
+	DeckIndex   int           `json:"deckIndex"` // Next card to deal
+	Players     []AdminPlayer `json:"players"`
+	Spectators  int           `json:"spectators"`
+}
+
+type AdminPlayer struct {
+	Name     string    `json:"name"`
+	Bot      bool      `json:"bot"`
+	Strategy string    `json:"strategy,omitempty"`
+	Status   Status    `json:"status"`
+	Purse    int       `json:"purse"`
+	Bet      int       `json:"bet"`
+	TotalBet int       `json:"totalBet"`
+	Cards    string    `json:"cards"`
+	LastPing time.Time `json:"lastPing"`
+}
+
+var statusNames = []string{"Waiting", "Playing", "Folded", "Left"}
+
+func addAdminRoutes(router *gin.Engine) {
+	admin := router.Group("/admin", adminAuth)
+	admin.GET("", apiAdminDashboard)
+	admin.POST("", apiAdminLogin)
+	admin.GET("/tables", apiAdminTables)
+	admin.POST("/kick", apiAdminKick)
+	admin.POST("/reset", apiAdminReset)
+	admin.POST("/purse", apiAdminPurse)
+	admin.POST("/pause", apiAdminPause)
+	admin.POST("/resume", apiAdminResume)
+	admin.POST("/bots", apiAdminBots)
+}
+
+// Only lets requests with the admin key through
+func adminAuth(c *gin.Context) {
+	if AdminKey == "" {
+		c.String(http.StatusNotFound, "Admin api disabled")
+		c.Abort()
+		return
+	}
+
+	key := c.GetHeader("X-Admin-Key")
+	if key == "" {
+		key = c.Request.PostFormValue("key")
+	}
+	if key == "" {
+		key, _ = c.Cookie(ADMIN_COOKIE)
+	}
+	if subtle.ConstantTimeCompare([]byte(key), []byte(AdminKey)) == 1 {
+		return
+	}
+
+	// The dashboard asks for the key
+	if c.Request.Method == http.MethodGet && c.FullPath() == "/admin" {
+		login := "<tr><td colspan='7'><form method='post' action='/admin'><input type='password' name='key' placeholder='Admin key'/><button>Log in</button></form></td></tr>"
+		c.Data(http.StatusUnauthorized, gin.MIMEHTML, bytes.ReplaceAll(ADMIN_HTML, []byte("$$TABLES$$"), []byte(login)))
+	} else {
+		c.String(http.StatusUnauthorized, "Invalid key")
+	}
+	c.Abort()
+}
+
+// Keeps the key posted to the dashboard in a cookie, sent back only to the admin paths of this site
+func apiAdminLogin(c *gin.Context) {
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(ADMIN_COOKIE, AdminKey, 0, "/admin", "", false, true)
+	c.Redirect(http.StatusSeeOther, "/admin")
+}
+
+func (state *GameState) adminView() AdminTable {
+	view := AdminTable{
+		Table:       state.table,
+		Name:        state.serverName,
+		Variant:     state.variant.Code,
+		Stakes:      state.betting.String(),
+		Lobby:       state.registerLobby,
+		Custom:      state.custom,
+		Private:     state.private,
+		Tournament:  int(state.tournamentStatus()),
+		Paused:      state.isPaused(),
+		Hand:        state.handNumber,
+		Round:       state.Round,
+		Pot:         state.Pot,
+		GameOver:    state.gameOver,
+		Active:      state.ActivePlayer,
+		MoveExpires: state.moveExpires,
+		Deck:        cardsToString(state.deck),
+		DeckIndex:   state.deckIndex,
+		Players:     []AdminPlayer{},
+		Spectators:  state.spectatorCount(),
+	}
+
+	for _, player := range state.Players {
+		view.Players = append(view.Players, AdminPlayer{
+			Name:     player.Name,
+			Bot:      player.isBot,
+			Strategy: strategyName(player.strategy),
+			Status:   player.Status,
+			Purse:    player.Purse,
+			Bet:      player.Bet,
+			TotalBet: player.totalBet,
+			Cards:    cardsToString(player.cards),
+			LastPing: player.lastPing,
+		})
+	}
+	return view
+}
+
+// Returns every table, in the order of /tables
+func adminTables() []AdminTable {
+	result := []AdminTable{}
+	for _, table := range getTables() {
+		func() {
+			unlock := tableMutex.Lock(table.Table)
+			defer unlock()
+
+			if value, ok := stateMap.Load(table.Table); ok {
+				result = append(result, value.(*GameState).adminView())
+			}
+		}()
+	}
+	return result
+}
+
+func (state *GameState) findPlayer(name string) (*Player, error) {
+	index := slices.IndexFunc(state.Players, func(p Player) bool { return strings.EqualFold(p.Name, name) })
+	if index < 0 {
+		return nil, errPlayerNotFound
+	}
+	return &state.Players[index], nil
+}
+
+// Removes the player from their seat, as if they left. They can sit down again
+func (state *GameState) kickPlayer(name string) error {
+	index := slices.IndexFunc(state.Players, func(p Player) bool { return strings.EqualFold(p.Name, name) })
+	if index < 0 {
+		return errPlayerNotFound
+	}
+
+	state.record("%s is removed by the operator", state.Players[index].Name)
+	state.clientPlayer = index
+	state.clientLeave()
+	state.clientPlayer = -1
+	return nil
+}
+
+func (state *GameState) setPurse(name string, purse int) error {
+	player, err := state.findPlayer(name)
+	if err != nil {
+		return err
+	}
+	if purse < 0 {
+		return errors.New("Invalid purse")
+	}
+
+	player.Purse = purse
+	recordPurse(player)
+	log.Printf("Purse of %s at table %s set to %d", player.Name, state.table, purse)
+	return nil
+}
+
+func (state *GameState) isPaused() bool {
+	return !state.pausedAt.IsZero()
+}
+
+// Stops the game where it is. Nobody can move, and the move timer is held until the table is resumed
+func (state *GameState) pause() {
+	if !state.isPaused() {
+		state.pausedAt = state.now()
+		log.Printf("Table %s paused", state.table)
+	}
+}
+
+func (state *GameState) resume() {
+	if !state.isPaused() {
+		return
+	}
+
+	paused := state.now().Sub(state.pausedAt)
+	state.moveExpires = state.moveExpires.Add(paused)
+	if state.tournamentStatus() == TOURNAMENT_PLAYING {
+		state.tournament.Started = state.tournament.Started.Add(paused)
+	}
+	state.pausedAt = time.Time{}
+	log.Printf("Table %s resumed", state.table)
+}
+
+// Adds count bots, each with the next strategy of the default mix
+func (state *GameState) addBots(count int) error {
+	if state.registrationClosed() {
+		return errors.New("The tournament has started")
+	}
+	if count < 1 || len(state.Players)+count > state.maxPlayers() {
+		return errors.New("Not enough seats")
+	}
+
+	bots := 0
+	for _, player := range state.Players {
+		if player.isBot {
+			bots++
+		}
+	}
+
+	for _, name := range botNames {
+		if count == 0 {
+			break
+		}
+		if _, err := state.findPlayer(name); err == nil {
+			continue
+		}
+		state.addPlayer(name, true)
+		state.Players[len(state.Players)-1].strategy = defaultBotMix[bots%len(defaultBotMix)]
+		bots++
+		count--
+	}
+
+	if count > 0 {
+		return errors.New("No bot names left")
+	}
+	return nil
+}
+
+// Removes the last count bots. A bot in the game folds out of it, and leaves when it is over
+func (state *GameState) removeBots(count int) error {
+	if state.registrationClosed() {
+		return errors.New("The tournament has started")
+	}
+
+	for i := len(state.Players) - 1; i >= 0 && count > 0; i-- {
+		if player := &state.Players[i]; player.isBot && player.Status != STATUS_LEFT {
+			player.Status = STATUS_LEFT
+			player.Move = "LEFT"
+			count--
+		}
+	}
+	if count > 0 {
+		return errors.New("Not enough bots")
+	}
+
+	if state.Round == 0 || state.gameOver {
+		state.dropInactivePlayers(false, false)
+	}
+	return nil
+}
+
+// Starts the table over with the same stakes and bots
+func (state *GameState) resetTable() *GameState {
+	betting, bots := state.betting, []BotStrategy{}
+	if state.isTournament() {
+		betting = state.tournament.Base
+	}
+	for _, player := range state.Players {
+		if player.isBot && player.Status != STATUS_LEFT {
+			bots = append(bots, player.botStrategy())
+		}
+	}
+
+	return state.startOver(state.variant, betting, bots)
+}
+
+// Aborts the game in progress and starts the table over with the variant, stakes and bots. Chips in the pot
+// go back to the players, and humans keep their seats while there is room. A tournament starts over with registration
+func (state *GameState) startOver(variant *Variant, betting BettingStructure, bots []BotStrategy) *GameState {
+	if state.Round > 0 && !state.gameOver {
+		state.finishHistory("Game aborted by the operator")
+	}
+
+	reset := createGameState(variant, betting, nil, state.registerLobby)
+	if state.isTournament() {
+		tournament := state.tournament
+		reset.tournament = newTournament(tournament.Seats, tournament.LevelHands, tournament.LevelTime, tournament.Base)
+	}
+	reset.seatBots(bots)
+	reset.table = state.table
+	reset.serverName = state.serverName
+	reset.custom = state.custom
+	reset.private = state.private
+	reset.password = state.password
+	reset.creator = state.creator
+	reset.lastActive = state.lastActive
+	reset.handNumber = state.handNumber
+	reset.spectators = state.spectators
+	reset.clock = state.clock
+	reset.random = state.random
+	reset.clientPlayer = -1
+
+	for _, player := range state.Players {
+		if player.isBot || player.Status == STATUS_LEFT || len(reset.Players) >= reset.maxPlayers() {
+			continue
+		}
+		if !player.tournament && !state.gameOver {
+			player.Purse += player.Bet + player.totalBet
+			recordPurse(&player)
+		}
+
+		reset.addPlayer(player.Name, false)
+		seat := &reset.Players[len(reset.Players)-1]
+		seat.token = player.token
+		seat.lastPing = player.lastPing
+	}
+	if len(reset.Players) < 2 {
+		reset.LastResult = WAITING_MESSAGE
+	}
+
+	log.Printf("Table %s started over", state.table)
+	return reset
+}
+
+// Changes the table of the request and returns the table as it is after. An html form is sent back to the dashboard
+func updateTable(c *gin.Context, update func(state *GameState) (*GameState, error)) {
+	state, unlock := getTableState(clientRequest{table: c.Request.FormValue("table")})
+	var err error
+	func() {
+		defer unlock()
+
+		if state == nil {
+			return
+		}
+		if state, err = update(state); err == nil {
+			saveState(state)
+			state.updateLobby()
+		}
+	}()
+
+	switch {
+	case state == nil:
+		c.String(http.StatusNotFound, "Table not found")
+	case errors.Is(err, errPlayerNotFound):
+		c.String(http.StatusNotFound, err.Error())
+	case err != nil:
+		c.String(http.StatusBadRequest, err.Error())
+	case c.Request.FormValue("dashboard") == "1":
+		c.Redirect(http.StatusSeeOther, "/admin")
+	default:
+		serializeResults(c, state.adminView())
+	}
+}
+
+// Lists every table with its internal state
+func apiAdminTables(c *gin.Context) {
+	serializeResults(c, adminTables())
+}
+
+// Removes "player" from "table"
+func apiAdminKick(c *gin.Context) {
+	updateTable(c, func(state *GameState) (*GameState, error) {
+		return state, state.kickPlayer(c.Request.FormValue("player"))
+	})
+}
+
+// Starts "table" over
+func apiAdminReset(c *gin.Context) {
+	updateTable(c, func(state *GameState) (*GameState, error) {
+		return state.resetTable(), nil
+	})
+}
+
+// Sets the purse of "player" at "table" to "purse"
+func apiAdminPurse(c *gin.Context) {
+	updateTable(c, func(state *GameState) (*GameState, error) {
+		purse, err := strconv.Atoi(c.Request.FormValue("purse"))
+		if err != nil {
+			return state, errors.New("Invalid purse")
+		}
+		return state, state.setPurse(c.Request.FormValue("player"), purse)
+	})
+}
+
+func apiAdminPause(c *gin.Context) {
+	updateTable(c, func(state *GameState) (*GameState, error) {
+		state.pause()
+		return state, nil
+	})
+}
+
+func apiAdminResume(c *gin.Context) {
+	updateTable(c, func(state *GameState) (*GameState, error) {
+		state.resume()
+		return state, nil
+	})
+}
+
+// Adds "add" bots to, or removes "remove" bots from, "table"
+func apiAdminBots(c *gin.Context) {
+	updateTable(c, func(state *GameState) (*GameState, error) {
+		if remove := c.Request.FormValue("remove"); remove != "" {
+			count, _ := strconv.Atoi(remove)
+			return state, state.removeBots(count)
+		}
+		count, _ := strconv.Atoi(c.Request.FormValue("add"))
+		return state, state.addBots(count)
+	})
+}
+
+// Shows the tables with a form for each action
+func apiAdminDashboard(c *gin.Context) {
+	TableTemplate := `
+<tr class='table'>
+	<td colspan='5'>%s <span class='id'>%s</span><br/>%s %s - hand %d, round %d, pot %d, deck %d/%d%s</td>
+	<td colspan='2'>%s %s %s %s</td>
+</tr>
+`
+	PlayerTemplate := `
+<tr>
+	<td class='player'>%s</td>
+	<td>%s</td>
+	<td>%s</td>
+	<td>%d</td>
+	<td>%s</td>
+	<td>%s</td>
+	<td>%s</td>
+</tr>
+`
+
+	var rows string
+	for _, table := range adminTables() {
+		status := ""
+		if table.Paused {
+			status = " - PAUSED"
+		}
+		pause := adminForm("pause", table.Table, "", "Pause")
+		if table.Paused {
+			pause = adminForm("resume", table.Table, "", "Resume")
+		}
+
+		rows += fmt.Sprintf(TableTemplate, html.EscapeString(table.Name), table.Table, table.Variant, html.EscapeString(table.Stakes),
+			table.Hand, table.Round, table.Pot, table.DeckIndex, len(table.Deck)/2, status,
+			pause,
+			adminForm("reset", table.Table, "", "Reset"),
+			adminForm("bots", table.Table, "<input type='hidden' name='add' value='1'/>", "+Bot"),
+			adminForm("bots", table.Table, "<input type='hidden' name='remove' value='1'/>", "-Bot"))
+
+		for _, player := range table.Players {
+			kind := "Human"
+			if player.Bot {
+				kind = "Bot " + player.Strategy
+			}
+			name := "<input type='hidden' name='player' value='" + html.EscapeString(player.Name) + "'/>"
+			rows += fmt.Sprintf(PlayerTemplate, html.EscapeString(player.Name), kind, statusNames[player.Status], player.Bet, player.Cards,
+				adminForm("purse", table.Table, name+fmt.Sprintf("<input type='number' name='purse' min='0' value='%d'/>", player.Purse), "Set"),
+				adminForm("kick", table.Table, name, "Kick"))
+		}
+	}
+
+	if len(rows) == 0 {
+		rows = "<tr><td colspan='7'>No tables.</td></tr>"
+	}
+
+	result := bytes.ReplaceAll(ADMIN_HTML, []byte("$$TABLES$$"), []byte(rows))
+	c.Data(http.StatusOK, gin.MIMEHTML, result)
+}
+
+// A form that posts an admin action for the table back to the dashboard
+func adminForm(action string, table string, inputs string, label string) string {
+	return fmt.Sprintf("<form method='post' action='/admin/%s'><input type='hidden' name='table' value='%s'/><input type='hidden' name='dashboard' value='1'/>%s<button>%s</button></form>",
+		action, html.EscapeString(table), inputs, label)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Player tokens - a human player gets a secret token with their seat when they first join a table. It is
+// returned in `k` of the state, only to requests that have it, and /move and /leave need it in the `k`
+// query parameter, so nobody can move or leave for a player by passing their name.
+//
+// If IDENTITY_URL is set, a player can only sit down with a token the identity service accepts, and that
+// token is used for the seat. The service is called with GET IDENTITY_URL?player=X&token=Y and must return
+// 200 for a valid token.
+
+const TOKEN_BYTES = 5 // 8 characters
+const IDENTITY_TIMEOUT = 5 * time.Second
+const IDENTITY_CACHE_TIME = 10 * time.Minute
+
+var IdentityURL string
+
+var identityClient = &http.Client{Timeout: IDENTITY_TIMEOUT}
+var identityCache sync.Map // "player token" -> time validated
+
+// Returns a new random token, upper case letters and digits so it survives uc=1/lc=1
+func newToken() string {
+	token := make([]byte, TOKEN_BYTES)
+	if _, err := rand.Read(token); err != nil {
+		panic(err)
+	}
+	return base32.StdEncoding.EncodeToString(token)
+}
+
+// Tokens are compared case insensitive, since 8-bit clients may change the case
+func tokenMatches(player *Player, token string) bool {
+	return player.token != "" && strings.EqualFold(player.token, token)
+}
+
+// A request that names a seat without its token only views the table: it sees no down cards,
+// doesn't keep the seat from timing out and doesn't advance the game
+func (state *GameState) requireToken() {
+	if !state.authorized {
+		state.clientPlayer = -1
+		state.clientSpectator = -1
+	}
+}
+
+// Returns the token a new player sits down with, or false if the identity service rejected them
+func seatToken(request clientRequest) (string, bool) {
+	if IdentityURL == "" {
+		return newToken(), true
+	}
+	return request.token, request.identified
+}
+
+// Asks the identity service if the token belongs to the player. Valid tokens are cached for a while.
+// Called before the table is locked, so a slow service never holds up the table
+func validateIdentity(playerName string, token string) bool {
+	key := strings.ToLower(playerName) + " " + token
+	if validated, ok := identityCache.Load(key); ok && time.Since(validated.(time.Time)) < IDENTITY_CACHE_TIME {
+		return true
+	}
+
+	response, err := identityClient.Get(IdentityURL + "?" + url.Values{"player": {playerName}, "token": {token}}.Encode())
+	if err != nil {
+		log.Printf("Unable to validate the token of %s: %s", playerName, err)
+		return false
+	}
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return false
+	}
+
+	identityCache.Store(key, time.Now())
+	return true
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ericcarrgh/cardrank"
+	"github.com/goccy/go-json"
+)
+
+// Player bankrolls - a human player's purse follows them between tables and sessions.
+// A player joining any table sits down with their bankroll instead of the table's starting purse,
+// and the bankroll is updated at the end of every game and when the player leaves or is dropped.
+// A name only has one seat with its bankroll at a time, so the same chips can't be played at two tables:
+// sitting down at a second table is refused until the player is dropped from the first. A request claims the
+// seat while it holds the table, and the seats follow the saved table once it lets go, so a request that
+// doesn't save gives its claim back.
+// Bankrolls are keyed by the case insensitive player name, and saved to the state store, if any.
+
+const LEADERBOARD_SIZE = 10
+
+type Bankroll struct {
+	Name      string            `json:"name"`
+	Purse     int               `json:"purse"`
+	HandsWon  int               `json:"handsWon"`
+	BestHand  string            `json:"bestHand"`
+	BestCards string            `json:"bestCards"`
+	BestRank  cardrank.EvalRank `json:"bestRank"`
+}
+
+var bankrolls = map[string]*Bankroll{}
+var bankrollMutex sync.Mutex
+
+// The table each name is seated at with their bankroll, kept in step with the saved tables
+var seats = map[string]string{}
+var seatsMutex sync.Mutex
+
+// The leaderboard. List keys are 2 characters, as in the game state
+type Leaderboard struct {
+	Purses    []LeaderboardValue `json:"lp"`
+	HandsWon  []LeaderboardValue `json:"lw"`
+	BestHands []LeaderboardHand  `json:"lh"`
+}
+
+type LeaderboardValue struct {
+	Name  string `json:"n"`
+	Value int    `json:"v"`
+}
+
+type LeaderboardHand struct {
+	Name  string `json:"n"`
+	Hand  string `json:"h"`
+	Cards string `json:"c"`
+}
+
+// Returns the purse a player sits down with. A player without enough chips to ante gets a fresh purse.
+func bankrollPurse(playerName string, startingPurse int) int {
+	bankrollMutex.Lock()
+	defer bankrollMutex.Unlock()
+
+	bankroll, ok := bankrolls[strings.ToLower(playerName)]
+	if !ok || bankroll.Purse <= 2 {
+		return startingPurse
+	}
+	return bankroll.Purse
+}
+
+// Reserves a seat at table for the player. Returns false if the player is seated at another table
+func claimSeat(playerName string, table string) bool {
+	seatsMutex.Lock()
+	defer seatsMutex.Unlock()
+
+	key := strings.ToLower(playerName)
+	if seated, ok := seats[key]; ok && seated != table {
+		return false
+	}
+	seats[key] = table
+	return true
+}
+
+// Sets the seats of the table to the human players at it, releasing the seats of the players that were dropped.
+// A tournament purse is not the bankroll, so tournament players don't take a seat
+func updateSeats(state *GameState) {
+	seatsMutex.Lock()
+	defer seatsMutex.Unlock()
+
+	for key, table := range seats {
+		if table == state.table {
+			delete(seats, key)
+		}
+	}
+	for _, player := range state.Players {
+		if !player.isBot && !player.tournament {
+			seats[strings.ToLower(player.Name)] = state.table
+		}
+	}
+}
+
+// Releases every seat of the table, when it is removed
+func releaseSeats(table string) {
+	seatsMutex.Lock()
+	defer seatsMutex.Unlock()
+
+	for key, seated := range seats {
+		if seated == table {
+			delete(seats, key)
+		}
+	}
+}
+
+// Updates the bankroll of a human player, creating it if needed
+func updateBankroll(player *Player, update func(*Bankroll)) {
+	if player.isBot {
+		return
+	}
+
+	bankrollMutex.Lock()
+	defer bankrollMutex.Unlock()
+
+	key := strings.ToLower(player.Name)
+	bankroll, ok := bankrolls[key]
+	if !ok {
+		bankroll = &Bankroll{BestRank: cardrank.Invalid}
+		bankrolls[key] = bankroll
+	}
+
+	bankroll.Name = player.Name
+	if !player.tournament {
+		bankroll.Purse = player.Purse
+	}
+	if update != nil {
+		update(bankroll)
+	}
+
+	persistBankroll(key, bankroll)
+}
+
+// Keeps the player's purse
+func recordPurse(player *Player) {
+	updateBankroll(player, nil)
+}
+
+// Counts a hand won by the player
+func recordWin(player *Player) {
+	updateBankroll(player, func(b *Bankroll) { b.HandsWon++ })
+}
+
+// Keeps the hand if it is the best the player has shown down
+func recordHand(player *Player, ev *cardrank.Eval) {
+	updateBankroll(player, func(b *Bankroll) {
+		if ev.HiRank < b.BestRank {
+			b.BestRank = ev.HiRank
+			b.BestHand = handDescription(ev)
+			b.BestCards = ""
+			for _, c := range ev.HiBest {
+				b.BestCards += strings.ToUpper(c.String())
+			}
+		}
+	})
+}
+
+func persistBankroll(key string, bankroll *Bankroll) {
+	if stateStore == nil {
+		return
+	}
+
+	data, err := json.Marshal(bankroll)
+	if err != nil {
+		log.Printf("Unable to persist the bankroll of %s: %s", bankroll.Name, err)
+		return
+	}
+	queueWrite(func(batch *storeBatch) { batch.Bankrolls[key] = data })
+}
+
+// Loads every bankroll from the store
+func loadBankrolls() {
+	saved, err := stateStore.LoadBankrolls()
+	if err != nil {
+		log.Printf("Unable to load the bankrolls: %s", err)
+		return
+	}
+
+	bankrollMutex.Lock()
+	defer bankrollMutex.Unlock()
+
+	for key, data := range saved {
+		bankroll := &Bankroll{}
+		if err := json.Unmarshal(data, bankroll); err != nil {
+			log.Printf("Unable to load the bankroll of %s: %s", key, err)
+			continue
+		}
+		bankrolls[key] = bankroll
+	}
+
+	log.Printf("Loaded %d bankrolls", len(bankrolls))
+}
+
+// Returns the top players by purse, hands won and best hand shown down
+func getLeaderboard() Leaderboard {
+	bankrollMutex.Lock()
+	all := []Bankroll{}
+	for _, bankroll := range bankrolls {
+		all = append(all, *bankroll)
+	}
+	bankrollMutex.Unlock()
+
+	// Sort by name first so ties are always listed in the same order
+	sort.Slice(all, func(i, j int) bool { return strings.ToLower(all[i].Name) < strings.ToLower(all[j].Name) })
+
+	leaderboard := Leaderboard{
+		Purses:    []LeaderboardValue{},
+		HandsWon:  []LeaderboardValue{},
+		BestHands: []LeaderboardHand{},
+	}
+
+	sort.SliceStable(all, func(i, j int) bool { return all[i].Purse > all[j].Purse })
+	for i := 0; i < len(all) && i < LEADERBOARD_SIZE; i++ {
+		leaderboard.Purses = append(leaderboard.Purses, LeaderboardValue{Name: all[i].Name, Value: all[i].Purse})
+	}
+
+	sort.SliceStable(all, func(i, j int) bool { return all[i].HandsWon > all[j].HandsWon })
+	for i := 0; i < len(all) && i < LEADERBOARD_SIZE && all[i].HandsWon > 0; i++ {
+		leaderboard.HandsWon = append(leaderboard.HandsWon, LeaderboardValue{Name: all[i].Name, Value: all[i].HandsWon})
+	}
+
+	sort.SliceStable(all, func(i, j int) bool { return all[i].BestRank < all[j].BestRank })
+	for i := 0; i < len(all) && len(leaderboard.BestHands) < LEADERBOARD_SIZE; i++ {
+		if all[i].BestHand != "" {
+			leaderboard.BestHands = append(leaderboard.BestHands, LeaderboardHand{Name: all[i].Name, Hand: all[i].BestHand, Cards: all[i].BestCards})
+		}
+	}
+
+	return leaderboard
+}
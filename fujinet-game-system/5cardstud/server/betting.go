@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// Betting structures - every table sets its own stakes and how much can be bet.
+// Limit tables bet fixed Low/High amounts (BL, BH, RA moves). Pot limit and no limit tables
+// bet or raise any amount between the minimum and the maximum with the BA move, e.g. BA25.
+// The amount is always the player's total bet this round, which becomes the table's current bet.
+// On any table, a player that can't afford a call, bet or raise can go all-in with the AI move.
+
+type BettingType string
+
+const (
+	BETTING_LIMIT     BettingType = "limit"
+	BETTING_POT_LIMIT BettingType = "pot"
+	BETTING_NO_LIMIT  BettingType = "nolimit"
+)
+
+type BettingStructure struct {
+	Type          BettingType `json:"type"`
+	Ante          int         `json:"ante"`
+	BringIn       int         `json:"bringIn"` // Also the small blind
+	Low           int         `json:"low"`     // Also the big blind, and the minimum bet
+	High          int         `json:"high"`    // Limit only
+	MaxRaises     int         `json:"maxRaises"`
+	StartingPurse int         `json:"startingPurse"`
+}
+
+// The original 1/2/5/10 limit game
+var limitStakes = BettingStructure{
+	Type:          BETTING_LIMIT,
+	Ante:          ANTE,
+	BringIn:       BRINGIN,
+	Low:           LOW,
+	High:          HIGH,
+	MaxRaises:     3,
+	StartingPurse: STARTING_PURSE,
+}
+
+var potLimitStakes = BettingStructure{
+	Type:          BETTING_POT_LIMIT,
+	Ante:          ANTE,
+	BringIn:       BRINGIN,
+	Low:           LOW,
+	MaxRaises:     3,
+	StartingPurse: STARTING_PURSE,
+}
+
+var noLimitStakes = BettingStructure{
+	Type:          BETTING_NO_LIMIT,
+	Ante:          ANTE,
+	BringIn:       BRINGIN,
+	Low:           LOW,
+	MaxRaises:     0, // unlimited
+	StartingPurse: STARTING_PURSE,
+}
+
+var bettingStructures = []BettingStructure{limitStakes, potLimitStakes, noLimitStakes}
+
+// Returns the betting structure of the given type, or false if there is none
+func getBettingStructure(bettingType string) (BettingStructure, bool) {
+	for _, betting := range bettingStructures {
+		if strings.EqualFold(string(betting.Type), bettingType) {
+			return betting, true
+		}
+	}
+	return BettingStructure{}, false
+}
+
+// Short description for the table list, e.g. "5/10 Limit"
+func (betting BettingStructure) String() string {
+	switch betting.Type {
+	case BETTING_POT_LIMIT:
+		return fmt.Sprintf("%d/%d Pot Limit", betting.BringIn, betting.Low)
+	case BETTING_NO_LIMIT:
+		return fmt.Sprintf("%d/%d No Limit", betting.BringIn, betting.Low)
+	}
+	return fmt.Sprintf("%d/%d Limit", betting.Low, betting.High)
+}
+
+// If another raise is allowed this round
+func (state *GameState) canRaise() bool {
+	return state.betting.MaxRaises == 0 || state.raiseCount < state.betting.MaxRaises
+}
+
+// Returns the min and max total bet the active player can make with a BA move
+func (state *GameState) betRange() (int, int) {
+	player := state.Players[state.ActivePlayer]
+	toCall := state.currentBet - player.Bet
+
+	// A raise must be at least the size of the previous one
+	raise := state.betting.Low
+	if state.raiseAmount > raise {
+		raise = state.raiseAmount
+	}
+
+	// A bet completes the bring-in, if any, to the minimum
+	min := raise
+	if state.currentBet >= state.betting.Low {
+		min += state.currentBet
+	}
+
+	// Every chip the player has
+	max := player.Bet + player.Purse
+	if state.betting.Type == BETTING_POT_LIMIT {
+		// Raising by the pot after calling
+		if pot := state.currentBet + state.potSize() + toCall; pot < max {
+			max = pot
+		}
+	}
+
+	return min, max
+}
+
+// The pot including the bets of this round
+func (state *GameState) potSize() int {
+	pot := state.Pot
+	for _, player := range state.Players {
+		pot += player.Bet
+	}
+	return pot
+}
+
+// Adds the BA move if the player can bet or raise
+func (state *GameState) appendBetAmountMove(moves []validMove) []validMove {
+	if !state.canRaise() {
+		return moves
+	}
+
+	min, max := state.betRange()
+	if max < min {
+		return moves
+	}
+
+	name := "Bet"
+	if state.currentBet >= state.betting.Low {
+		name = "Raise"
+	}
+
+	return append(moves, validMove{Move: "BA", Name: fmt.Sprintf("%s %d-%d", name, min, max)})
+}
+
+// Adds the AI move when the player has chips left but can't afford a full call, or a full bet or raise
+func (state *GameState) appendAllInMove(moves []validMove) []validMove {
+	player := state.Players[state.ActivePlayer]
+	toCall := state.currentBet - player.Bet
+
+	canBet := slices.ContainsFunc(moves, func(m validMove) bool {
+		return m.Move == "BL" || m.Move == "BH" || m.Move == "RA" || m.Move == "BA"
+	})
+
+	if player.Purse > 0 && (player.Purse < toCall || (!canBet && state.canRaise() && player.Purse > toCall)) {
+		moves = append(moves, validMove{Move: "AI", Name: fmt.Sprint("All-in ", player.Purse)})
+	}
+	return moves
+}
+
+// Parses the amount of a BA move. No amount is the minimum bet
+func (state *GameState) parseBetAmount(amount string) (int, bool) {
+	min, max := state.betRange()
+	if amount == "" {
+		return min, true
+	}
+
+	value, err := strconv.Atoi(amount)
+	if err != nil || value < min || value > max {
+		return 0, false
+	}
+	return value, true
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/ericcarrgh/cardrank"
+	"golang.org/x/exp/slices"
+)
+
+// Bot strategies - every bot plays with a strategy, picked by the bot mix of its table.
+// A strategy only picks one of the valid moves for the active player. Bots discard in the draw round with botDiscards.
+
+type BotStrategy interface {
+	// Used to select the strategy, e.g. in the state file and SIMULATE_BOTS
+	Name() string
+
+	// Returns the move to make, one of the valid moves. A BA move may be followed by the amount
+	ChooseMove(state *GameState, moves []validMove) string
+}
+
+const MONTE_CARLO_TRIALS = 150
+
+var botStrategies = []BotStrategy{classicBot{}, tightBot{}, looseAggressiveBot{}, monteCarloBot{Trials: MONTE_CARLO_TRIALS}}
+
+// The bots of tables that do not say otherwise
+var defaultBotMix = []BotStrategy{monteCarloBot{Trials: MONTE_CARLO_TRIALS}, looseAggressiveBot{}, tightBot{}}
+
+// Returns the strategy with the given name, or nil if there is none
+func getBotStrategy(name string) BotStrategy {
+	for _, strategy := range botStrategies {
+		if strings.EqualFold(strategy.Name(), name) {
+			return strategy
+		}
+	}
+	return nil
+}
+
+// Returns the strategies of count bots, taking turns through the mix (the default mix if none is given)
+func mixedBots(count int, mix ...BotStrategy) []BotStrategy {
+	if len(mix) == 0 {
+		mix = defaultBotMix
+	}
+
+	bots := []BotStrategy{}
+	for i := 0; i < count; i++ {
+		bots = append(bots, mix[i%len(mix)])
+	}
+	return bots
+}
+
+// The strategy of a bot. Bots restored from older state files play the classic strategy
+func (player *Player) botStrategy() BotStrategy {
+	if player.strategy == nil {
+		return classicBot{}
+	}
+	return player.strategy
+}
+
+// Classic - the original bot logic: simple rules on its own cards, sometimes random
+
+type classicBot struct{}
+
+func (classicBot) Name() string { return "classic" }
+
+func (classicBot) ChooseMove(state *GameState, moves []validMove) string {
+	cards := state.Players[state.ActivePlayer].cards
+
+	// Default to FOLD
+	choice := 0
+
+	// Never fold if CHECK is an option
+	if len(moves) > 1 && moves[1].Move == "CH" {
+		choice = 1
+	}
+
+	// Hardly ever fold early if a BOT has an jack or higher.
+	if state.Round < 3 && len(moves) > 1 && state.intn(3) > 0 && slices.ContainsFunc(cards, func(c card) bool { return c.value > 10 }) {
+		choice = 1
+	}
+
+	// Likely don't fold if BOT has a pair or better
+	rank := getRank(state.playerCards(&state.Players[state.ActivePlayer]))
+	if rank[0] < 300 && state.intn(20) > 0 {
+		choice = 1
+	}
+
+	// Don't fold if BOT has a 2 pair or better
+	if rank[0] < 200 {
+		choice = 1
+	}
+
+	// Raise the bet if three of a kind or better
+	if len(moves) > 2 && rank[0] < 312 && state.currentBet < state.betting.Low {
+		choice = 2
+	} else if len(moves) > 2 && state.getPlayerWithBestVisibleHand(true) == state.ActivePlayer && state.currentBet < 2*state.betting.Low && (rank[0] < 306) {
+		choice = len(moves) - 1
+	} else {
+
+		// Consider bet/call/raise most of the time
+		if len(moves) > 1 && state.intn(3) > 0 && (len(cards) > 2 ||
+			cards[0].value == cards[1].value ||
+			math.Abs(float64(cards[1].value-cards[0].value)) < 3 ||
+			cards[0].value > 8 ||
+			cards[1].value > 5) {
+
+			// Avoid endless raises
+			if state.currentBet >= 4*state.betting.Low || state.intn(3) > 0 {
+				choice = 1
+			} else {
+				choice = state.intn(len(moves)-1) + 1
+			}
+
+		}
+	}
+
+	// Bounds check - clamp the move to the end of the array if a higher move is desired.
+	// This may occur if a bot wants to call, but cannot, due to limited funds.
+	if choice > len(moves)-1 {
+		choice = len(moves) - 1
+	}
+
+	return moves[choice].Move
+}
+
+// Tight - only plays hands that beat everything showing on the table, and bets them hard
+
+type tightBot struct{}
+
+func (tightBot) Name() string { return "tight" }
+
+func (tightBot) ChooseMove(state *GameState, moves []validMove) string {
+	player := &state.Players[state.ActivePlayer]
+	mine, showing := state.handStrengths(player)
+	toCall := state.currentBet - player.Bet
+
+	switch {
+	case mine >= 2 && mine > showing:
+		return state.botRaise(moves, state.potSize()/2)
+	case mine >= 1 && mine > showing:
+		return botCall(moves)
+	case state.Round == 1 && highCards(player.cards) >= 2 && toCall <= state.betting.Low:
+		return botCall(moves)
+	}
+	return botFold(moves)
+}
+
+// Loose-aggressive - plays most hands, bets anything it is not beaten on, and bluffs
+
+type looseAggressiveBot struct{}
+
+func (looseAggressiveBot) Name() string { return "loose" }
+
+func (looseAggressiveBot) ChooseMove(state *GameState, moves []validMove) string {
+	player := &state.Players[state.ActivePlayer]
+	mine, showing := state.handStrengths(player)
+	toCall := state.currentBet - player.Bet
+	pot := state.potSize()
+
+	// Do not keep re-raising with nothing
+	if state.raiseCount < 2 && mine >= showing && (mine >= 1 || highCards(player.cards) >= 1 || state.intn(10) < 3) {
+		return state.botRaise(moves, pot)
+	}
+	if mine >= showing || toCall <= pot/2 {
+		return botCall(moves)
+	}
+	return botFold(moves)
+}
+
+// Monte Carlo - deals the cards it can't see many times to estimate how often it wins,
+// and plays when that beats the pot odds
+
+type monteCarloBot struct {
+	Trials int
+}
+
+func (monteCarloBot) Name() string { return "montecarlo" }
+
+func (bot monteCarloBot) ChooseMove(state *GameState, moves []validMove) string {
+	player := &state.Players[state.ActivePlayer]
+	equity := state.estimateEquity(state.ActivePlayer, bot.Trials)
+	toCall := state.currentBet - player.Bet
+	pot := state.potSize()
+
+	// A fair share of the pot is 1/players. Raise when well above it
+	fair := 1 / float64(state.playersInGame())
+	strong := fair + (1-fair)/3
+
+	if equity >= strong && (state.raiseCount < 2 || equity > 0.8) {
+		return state.botRaise(moves, int(float64(pot)*equity))
+	}
+
+	// Call when the chance of winning beats the price of the call. A little looser before the last round,
+	// since there are cards to come
+	if toCall == 0 || equity*float64(pot+toCall) >= float64(toCall) ||
+		(state.Round < state.variant.Rounds && equity*1.25*float64(pot+toCall) >= float64(toCall)) {
+		// Sometimes bet a medium hand when nobody else did
+		if toCall == 0 && equity >= fair && state.intn(5) == 0 {
+			return state.botRaise(moves, pot/2)
+		}
+		return botCall(moves)
+	}
+	return botFold(moves)
+}
+
+// Returns the chance (0-1) the player wins the pot at the showdown, by dealing the cards
+// the player can't see (down cards of others, cards still to come) trials times
+func (state *GameState) estimateEquity(index int, trials int) float64 {
+	player := &state.Players[index]
+	finalHand := len(state.variant.down)
+	finalBoard := strings.Count(strings.Join(state.variant.Deal, ""), "b")
+
+	// The cards the player knows about - their own, the board and the up cards of the others
+	known := map[card]bool{}
+	for _, c := range player.cards {
+		known[c] = true
+	}
+	for _, c := range state.board {
+		known[c] = true
+	}
+
+	opponents := [][]card{}
+	for i := range state.Players {
+		if i != index && state.Players[i].Status == STATUS_PLAYING {
+			visible := state.visibleCards(&state.Players[i])
+			for _, c := range visible {
+				known[c] = true
+			}
+			opponents = append(opponents, visible)
+		}
+	}
+
+	unknown := []card{}
+	for suit := 0; suit < 4; suit++ {
+		for value := 2; value < 15; value++ {
+			if c := (card{value: value, suit: suit}); !known[c] {
+				unknown = append(unknown, c)
+			}
+		}
+	}
+
+	// Not enough cards to deal (should not happen), or nobody to beat
+	needed := finalHand - len(player.cards) + finalBoard - len(state.board)
+	for _, visible := range opponents {
+		needed += finalHand - len(visible)
+	}
+	if len(opponents) == 0 || needed > len(unknown) || trials <= 0 {
+		return 1 / float64(len(opponents)+1)
+	}
+
+	wins := 0.0
+	for trial := 0; trial < trials; trial++ {
+		state.shuffleRandom(len(unknown), func(i, j int) { unknown[i], unknown[j] = unknown[j], unknown[i] })
+		next := 0
+		deal := func(cards []card, count int) []card {
+			dealt := append([]card{}, cards...)
+			for len(dealt) < count {
+				dealt = append(dealt, unknown[next])
+				next++
+			}
+			return dealt
+		}
+
+		pockets := [][]cardrank.Card{toCardrank(deal(player.cards, finalHand))}
+		for _, visible := range opponents {
+			pockets = append(pockets, toCardrank(deal(visible, finalHand)))
+		}
+
+		var board []cardrank.Card
+		if finalBoard > 0 {
+			board = toCardrank(deal(state.board, finalBoard))
+		}
+
+		order, pivot := cardrank.Order(state.variant.Eval.EvalPockets(pockets, board), false)
+		for i := 0; i < pivot; i++ {
+			if order[i] == 0 {
+				wins += 1 / float64(pivot)
+			}
+		}
+	}
+
+	return wins / float64(trials)
+}
+
+var cardrankCards = map[card]cardrank.Card{}
+
+func init() {
+	for suit := 0; suit < 4; suit++ {
+		for value := 2; value < 15; value++ {
+			c := card{value: value, suit: suit}
+			cardrankCards[c] = cardrank.Must(cardsToString([]card{c}))[0]
+		}
+	}
+}
+
+func toCardrank(cards []card) []cardrank.Card {
+	result := make([]cardrank.Card, len(cards))
+	for i, c := range cards {
+		result[i] = cardrankCards[c]
+	}
+	return result
+}
+
+// Rough strength of the player's cards and of the best hand showing on the table, counting sets only:
+// 0 high card, 1 pair, 2 two pair, 3 three of a kind, 4 full house, 5 four of a kind
+func (state *GameState) handStrengths(player *Player) (int, int) {
+	mine := setStrength(state.playerCards(player))
+	showing := setStrength(state.board)
+
+	for i := range state.Players {
+		other := &state.Players[i]
+		if other != player && other.Status == STATUS_PLAYING {
+			if strength := setStrength(append(state.visibleCards(other), state.board...)); strength > showing {
+				showing = strength
+			}
+		}
+	}
+	return mine, showing
+}
+
+func setStrength(cards []card) int {
+	sets := map[int]int{}
+	for _, c := range cards {
+		sets[c.value]++
+	}
+
+	pairs, trips, quads := 0, 0, 0
+	for _, count := range sets {
+		switch {
+		case count >= 4:
+			quads++
+		case count == 3:
+			trips++
+		case count == 2:
+			pairs++
+		}
+	}
+
+	switch {
+	case quads > 0:
+		return 5
+	case trips > 0 && (pairs > 0 || trips > 1):
+		return 4
+	case trips > 0:
+		return 3
+	case pairs > 1:
+		return 2
+	case pairs > 0:
+		return 1
+	}
+	return 0
+}
+
+// Number of cards jack or higher
+func highCards(cards []card) int {
+	count := 0
+	for _, c := range cards {
+		if c.value > 10 {
+			count++
+		}
+	}
+	return count
+}
+
+// Number of players still playing this game
+func (state *GameState) playersInGame() int {
+	count := 0
+	for _, player := range state.Players {
+		if player.Status == STATUS_PLAYING {
+			count++
+		}
+	}
+	return count
+}
+
+// Moves a strategy wants to make, falling back to the closest valid move
+
+// Checks if possible, otherwise folds. A forced bring-in is posted
+func botFold(moves []validMove) string {
+	for _, move := range []string{"CH", "FO"} {
+		if slices.ContainsFunc(moves, func(m validMove) bool { return m.Move == move }) {
+			return move
+		}
+	}
+	return moves[0].Move
+}
+
+// Checks or calls, going all-in when short
+func botCall(moves []validMove) string {
+	for _, move := range []string{"CH", "CA", "BB", "AI"} {
+		if slices.ContainsFunc(moves, func(m validMove) bool { return m.Move == move }) {
+			return move
+		}
+	}
+	return botFold(moves)
+}
+
+// Bets or raises about amount on top of the current bet. Limit tables bet the fixed amounts
+func (state *GameState) botRaise(moves []validMove, amount int) string {
+	if slices.ContainsFunc(moves, func(m validMove) bool { return m.Move == "BA" }) {
+		min, max := state.betRange()
+		return fmt.Sprint("BA", clamp(state.currentBet+amount, min, max))
+	}
+
+	for _, move := range []string{"RA", "BH", "BL", "AI"} {
+		if slices.ContainsFunc(moves, func(m validMove) bool { return m.Move == move }) {
+			return move
+		}
+	}
+	return botCall(moves)
+}
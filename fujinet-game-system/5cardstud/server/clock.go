@@ -0,0 +1,68 @@
+package main
+
+import (
+	"time"
+)
+
+// Clock and randomness - the game never reads the time or draws random numbers on its own, it asks the
+// clock and random source of its table. The server runs on the system clock with random seeds, and every seed
+// is logged and kept in the hand history (see fairness.go). The seed of a game shuffles its deck and drives the
+// decisions of its bots, so with a fake clock and the same seeds, a reported hand plays out exactly again.
+
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// Source of the seed of each game, and of the deck shuffled with it
+type RandomSource interface {
+	NewSeed() string
+	Shuffle(seed string) []card
+}
+
+type cryptoRandom struct{}
+
+func (cryptoRandom) NewSeed() string { return newSeed() }
+
+func (cryptoRandom) Shuffle(seed string) []card { return shuffleDeck(seed) }
+
+// The time on the clock of the table
+func (state *GameState) now() time.Time {
+	if state.clock == nil {
+		return time.Now()
+	}
+	return state.clock.Now()
+}
+
+func (state *GameState) newSeed() string {
+	if state.random == nil {
+		return newSeed()
+	}
+	return state.random.NewSeed()
+}
+
+// The deck of the game with the seed
+func (state *GameState) shuffledDeck(seed string) []card {
+	if state.random == nil {
+		return shuffleDeck(seed)
+	}
+	return state.random.Shuffle(seed)
+}
+
+// Returns a number from 0 to n-1 for the bots, drawn from the seed of the game
+func (state *GameState) intn(n int) int {
+	if state.botRandom == nil {
+		state.botRandom = &seedStream{seed: state.seed + ":bots"}
+	}
+	return state.botRandom.intn(n)
+}
+
+// Shuffles n items with the numbers of intn
+func (state *GameState) shuffleRandom(n int, swap func(i, j int)) {
+	for i := n - 1; i > 0; i-- {
+		swap(i, state.intn(i+1))
+	}
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-json"
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+)
+
+// Server config - the tables, bot names and lobby details of an instance can be read from a YAML or JSON file
+// (by its .json extension) set with the CONFIG_FILE env variable, so every regional instance runs the same build.
+// Anything the file leaves out keeps the built-in default below, see config.example.yaml.
+//
+// The file is checked for changes every CONFIG_RELOAD_INTERVAL. A changed file that is valid is applied
+// while the server runs: new tables open, removed tables close, and a table whose variant, stakes or bots
+// changed starts over. Bot names only change on a restart. An invalid file is logged and ignored.
+
+const CONFIG_RELOAD_INTERVAL = 10 * time.Second
+const BOT_NAME_LENGTH = 4 // " BOT" is added, and player names are 8 characters in the binary state
+
+type Config struct {
+	Lobby    LobbyConfig   `json:"lobby" yaml:"lobby"`
+	BotNames []string      `json:"botNames" yaml:"botNames"`
+	Tables   []TableConfig `json:"tables" yaml:"tables"`
+}
+
+type LobbyConfig struct {
+	Endpoint  string       `json:"endpoint" yaml:"endpoint"`   // Where table updates are posted, when GO_PROD=1
+	Region    string       `json:"region" yaml:"region"`       // e.g. "us"
+	ServerURL string       `json:"serverUrl" yaml:"serverUrl"` // Url of this server, for the clients
+	Clients   []GameClient `json:"clients" yaml:"clients"`     // Where each platform downloads the client
+}
+
+type TableConfig struct {
+	Name    string   `json:"name" yaml:"name"`
+	ID      string   `json:"id" yaml:"id"`
+	Variant string   `json:"variant" yaml:"variant"` // Variant code, 5cs by default
+	Stakes  string   `json:"stakes" yaml:"stakes"`   // limit (default), pot or nolimit
+	Bots    int      `json:"bots" yaml:"bots"`
+	BotMix  []string `json:"botMix" yaml:"botMix"` // Strategies the bots take turns with. The default mix if empty
+	Lobby   bool     `json:"lobby" yaml:"lobby"`   // Send the table to the lobby
+}
+
+// The tables of the live server, and its lobby details
+var defaultConfig = Config{
+	Lobby: LobbyConfig{
+		Endpoint:  LOBBY_ENDPOINT_UPSERT,
+		Region:    DefaultGameServerDetails.Region,
+		ServerURL: DefaultGameServerDetails.Serverurl,
+		Clients:   DefaultGameServerDetails.Clients,
+	},
+	BotNames: append([]string{}, botNames...),
+	Tables: append([]TableConfig{
+		{Name: "The Basement", ID: "basement", Lobby: true},
+		{Name: "The Den", ID: "den", Lobby: true},
+		// Each table picks its bot mix. The 2 bot room plays the easier classic and loose bots
+		{Name: "AI Room - 2 bots", ID: "ai2", Bots: 2, BotMix: []string{"classic", "loose"}, Lobby: true},
+		{Name: "AI Room - 4 bots", ID: "ai4", Bots: 4, Lobby: true},
+		{Name: "AI Room - 6 bots", ID: "ai6", Bots: 6, Lobby: true},
+
+		// Other variants. These are not sent to the lobby, since its clients only play 5 Card Stud
+		{Name: "7 Card Stud - 3 bots", ID: "7cs", Variant: "7cs", Bots: 3, Lobby: true},
+		{Name: "5 Card Draw - 3 bots", ID: "5cd", Variant: "5cd", Stakes: "pot", Bots: 3, Lobby: true},
+		{Name: "Texas Hold'em - 3 bots", ID: "holdem", Variant: "holdem", Bots: 3, Lobby: true},
+		{Name: "No Limit Hold'em - 3 bots", ID: "holdemnl", Variant: "holdem", Stakes: "nolimit", Bots: 3, Lobby: true},
+	}, devTables()...),
+}
+
+// For client developers, hidden tables for each # of bots (for ease of testing with a specific # of players in the game)
+// These do not update the lobby
+func devTables() []TableConfig {
+	tables := []TableConfig{}
+	for i := 1; i < 8; i++ {
+		tables = append(tables, TableConfig{Name: fmt.Sprintf("Dev Room - %d bots", i), ID: fmt.Sprintf("dev%d", i), Bots: i, BotMix: []string{"classic"}})
+	}
+	return tables
+}
+
+var currentConfig Config
+var configMutex sync.Mutex
+
+var tableIDPattern = regexp.MustCompile(`^[a-z0-9]{1,8}$`)
+
+func getConfig() Config {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	return currentConfig
+}
+
+// Sets the config, returning the previous one
+func setConfig(config Config) Config {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	previous := currentConfig
+	currentConfig = config
+	return previous
+}
+
+// Loads the config file, or the default config if there is none. The server does not start with an invalid file
+func initializeConfig(path string) {
+	config := defaultConfig
+	if path != "" {
+		var err error
+		if config, err = loadConfig(path); err != nil {
+			log.Fatalf("Invalid config %s: %s", path, err)
+		}
+		log.Printf("Loaded config %s with %d tables", path, len(config.Tables))
+	}
+
+	config.setDefaults()
+	setConfig(config)
+	botNames = append([]string{}, config.BotNames...)
+}
+
+// Reads and validates a config file
+func loadConfig(path string) (Config, error) {
+	config := Config{}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&config)
+	} else {
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&config)
+	}
+
+	// An empty file keeps all the defaults
+	if err != nil && err != io.EOF {
+		return config, err
+	}
+
+	config.setDefaults()
+	return config, config.validate()
+}
+
+// Fills in the defaults for anything left out
+func (config *Config) setDefaults() {
+	lobby := &config.Lobby
+	if lobby.Endpoint == "" {
+		lobby.Endpoint = defaultConfig.Lobby.Endpoint
+	}
+	if lobby.Region == "" {
+		lobby.Region = defaultConfig.Lobby.Region
+	}
+	if lobby.ServerURL == "" {
+		lobby.ServerURL = defaultConfig.Lobby.ServerURL
+	}
+	if len(lobby.Clients) == 0 {
+		lobby.Clients = defaultConfig.Lobby.Clients
+	}
+	if len(config.BotNames) == 0 {
+		config.BotNames = defaultConfig.BotNames
+	}
+	if len(config.Tables) == 0 {
+		config.Tables = defaultConfig.Tables
+	}
+
+	tables := []TableConfig{}
+	for _, table := range config.Tables {
+		if table.Variant == "" {
+			table.Variant = defaultVariant.Code
+		}
+		if table.Stakes == "" {
+			table.Stakes = string(BETTING_LIMIT)
+		}
+		tables = append(tables, table)
+	}
+	config.Tables = tables
+}
+
+// Returns every problem with the config
+func (config *Config) validate() error {
+	problems := []error{}
+	invalid := func(format string, args ...any) {
+		problems = append(problems, fmt.Errorf(format, args...))
+	}
+
+	for _, link := range []string{config.Lobby.Endpoint, config.Lobby.ServerURL} {
+		if u, err := url.Parse(link); err != nil || u.Scheme == "" || u.Host == "" {
+			invalid("invalid url %q", link)
+		}
+	}
+	for _, client := range config.Lobby.Clients {
+		if client.Platform == "" || client.Url == "" {
+			invalid("client %q needs a platform and url", client.Platform)
+		}
+	}
+
+	for _, name := range config.BotNames {
+		if name == "" || len(name) > BOT_NAME_LENGTH {
+			invalid("bot name %q must have 1 to %d characters", name, BOT_NAME_LENGTH)
+		}
+	}
+
+	ids := map[string]bool{}
+	for _, table := range config.Tables {
+		if table.Name == "" {
+			invalid("table %q needs a name", table.ID)
+		}
+		if !tableIDPattern.MatchString(table.ID) {
+			invalid("table %q: id must have 1 to 8 lower case letters or digits", table.ID)
+		} else if ids[table.ID] {
+			invalid("table %q is listed twice", table.ID)
+		}
+		ids[table.ID] = true
+
+		variant := getVariant(table.Variant)
+		if variant == nil {
+			invalid("table %q: unknown variant %q", table.ID, table.Variant)
+		}
+		if _, ok := getBettingStructure(table.Stakes); !ok {
+			invalid("table %q: unknown stakes %q", table.ID, table.Stakes)
+		}
+
+		// Leave at least one seat for a human, and a spare bot name for a bot that runs out of chips
+		if table.Bots < 0 || (variant != nil && table.Bots >= variant.MaxPlayers) || table.Bots >= len(config.BotNames) {
+			invalid("table %q: too many bots", table.ID)
+		}
+		for _, strategy := range table.BotMix {
+			if getBotStrategy(strategy) == nil {
+				invalid("table %q: unknown bot strategy %q", table.ID, strategy)
+			}
+		}
+	}
+
+	return errors.Join(problems...)
+}
+
+// The variant, stakes and bots of a valid table config
+func (table TableConfig) settings() (*Variant, BettingStructure, []BotStrategy) {
+	mix := []BotStrategy{}
+	for _, name := range table.BotMix {
+		mix = append(mix, getBotStrategy(name))
+	}
+	betting, _ := getBettingStructure(table.Stakes)
+	return getVariant(table.Variant), betting, mixedBots(table.Bots, mix...)
+}
+
+// The lobby details to send the state of a table with, and where to send them
+func lobbySettings() (GameServer, string) {
+	lobby := getConfig().Lobby
+	details := DefaultGameServerDetails
+	details.Region = lobby.Region
+	details.Serverurl = lobby.ServerURL
+	details.Clients = lobby.Clients
+	return details, lobby.Endpoint
+}
+
+// Checks the config file for changes until the server stops
+func startConfigReload(path string) {
+	if path == "" {
+		return
+	}
+
+	go func() {
+		modified := fileModified(path)
+		for range time.Tick(CONFIG_RELOAD_INTERVAL) {
+			if changed := fileModified(path); !changed.Equal(modified) {
+				modified = changed
+				reloadConfig(path)
+			}
+		}
+	}()
+}
+
+func fileModified(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// Applies the config file, if it is valid
+func reloadConfig(path string) {
+	config, err := loadConfig(path)
+	if err != nil {
+		log.Printf("Config %s not reloaded: %s", path, err)
+		return
+	}
+
+	previous := setConfig(config)
+	if !slices.Equal(previous.BotNames, config.BotNames) {
+		log.Printf("Bot names in %s change on the next restart", path)
+	}
+	updateTables(previous.Tables, config.Tables)
+	log.Printf("Reloaded config %s", path)
+}
+
+// Opens, closes and changes the tables of the config
+func updateTables(previous []TableConfig, tables []TableConfig) {
+	before := map[string]TableConfig{}
+	for _, table := range previous {
+		before[table.ID] = table
+	}
+
+	for _, table := range tables {
+		old, ok := before[table.ID]
+		delete(before, table.ID)
+		switch {
+		case !ok:
+			openTable(table)
+			startTableTicker(table.ID)
+		case !reflect.DeepEqual(old, table):
+			changeTable(old, table)
+		}
+	}
+
+	for _, table := range before {
+		closeTable(table)
+	}
+}
+
+func openTable(table TableConfig) {
+	variant, betting, bots := table.settings()
+	createTable(table.Name, table.ID, variant, betting, bots, table.Lobby)
+}
+
+// Renames a table or changes its lobby registration in place. Any other change starts the table over
+func changeTable(old TableConfig, table TableConfig) {
+	unlock := tableMutex.Lock(table.ID)
+	defer unlock()
+
+	value, ok := stateMap.Load(table.ID)
+	if !ok {
+		return
+	}
+	stateCopy := *value.(*GameState)
+	state := &stateCopy
+
+	if old.Variant != table.Variant || old.Stakes != table.Stakes || old.Bots != table.Bots || !slices.Equal(old.BotMix, table.BotMix) {
+		variant, betting, bots := table.settings()
+		state = state.startOver(variant, betting, bots)
+	}
+
+	if old.Lobby && !table.Lobby {
+		state.removeFromLobby()
+	}
+	state.serverName = table.Name
+	state.registerLobby = table.Lobby
+	saveState(state)
+	state.updateLobby()
+
+	tablesMutex.Lock()
+	for i := range tables {
+		if tables[i].Table == table.ID {
+			tables[i].Name = table.Name
+			tables[i].Stakes = state.betting.String()
+		}
+	}
+	tablesMutex.Unlock()
+
+	log.Printf("Changed table %s (%s)", table.ID, table.Name)
+}
+
+// Removes a table that is no longer in the config. Players at it are dropped
+func closeTable(table TableConfig) {
+	unlock := tableMutex.Lock(table.ID)
+	defer unlock()
+
+	if value, ok := stateMap.Load(table.ID); ok {
+		value.(*GameState).removeFromLobby()
+	}
+	deleteTable(table.ID)
+	log.Printf("Closed table %s (%s)", table.ID, table.Name)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log"
+)
+
+// Provably fair shuffle - every game is dealt from a deck shuffled with a new random seed (crypto/rand).
+// The commitment, the SHA-256 of the seed followed by the shuffled deck, is in the state from the first
+// round of the game, and the seed is revealed once the game is over. Shuffling a new deck with the seed
+// must give the same commitment, so the deal was fixed before the first card and not changed since.
+//
+// The shuffle, so it can be repeated in any language:
+//  1. Start with the deck in order: 2C 3C .. AC, 2D .. AD, 2H .. AH, 2S .. AS
+//  2. The random numbers are the 8 byte big endian words of SHA-256(seed + ":" + n), for n = 0, 1, 2, ..
+//  3. Fisher-Yates: for i from 51 down to 1, swap card i with card j, j being the next number mod (i+1).
+//     Numbers at or above the largest multiple of (i+1) are skipped, so every j is as likely
+//  4. If the deck runs out (after many draws), the cards not in play are shuffled the same way with the seed + ":muck"
+//  5. The commitment is the hex SHA-256 of the seed followed by the deck, e.g. "<seed>2C7DAS..."
+
+const SEED_BYTES = 32
+
+// Random numbers derived from a seed, as described above
+type seedStream struct {
+	seed    string
+	counter int
+	block   []byte
+	draws   int // Numbers drawn, to continue the stream after a restart
+}
+
+func (stream *seedStream) next() uint64 {
+	if len(stream.block) == 0 {
+		hash := sha256.Sum256([]byte(fmt.Sprintf("%s:%d", stream.seed, stream.counter)))
+		stream.block = hash[:]
+		stream.counter++
+	}
+
+	value := binary.BigEndian.Uint64(stream.block)
+	stream.block = stream.block[8:]
+	stream.draws++
+	return value
+}
+
+// Returns a number from 0 to n-1, each equally likely
+func (stream *seedStream) intn(n int) int {
+	limit := ^uint64(0) - ^uint64(0)%uint64(n)
+	for {
+		if value := stream.next(); value < limit {
+			return int(value % uint64(n))
+		}
+	}
+}
+
+// Returns a new random seed as hex
+func newSeed() string {
+	seed := make([]byte, SEED_BYTES)
+	if _, err := rand.Read(seed); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(seed)
+}
+
+// Returns a deck of 52 cards in order
+func newDeck() []card {
+	deck := []card{}
+	for suit := 0; suit < 4; suit++ {
+		for value := 2; value < 15; value++ {
+			deck = append(deck, card{value: value, suit: suit})
+		}
+	}
+	return deck
+}
+
+// Returns a new deck, shuffled with the seed
+func shuffleDeck(seed string) []card {
+	deck := newDeck()
+	shuffleCards(deck, seed)
+	return deck
+}
+
+// Shuffles the cards in place with the seed
+func shuffleCards(cards []card, seed string) {
+	stream := &seedStream{seed: seed}
+	for i := len(cards) - 1; i > 0; i-- {
+		j := stream.intn(i + 1)
+		cards[i], cards[j] = cards[j], cards[i]
+	}
+}
+
+func deckCommitment(seed string, deck []card) string {
+	hash := sha256.Sum256([]byte(seed + cardsToString(deck)))
+	return hex.EncodeToString(hash[:])
+}
+
+// Shuffles the deck for a new game and publishes its commitment. The seed is revealed at the end of the game
+func (state *GameState) shuffle() {
+	state.seed = state.newSeed()
+	state.botRandom = nil
+	state.deck = state.shuffledDeck(state.seed)
+	state.deckIndex = 0
+	state.Commitment = deckCommitment(state.seed, state.deck)
+	state.Seed = ""
+
+	// Kept in the server log, to replay a game that never finished
+	log.Printf("Table %s shuffled with seed %s", state.table, state.seed)
+}
+
+// Result of /verify
+type Verification struct {
+	Seed       string `json:"s"`
+	Commitment string `json:"c"`
+	Deck       string `json:"d"`
+	Valid      bool   `json:"v"`
+}
+
+// Shuffles the deck with the seed and checks it against the commitment
+func verifyDeal(seed string, commitment string) Verification {
+	deck := shuffleDeck(seed)
+	return Verification{
+		Seed:       seed,
+		Commitment: commitment,
+		Deck:       cardsToString(deck),
+		Valid:      commitment != "" && deckCommitment(seed, deck) == commitment,
+	}
+}
@@ -3,8 +3,6 @@ package main
 import (
 	"fmt"
 	"log"
-	"math"
-	"math/rand"
 	"sort"
 	"strings"
 	"time"
@@ -18,6 +16,7 @@ import (
 5 Card Stud Rules below to serve as guideline.
 
 The logic to support below is not all implemented, and will be done as time allows.
+Other variants (7 Card Stud, 5 Card Draw, Texas Hold'em) share these betting rules, see variant.go
 
 Rules -  Assume Limit betting: Anti 1, Bringin 2,  Low 5, High 10
 Suit Rank (for comparing first to act): S,H,D,C
@@ -37,6 +36,7 @@ Winning hands - tied hands split the pot, remainder is discarded
 	- 4th street+ - 10
 */
 
+// Stakes of the default limit tables. Each table has its own BettingStructure, see betting.go
 const ANTE = 1
 const BRINGIN = 2
 const LOW = 5
@@ -63,6 +63,9 @@ var moveLookup = map[string]string{
 	"BH": "BET", // BET HIGH (e.g. 10)
 	"CA": "CALL",
 	"RA": "RAISE",
+	"AI": "ALL-IN",
+	"DR": "DRAW", // DRAW, followed by the positions (1-5) of the cards to discard, e.g. DR25
+	// BA (pot limit/no limit) is followed by the amount to bet or raise, e.g. BA25, and shown as BET or RAISE
 }
 
 var botNames = []string{"Clyd", "Jim", "Kirk", "Hulk", "Fry", "Meg", "Grif", "GPT"}
@@ -99,36 +102,73 @@ type Player struct {
 	Hand   string `json:"h"`
 
 	// Internal
-	isBot    bool
-	cards    []card
-	lastPing time.Time
+	isBot      bool
+	cards      []card
+	lastPing   time.Time
+	totalBet   int // Chips put in the pot this game, including the ante. Used for side pots
+	strategy   BotStrategy
+	token      string // Secret token of a human player's seat, see auth.go
+	tournament bool   // Plays with tournament chips, which are kept out of the bankroll
+	voluntary  bool   // Put chips in the pot by choice this game, see stats.go
 }
 
 type GameState struct {
 	// External (JSON)
-	LastResult   string      `json:"l"`
-	Round        int         `json:"r"`
-	Pot          int         `json:"p"`
-	ActivePlayer int         `json:"a"`
-	MoveTime     int         `json:"m"`
-	Viewing      int         `json:"v"`
-	ValidMoves   []validMove `json:"vm"`
-	Players      []Player    `json:"pl"`
+	LastResult   string        `json:"l"`
+	Round        int           `json:"r"`
+	Pot          int           `json:"p"`
+	ActivePlayer int           `json:"a"`
+	MoveTime     int           `json:"m"`
+	Viewing      int           `json:"v"`
+	ValidMoves   []validMove   `json:"vm"`
+	Players      []Player      `json:"pl"`
+	Board        string        `json:"c,omitempty"`  // Community cards (Texas Hold'em)
+	Commitment   string        `json:"fc,omitempty"` // SHA-256 of the seed and the shuffled deck, see fairness.go
+	Seed         string        `json:"fs,omitempty"` // Seed of the shuffle, revealed when the game is over
+	Token        string        `json:"k,omitempty"`  // The client player's token, if the request has it
+	Spectators   int           `json:"o,omitempty"`  // Number of spectators
+	Queue        int           `json:"q,omitempty"`  // The client spectator's place in the queue for a seat, 0 if not queued
+	Chat         []chatMessage `json:"ch,omitempty"` // Chat log of the table, oldest first
+	Tournament   int           `json:"g,omitempty"`  // Tournament status, see tournament.go
+	Seats        int           `json:"u,omitempty"`  // Seats of the tournament
+	Level        int           `json:"e,omitempty"`  // Tournament level, starting at 1
+	NextLevel    int           `json:"x,omitempty"`  // Hands (or minutes) until the next level
+	Standings    []Standing    `json:"sd,omitempty"` // Places of the eliminated players, and the winner
+	Hash         string        `json:"z,omitempty"`  // Hash of the client state. Pass it back in hash to skip unchanged states
 
 	// Internal
-	deck          []card
-	deckIndex     int
-	currentBet    int
-	gameOver      bool
-	clientPlayer  int
-	table         string
-	wonByFolds    bool
-	moveExpires   time.Time
-	serverName    string
-	raiseCount    int
-	raiseAmount   int
-	registerLobby bool
-	hash          string //   `json:"z"` // external later
+	variant         *Variant
+	betting         BettingStructure
+	board           []card
+	dealer          int
+	deck            []card
+	seed            string
+	deckIndex       int
+	currentBet      int
+	gameOver        bool
+	clientPlayer    int
+	spectators      []Spectator // See spectators.go
+	clientSpectator int
+	authorized      bool // The request has the token of the client player
+	custom          bool // Created with /create, see tables.go
+	private         bool
+	password        string
+	creator         string // Address of the client that created the table, see tables.go
+	lastActive      time.Time
+	table           string
+	wonByFolds      bool
+	moveExpires     time.Time
+	serverName      string
+	raiseCount      int
+	raiseAmount     int
+	registerLobby   bool
+	handNumber      int
+	history         []string // Hand history of the game in progress, see history.go
+	tournament      Tournament
+	clock           Clock  // See clock.go
+	random          RandomSource
+	botRandom       *seedStream
+	pausedAt        time.Time // Set while the table is paused, see admin.go
 }
 
 // Used to send a list of available tables
@@ -137,6 +177,10 @@ type GameTable struct {
 	Name       string `json:"n"`
 	CurPlayers int    `json:"p"`
 	MaxPlayers int    `json:"m"`
+	Stakes     string `json:"s"`           // Betting structure, e.g. "5/10 Limit"
+	Password   bool   `json:"w,omitempty"` // A password is needed to sit down
+	Spectators int    `json:"o,omitempty"`
+	Tournament int    `json:"g,omitempty"` // Tournament status, see tournament.go
 }
 
 func initializeGameServer() {
@@ -147,34 +191,36 @@ func initializeGameServer() {
 	}
 }
 
-func createGameState(playerCount int, registerLobby bool) *GameState {
-
-	deck := []card{}
-
-	// Create deck of 52 cards
-	for suit := 0; suit < 4; suit++ {
-		for value := 2; value < 15; value++ {
-			card := card{value: value, suit: suit}
-			deck = append(deck, card)
-		}
-	}
+func createGameState(variant *Variant, betting BettingStructure, bots []BotStrategy, registerLobby bool) *GameState {
 
 	state := GameState{}
-	state.deck = deck
+	state.variant = variant
+	state.betting = betting
+	state.deck = newDeck()
 	state.Round = 0
 	state.ActivePlayer = -1
+	state.clientSpectator = -1
 	state.registerLobby = registerLobby
+	state.clock = systemClock{}
+	state.random = cryptoRandom{}
 
 	// Pre-populate player pool with bots
-	for i := 0; i < playerCount; i++ {
-		state.addPlayer(botNames[i], true)
+	state.seatBots(bots)
+
+	return &state
+}
+
+// Seats a bot for each strategy. A tournament must be set first, so the bots play with tournament chips
+func (state *GameState) seatBots(bots []BotStrategy) {
+	for _, strategy := range bots {
+		state.addPlayer(botNames[len(state.Players)], true)
+		state.Players[len(state.Players)-1].strategy = strategy
 	}
 
-	if playerCount < 2 {
+	state.LastResult = ""
+	if len(state.Players) < 2 {
 		state.LastResult = WAITING_MESSAGE
 	}
-
-	return &state
 }
 
 func (state *GameState) newRound() {
@@ -207,6 +253,13 @@ func (state *GameState) newRound() {
 	if state.Round == 1 {
 		state.Pot = 0
 		state.gameOver = false
+		state.updateLevel()
+	}
+
+	// In a tournament, everyone with chips left plays
+	minimumPurse := 2
+	if state.isTournament() {
+		minimumPurse = 0
 	}
 
 	// Reset players for this round
@@ -218,13 +271,14 @@ func (state *GameState) newRound() {
 		if state.Round > 1 {
 			// If not the first round, add any bets into the pot
 			state.Pot += player.Bet
+			player.totalBet += player.Bet
 		} else {
 
 			// First round of a new game
 
 			// A bot will leave if it has under 25 chips, another will take their place
-			if player.isBot && player.Purse < 25 {
-				player.Purse = STARTING_PURSE
+			if player.isBot && player.Purse < 25 && !state.isTournament() {
+				player.Purse = state.betting.StartingPurse
 				for j := 0; j < len(botNames); j++ {
 					botNameUsed := false
 					for k := 0; k < len(state.Players); k++ {
@@ -240,16 +294,25 @@ func (state *GameState) newRound() {
 				}
 			}
 
-			// Reset player status and take the ANTI
-			if player.Purse > 2 {
+			// Reset player status and take the ANTI (blinds are posted once the cards are dealt)
+			player.totalBet = 0
+			if player.Purse > minimumPurse {
 				player.Status = STATUS_PLAYING
-				player.Purse -= ANTE
-				state.Pot += ANTE
+				if !state.variant.Blinds {
+					ante := state.betting.Ante
+					if ante > player.Purse {
+						ante = player.Purse
+					}
+					player.Purse -= ante
+					player.totalBet += ante
+					state.Pot += ante
+				}
 			} else {
 				// Player doesn't have enough money to play
 				player.Status = STATUS_WAITING
 			}
 			player.cards = []card{}
+			player.voluntary = false
 		}
 
 		// Reset player's last move/bet for this round
@@ -261,25 +324,101 @@ func (state *GameState) newRound() {
 	state.raiseCount = 0
 	state.raiseAmount = 0
 
-	// First round of a new game? Shuffle the cards and deal an extra card
+	// First round of a new game? Shuffle the cards and move the dealer button
 	if state.Round == 1 {
 
-		// Shuffle the deck 7 times :)
-		for shuffle := 0; shuffle < 7; shuffle++ {
-			rand.Shuffle(len(state.deck), func(i, j int) { state.deck[i], state.deck[j] = state.deck[j], state.deck[i] })
-		}
-		state.deckIndex = 0
-		state.dealCards()
+		state.shuffle()
+		state.board = []card{}
+		state.dealer = state.nextPlayingPlayer(state.dealer)
 		if state.LastResult == WAITING_MESSAGE {
 			state.LastResult = ""
 		}
+		state.startHistory()
 	}
 
-	state.dealCards()
-	state.ActivePlayer = state.getPlayerWithBestVisibleHand(state.Round > 1)
+	// Deal the cards of this round, as the variant says
+	dealt := []int{}
+	for _, player := range state.Players {
+		dealt = append(dealt, len(player.cards))
+	}
+	boardDealt := len(state.board)
+
+	for _, deal := range state.variant.Deal[state.Round-1] {
+		if deal == 'b' {
+			state.board = append(state.board, state.nextCard())
+		} else {
+			state.dealCards()
+		}
+	}
+	state.recordDeal(dealt, boardDealt)
+
+	// Stud - the lowest up card brings in, then the best visible hand acts first.
+	// Otherwise, the player after the dealer (or after the big blind) acts first
+	if state.variant.BringIn {
+		state.ActivePlayer = state.getPlayerWithBestVisibleHand(state.Round > 1)
+	} else if state.variant.Blinds && state.Round == 1 {
+		state.ActivePlayer = state.postBlinds()
+	} else {
+		state.ActivePlayer = state.nextPlayingPlayer(state.dealer)
+	}
+	state.skipPlayersWhoCannotAct()
 	state.resetPlayerTimer(true)
 }
 
+// Posts the small and big blind after the dealer, returning the player that acts next
+func (state *GameState) postBlinds() int {
+	smallBlind := state.nextPlayingPlayer(state.dealer)
+	bigBlind := state.nextPlayingPlayer(smallBlind)
+
+	for _, blind := range []struct {
+		player int
+		amount int
+		name   string
+	}{{smallBlind, state.betting.BringIn, "small"}, {bigBlind, state.betting.Low, "big"}} {
+		player := &state.Players[blind.player]
+		amount := blind.amount
+		if amount > player.Purse {
+			amount = player.Purse
+		}
+		player.Bet = amount
+		player.Purse -= amount
+		state.record("%s: posts %s blind %d", player.Name, blind.name, amount)
+	}
+
+	// The big blind is the bet to call, and keeps the option to raise when the action gets back to them
+	state.currentBet = state.betting.Low
+	state.raiseAmount = state.betting.Low
+
+	return state.nextPlayingPlayer(bigBlind)
+}
+
+// Returns the next player after the given one that is playing this game
+func (state *GameState) nextPlayingPlayer(from int) int {
+	for i := 1; i <= len(state.Players); i++ {
+		next := (from + i) % len(state.Players)
+		if state.Players[next].Status == STATUS_PLAYING {
+			return next
+		}
+	}
+	return 0
+}
+
+// Returns the cards of the player's hand that the other players can see
+func (state *GameState) visibleCards(player *Player) []card {
+	cards := []card{}
+	for i, card := range player.cards {
+		if !state.variant.isDown(i) {
+			cards = append(cards, card)
+		}
+	}
+	return cards
+}
+
+// Returns the cards a player can use: their hand and the community cards
+func (state *GameState) playerCards(player *Player) []card {
+	return append(append([]card{}, player.cards...), state.board...)
+}
+
 func (state *GameState) getPlayerWithBestVisibleHand(highHand bool) int {
 
 	ranks := [][]int{}
@@ -287,7 +426,7 @@ func (state *GameState) getPlayerWithBestVisibleHand(highHand bool) int {
 	for i := 0; i < len(state.Players); i++ {
 		player := &state.Players[i]
 		if player.Status == STATUS_PLAYING {
-			rank := getRank(player.cards[1:len(player.cards)])
+			rank := getRank(state.visibleCards(player))
 
 			// Add player number to start of rank to hold on to when sorting
 			rank = append([]int{i}, rank...)
@@ -323,27 +462,64 @@ func (state *GameState) getPlayerWithBestVisibleHand(highHand bool) int {
 func (state *GameState) dealCards() {
 	for i, player := range state.Players {
 		if player.Status == STATUS_PLAYING {
-			player.cards = append(player.cards, state.deck[state.deckIndex])
+			player.cards = append(player.cards, state.nextCard())
 			state.Players[i] = player
-			state.deckIndex++
 		}
 	}
 }
 
+// Returns the next card of the deck. If the deck runs out (after many draws), the cards
+// that are not in play are shuffled to make a new one, with the seed of the game so the deal stays verifiable
+func (state *GameState) nextCard() card {
+	if state.deckIndex >= len(state.deck) {
+		inPlay := append([]card{}, state.board...)
+		for _, player := range state.Players {
+			inPlay = append(inPlay, player.cards...)
+		}
+
+		muck := []card{}
+		for _, card := range state.deck {
+			if !slices.Contains(inPlay, card) {
+				muck = append(muck, card)
+			}
+		}
+		shuffleCards(muck, state.seed+":muck")
+
+		state.deck = append(inPlay, muck...)
+		state.deckIndex = len(inPlay)
+	}
+
+	card := state.deck[state.deckIndex]
+	state.deckIndex++
+	return card
+}
+
 func (state *GameState) addPlayer(playerName string, isBot bool) {
 
 	newPlayer := Player{
 		Name:   playerName,
 		Status: 0,
-		Purse:  STARTING_PURSE,
+		Purse:  state.betting.StartingPurse,
 		cards:  []card{},
 		isBot:  isBot,
 	}
 
+	// Human players bring their bankroll to the table, except to a tournament
+	if state.isTournament() {
+		newPlayer.Purse = state.tournament.Base.StartingPurse
+		newPlayer.tournament = true
+	} else if !isBot {
+		newPlayer.Purse = bankrollPurse(playerName, state.betting.StartingPurse)
+	}
+
 	state.Players = append(state.Players, newPlayer)
 }
 
-func (state *GameState) setClientPlayerByName(playerName string) {
+func (state *GameState) setClientPlayerByName(request clientRequest) {
+	playerName, token := request.player, request.token
+	state.authorized = false
+	state.clientSpectator = -1
+
 	// If no player name was passed, simply return. This is an anonymous viewer.
 	if len(playerName) == 0 {
 		state.clientPlayer = -1
@@ -351,16 +527,65 @@ func (state *GameState) setClientPlayerByName(playerName string) {
 	}
 	state.clientPlayer = slices.IndexFunc(state.Players, func(p Player) bool { return strings.EqualFold(p.Name, playerName) })
 
+	if state.clientPlayer >= 0 {
+		player := &state.Players[state.clientPlayer]
+
+		// Seats restored from before tokens were added get one with the next request
+		if player.token == "" && !player.isBot {
+			player.token, state.authorized = seatToken(request)
+		} else {
+			state.authorized = tokenMatches(player, token)
+		}
+	}
+
+	// A spectator - with the password, one that only watched may join the queue for a seat
+	if state.clientPlayer < 0 {
+		if state.clientSpectator = state.findSpectator(playerName); state.clientSpectator >= 0 {
+			spectator := &state.spectators[state.clientSpectator]
+			if state.authorized = tokenMatches(&Player{token: spectator.token}, token); state.authorized && !request.spectate && state.passwordMatches(request.password) && !state.registrationClosed() {
+				spectator.queued = true
+				state.seatSpectators()
+				state.clientPlayer = slices.IndexFunc(state.Players, func(p Player) bool { return strings.EqualFold(p.Name, playerName) })
+				state.clientSpectator = state.findSpectator(playerName)
+			}
+			return
+		}
+	}
+
 	// If a new player is joining, remove any old players that timed out to make space
 	if state.clientPlayer < 0 {
 		// Drop any players that left to make space
 		state.dropInactivePlayers(false, true)
 	}
 
-	// Add new player if there is room
-	if state.clientPlayer < 0 && len(state.Players) < 8 {
+	// Add new player if there is room, and nobody is waiting for a seat. Otherwise the client watches
+	if state.clientPlayer < 0 && (request.spectate || len(state.Players) >= state.maxPlayers() || state.hasQueue() || state.registrationClosed()) {
+		state.addSpectator(request)
+		return
+	}
+
+	if state.clientPlayer < 0 && len(state.Players) < state.maxPlayers() {
+		// Tables with a password only seat players that know it
+		if !state.passwordMatches(request.password) {
+			state.addSpectator(request)
+			return
+		}
+
+		seat, ok := seatToken(request)
+		if !ok {
+			// Rejected by the identity service, so only viewing
+			return
+		}
+
+		// Seated at another table with their bankroll, so only viewing
+		if !state.isTournament() && !claimSeat(playerName, state.table) {
+			return
+		}
+
 		state.addPlayer(playerName, false)
 		state.clientPlayer = len(state.Players) - 1
+		state.Players[state.clientPlayer].token = seat
+		state.authorized = true
 
 		// Set the ping for this player so they are counted as active when updating the lobby
 		state.playerPing()
@@ -370,10 +595,10 @@ func (state *GameState) setClientPlayerByName(playerName string) {
 	}
 
 	// Extra logic if a player is requesting
-	if state.clientPlayer > 0 {
+	if state.clientPlayer > 0 && state.authorized {
 
 		// In case a player returns while they are still in the "LEFT" status (before the current game ended), add them back in as waiting
-		if state.Players[state.clientPlayer].Status == STATUS_LEFT {
+		if state.Players[state.clientPlayer].Status == STATUS_LEFT && !state.isEliminated(playerName) {
 			state.Players[state.clientPlayer].Status = STATUS_WAITING
 		}
 	}
@@ -387,76 +612,152 @@ func (state *GameState) endGame(abortGame bool) {
 
 	state.gameOver = true
 	state.ActivePlayer = -1
-	state.Round = 5
+	state.Round = state.variant.endRound()
+	state.Seed = state.seed
 
 	remainingPlayers := []int{}
 	pockets := [][]cardrank.Card{}
 
-	for index, player := range state.Players {
+	for index := range state.Players {
+		player := &state.Players[index]
 		state.Pot += player.Bet
+		player.totalBet += player.Bet
 		if !abortGame && player.Status == STATUS_PLAYING {
 			remainingPlayers = append(remainingPlayers, index)
-			hand := ""
-			// Loop through and build hand string
-			for _, card := range player.cards {
-				hand += valueLookup[card.value] + suitLookup[card.suit]
-			}
-			pockets = append(pockets, cardrank.Must(hand))
+			pockets = append(pockets, cardrank.Must(cardsToString(player.cards)))
 		}
 	}
 
-	evs := cardrank.StudFive.EvalPockets(pockets, nil)
-	order, pivot := cardrank.Order(evs, false)
+	var board []cardrank.Card
+	if len(state.board) > 0 {
+		board = cardrank.Must(cardsToString(state.board))
+	}
 
-	if pivot == 0 {
+	evs := state.variant.Eval.EvalPockets(pockets, board)
+	if len(remainingPlayers) == 0 {
 		// If nobody won, the game was aborted. Display the waiting message if this
 		// server does not contains bots.
 		humanAvailSlots, _ := state.getHumanPlayerCountInfo()
-		if humanAvailSlots == 8 {
+		if humanAvailSlots == state.maxPlayers() {
 			state.LastResult = WAITING_MESSAGE
-			state.moveExpires = time.Now().Add(ENDGAME_TIME_LIMIT)
+			state.moveExpires = state.now().Add(ENDGAME_TIME_LIMIT)
 		} else {
-			state.moveExpires = time.Now()
+			state.moveExpires = state.now()
 		}
+		state.finishHistory("Game aborted")
 		return
 	}
 
-	// Int divide, so "house" takes remainder
-	perPlayerWinnings := state.Pot / pivot
+	if len(remainingPlayers) > 1 {
+		state.recordShowdown(remainingPlayers, evs)
+	}
 
+	// Award the main pot and any side pots to the best hands that can win them
 	result := ""
+	winners := map[int]bool{}
+	mentioned := map[int]bool{}
 
-	for i := 0; i < pivot; i++ {
-		player := &state.Players[remainingPlayers[order[i]]]
+	purses := []int{}
+	for _, player := range state.Players {
+		purses = append(purses, player.Purse)
+	}
+
+	pots := state.buildPots()
+	for potIndex, pot := range pots {
+		potEvs := []*cardrank.Eval{}
+		for _, index := range pot.Players {
+			potEvs = append(potEvs, evs[slices.Index(remainingPlayers, index)])
+		}
+		order, pivot := cardrank.Order(potEvs, false)
 
-		// Award winnings to player's purse
-		player.Purse += int(perPlayerWinnings)
+		potWinners := []int{}
+		names := ""
+		for i := 0; i < pivot; i++ {
+			index := pot.Players[order[i]]
+			potWinners = append(potWinners, index)
 
-		// Add player's name to result
-		if result != "" {
-			result += " and "
+			// A side pot nobody else could win is just the player's own chips coming back
+			if len(pot.Players) > 1 || len(remainingPlayers) == 1 {
+				winners[index] = true
+			}
+
+			if names != "" {
+				names += " and "
+			}
+			names += state.Players[index].Name
+		}
+		potName := "the pot"
+		if len(pots) > 1 {
+			potName = "the main pot"
+			if potIndex > 0 && len(pot.Players) > 1 {
+				potName = fmt.Sprint("side pot ", potIndex)
+			} else if potIndex > 0 {
+				potName = "" // Uncalled bet
+			}
+		}
+		state.splitPot(pot.Amount, potWinners, potName)
+
+		if potIndex == 0 {
+			result = names
+			if len(remainingPlayers) > 1 {
+				result += " won with " + handDescription(potEvs[order[0]])
+			}
+		} else if len(pot.Players) > 1 && !mentioned[potWinners[0]] {
+			result += ", " + names + " won a side pot"
+		}
+		for _, index := range potWinners {
+			mentioned[index] = true
 		}
-		result += player.Name
 	}
 
+	for index := range winners {
+		recordWin(&state.Players[index])
+	}
+	state.recordStats(purses, len(remainingPlayers) > 1, winners)
+
 	if len(remainingPlayers) > 1 {
 		state.wonByFolds = false
-		result += strings.Join(strings.Split(strings.Split(fmt.Sprintf(" won with %s", evs[order[0]]), " [")[0], ",")[0:2], ",")
-		result = strings.ReplaceAll(result, "kickers", "kicker")
+
+		// Every hand shown down counts for the best hand on the leaderboard
+		for i, index := range remainingPlayers {
+			recordHand(&state.Players[index], evs[i])
+		}
 	} else {
 		state.wonByFolds = true
 		result += " won by default"
 	}
 	state.LastResult = result
+	state.eliminateBustedPlayers()
+	state.finishHistory(result)
+
+	// Keep the purse of every player that played
+	for i := range state.Players {
+		recordPurse(&state.Players[i])
+	}
 
-	state.moveExpires = time.Now().Add(ENDGAME_TIME_LIMIT)
+	state.moveExpires = state.now().Add(ENDGAME_TIME_LIMIT)
 
 	log.Println(result)
 }
 
+// Describes a hand in a few words, e.g. "Full House, Eights full of Sixes"
+func handDescription(ev *cardrank.Eval) string {
+	description := strings.Split(fmt.Sprintf("%s", ev), " [")[0]
+	description = strings.Join(strings.Split(description, ",")[0:2], ",")
+	return strings.ReplaceAll(description, "kickers", "kicker")
+}
+
 // Emulates simplified player/logic for 5 card stud
 func (state *GameState) runGameLogic() {
-	state.playerPing()
+	// The table ticker advances the game without a client player
+	if state.clientPlayer >= 0 {
+		state.playerPing()
+	}
+
+	// A paused table waits for the operator
+	if state.isPaused() {
+		return
+	}
 
 	// We can't play a game until there are at least 2 players
 	if len(state.Players) < 2 {
@@ -467,6 +768,11 @@ func (state *GameState) runGameLogic() {
 		return
 	}
 
+	// A tournament only starts once every seat is taken
+	if !state.startTournament() {
+		return
+	}
+
 	// Very first call of state? Initialize first round but do not play for any BOTs
 	if state.Round == 0 {
 		state.newRound()
@@ -477,8 +783,8 @@ func (state *GameState) runGameLogic() {
 
 	if state.gameOver {
 
-		// Create a new game if the end game delay is past
-		if int(time.Until(state.moveExpires).Seconds()) < 0 {
+		// Create a new game if the end game delay is past. A finished tournament stays over
+		if int(state.moveExpires.Sub(state.now()).Seconds()) < 0 && state.tournamentStatus() != TOURNAMENT_FINISHED {
 			state.dropInactivePlayers(false, false)
 			state.Round = 0
 			state.Pot = 0
@@ -506,9 +812,8 @@ func (state *GameState) runGameLogic() {
 	// 1. We got back to the player who made the most recent bet/raise
 	// 2. There were checks/folds around the table
 	if state.ActivePlayer > -1 {
-		if (state.currentBet > 0 && state.Players[state.ActivePlayer].Bet == state.currentBet) ||
-			(state.currentBet == 0 && state.Players[state.ActivePlayer].Move != "") {
-			if state.Round == 4 {
+		if state.isRoundComplete() {
+			if state.Round == state.variant.Rounds {
 				state.endGame(false)
 			} else {
 				state.newRound()
@@ -520,7 +825,7 @@ func (state *GameState) runGameLogic() {
 	// Return if the move timer has not expired
 	// Check timer if no active player, or the active player hasn't already left
 	if state.ActivePlayer == -1 || state.Players[state.ActivePlayer].Status != STATUS_LEFT {
-		moveTimeRemaining := int(time.Until(state.moveExpires).Seconds())
+		moveTimeRemaining := int(state.moveExpires.Sub(state.now()).Seconds())
 		if moveTimeRemaining > 0 {
 			return
 		}
@@ -543,79 +848,40 @@ func (state *GameState) runGameLogic() {
 	// Force a move for this player or BOT if they are in the game and have not folded
 	if state.Players[state.ActivePlayer].Status == STATUS_PLAYING {
 		cards := state.Players[state.ActivePlayer].cards
-		moves := state.getValidMoves()
-
-		// Default to FOLD
-		choice := 0
-
-		// Never fold if CHECK is an option. This applies to forced player moves as well as bots
-		if len(moves) > 1 && moves[1].Move == "CH" {
-			choice = 1
-		}
-
-		// If this is a bot, pick the best move using some simple logic (sometimes random)
-		if state.Players[state.ActivePlayer].isBot {
 
-			// Potential TODO: If on round 5 and check is not an option, fold if there is a visible hand that beats the bot's hand.
-			//if len(cards) == 5 && len(moves) > 1 && moves[1].Move == "CH" {}
-
-			// Hardly ever fold early if a BOT has an jack or higher.
-			if state.Round < 3 && len(moves) > 1 && rand.Intn(3) > 0 && slices.ContainsFunc(cards, func(c card) bool { return c.value > 10 }) {
-				choice = 1
-			}
-
-			// Likely don't fold if BOT has a pair or better
-			rank := getRank(cards)
-			if rank[0] < 300 && rand.Intn(20) > 0 {
-				choice = 1
+		// Draw round - bots discard the cards that do not help their hand, players that run out of time keep theirs
+		if state.Round == state.variant.DrawRound {
+			move := "DR"
+			if state.Players[state.ActivePlayer].isBot {
+				move += botDiscards(cards, state.variant.MaxDiscard)
 			}
+			state.performMove(move, true)
+			return
+		}
 
-			// Don't fold if BOT has a 2 pair or better
-			if rank[0] < 200 {
-				choice = 1
-			}
-
-			// Raise the bet if three of a kind or better
-			if len(moves) > 2 && rank[0] < 312 && state.currentBet < LOW {
-				choice = 2
-			} else if len(moves) > 2 && state.getPlayerWithBestVisibleHand(true) == state.ActivePlayer && state.currentBet < HIGH && (rank[0] < 306) {
-				choice = len(moves) - 1
-			} else {
-
-				// Consider bet/call/raise most of the time
-				if len(moves) > 1 && rand.Intn(3) > 0 && (len(cards) > 2 ||
-					cards[0].value == cards[1].value ||
-					math.Abs(float64(cards[1].value-cards[0].value)) < 3 ||
-					cards[0].value > 8 ||
-					cards[1].value > 5) {
-
-					// Avoid endless raises
-					if state.currentBet >= 20 || rand.Intn(3) > 0 {
-						choice = 1
-					} else {
-						choice = rand.Intn(len(moves)-1) + 1
-					}
+		moves := state.getValidMoves()
 
-				}
-			}
+		// Default to FOLD, but never fold if CHECK is an option
+		move := moves[0].Move
+		if len(moves) > 1 && moves[1].Move == "CH" {
+			move = moves[1].Move
 		}
 
-		// Bounds check - clamp the move to the end of the array if a higher move is desired.
-		// This may occur if a bot wants to call, but cannot, due to limited funds.
-		if choice > len(moves)-1 {
-			choice = len(moves) - 1
+		// If this is a bot, its strategy picks the move
+		if player := &state.Players[state.ActivePlayer]; player.isBot {
+			if botMove := player.botStrategy().ChooseMove(state, moves); state.performMove(botMove, true) {
+				return
+			}
 		}
 
-		move := moves[choice]
-
-		state.performMove(move.Move, true)
+		state.performMove(move, true)
 	}
 
 }
 
 // Drop players that left or have not pinged within the expected timeout
 func (state *GameState) dropInactivePlayers(inMiddleOfGame bool, dropForNewPlayer bool) {
-	cutoff := time.Now().Add(PLAYER_PING_TIMEOUT)
+	cutoff := state.now().Add(PLAYER_PING_TIMEOUT)
 	players := []Player{}
 	currentPlayerName := ""
 	if state.clientPlayer > -1 {
@@ -623,8 +889,15 @@ func (state *GameState) dropInactivePlayers(inMiddleOfGame bool, dropForNewPlaye
 	}
 
 	for _, player := range state.Players {
-		if len(state.Players) > 0 && player.Status != STATUS_LEFT && (inMiddleOfGame || player.isBot || player.lastPing.Compare(cutoff) > 0) {
+		// Players that stop responding stay in a tournament until they run out of chips
+		if len(state.Players) > 0 && player.Status != STATUS_LEFT && (inMiddleOfGame || player.isBot || state.registrationClosed() || player.lastPing.Compare(cutoff) > 0) {
 			players = append(players, player)
+		} else {
+			// Players that are dropped keep their winnings in their bankroll
+			recordPurse(&player)
+			if player.Status != STATUS_LEFT {
+				state.record("%s was dropped for not responding", player.Name)
+			}
 		}
 	}
 
@@ -636,8 +909,19 @@ func (state *GameState) dropInactivePlayers(inMiddleOfGame bool, dropForNewPlaye
 	// Store if players were dropped, before updating the state player array
 	playersWereDropped := len(state.Players) != len(players)
 
+	// Players that leave a tournament are eliminated
+	for i := range state.Players {
+		if !slices.ContainsFunc(players, func(p Player) bool { return p.Name == state.Players[i].Name }) {
+			state.eliminate(&state.Players[i])
+		}
+	}
+	state.checkTournamentWinner()
+
+	// Spectators waiting in the queue take the free seats
+	state.dropInactiveSpectators()
 	if playersWereDropped {
 		state.Players = players
+		state.seatSpectators()
 	}
 
 	// If a new player is joining, don't bother updating anything else
@@ -670,6 +954,8 @@ func (state *GameState) clientLeave() {
 
 	player.Status = STATUS_LEFT
 	player.Move = "LEFT"
+	recordPurse(player)
+	state.record("%s leaves the table", player.Name)
 
 	// Check if no human players are playing. If so, end the game
 	playersLeft := 0
@@ -689,7 +975,8 @@ func (state *GameState) clientLeave() {
 
 // Update player's ping timestamp. If a player doesn't ping in a certain amount of time, they will be dropped from the server.
 func (state *GameState) playerPing() {
-	state.Players[state.clientPlayer].lastPing = time.Now()
+	state.Players[state.clientPlayer].lastPing = state.now()
+	state.lastActive = state.now()
 }
 
 // Performs the requested move for the active player, and returns true if successful
@@ -707,11 +994,34 @@ func (state *GameState) performMove(move string, internalCall ...bool) bool {
 		return false
 	}
 
-	// Only perform move if it is a valid move for this player
-	if !slices.ContainsFunc(state.getValidMoves(), func(m validMove) bool { return m.Move == move }) {
+	// Only perform move if it is a valid move for this player. A draw is followed by the cards to discard,
+	// a bet amount move by the amount.
+	if !slices.ContainsFunc(state.getValidMoves(), func(m validMove) bool {
+		return m.Move == move || ((m.Move == "DR" || m.Move == "BA") && strings.HasPrefix(move, m.Move))
+	}) {
 		return false
 	}
 
+	if strings.HasPrefix(move, "DR") { // DRAW
+		discards, ok := parseDiscards(move[2:], len(player.cards), state.variant.MaxDiscard)
+		if !ok {
+			return false
+		}
+		discarded := []card{}
+		for _, index := range discards {
+			discarded = append(discarded, player.cards[index])
+			player.cards[index] = state.nextCard()
+		}
+		player.Move = fmt.Sprint("DRAW ", len(discards))
+		state.record("%s: discards %d [%s] and has [%s]", player.Name, len(discards), cardsToText(discarded), cardsToText(player.cards))
+		state.nextValidPlayer()
+		return true
+	}
+
+	timedOut := len(internalCall) > 0 && internalCall[0] && !player.isBot
+	currentBet := state.currentBet
+	delta := 0
+
 	if move == "FO" { // FOLD
 		player.Status = STATUS_FOLDED
 	} else if move != "CH" { // Not Checking
@@ -719,33 +1029,66 @@ func (state *GameState) performMove(move string, internalCall ...bool) bool {
 		// Default raise to 0 (effectively a CALL)
 		raise := 0
 
-		if move == "RA" {
+		if move == "AI" {
+			// Everything the player has left. Going over the current bet only reopens the raising if it is a full raise
+			allIn := player.Bet + player.Purse
+			if allIn > state.currentBet {
+				raise = allIn - state.currentBet
+				if raise >= state.betting.Low && raise >= state.raiseAmount {
+					if state.currentBet >= state.betting.Low {
+						state.raiseCount++
+					}
+					state.raiseAmount = raise
+				}
+			}
+		} else if strings.HasPrefix(move, "BA") {
+			total, ok := state.parseBetAmount(move[2:])
+			if !ok {
+				return false
+			}
+			raise = total - state.currentBet
+
+			// Shown to the other players as a bet or a raise. A bet completes the bring-in, if any.
+			if state.currentBet >= state.betting.Low {
+				state.raiseAmount = raise
+				state.raiseCount++
+				move = "RA"
+			} else {
+				state.raiseAmount = total
+				move = "BL"
+			}
+		} else if move == "RA" {
 			raise = state.raiseAmount
 			state.raiseCount++
 		} else if move == "BH" {
-			raise = HIGH
-			state.raiseAmount = HIGH
+			// Make the total bet HIGH, e.g. after a short all-in
+			raise = state.betting.High - state.currentBet
+			state.raiseAmount = state.betting.High
 		} else if move == "BL" {
-			raise = LOW
-			state.raiseAmount = LOW
-
-			// If betting LOW the very first time and the pot is BRINGIN
+			// If betting LOW the very first time and the pot is BRINGIN (or a short all-in)
 			// just make their bet enough to make the total bet LOW
-			if state.currentBet == BRINGIN {
-				raise -= BRINGIN
-			}
+			raise = state.betting.Low - state.currentBet
+			state.raiseAmount = state.betting.Low
 		} else if move == "BB" {
-			raise = BRINGIN
+			raise = state.betting.BringIn
 		}
 
-		// Place the bet
-		delta := state.currentBet + raise - player.Bet
+		// Place the bet. A player can never bet more than their purse - a short call is all-in
+		delta = state.currentBet + raise - player.Bet
+		if delta > player.Purse {
+			delta = player.Purse
+		}
 		state.currentBet += raise
 		player.Bet += delta
 		player.Purse -= delta
 	}
 
+	if slices.Contains([]string{"CA", "BL", "BH", "RA", "AI"}, move) {
+		player.voluntary = true
+	}
+
 	player.Move = moveLookup[move]
+	state.recordMove(player, move, currentBet, delta, timedOut)
 	state.nextValidPlayer()
 
 	return true
@@ -762,23 +1105,58 @@ func (state *GameState) resetPlayerTimer(newRound bool) {
 		timeLimit += NEW_ROUND_FIRST_PLAYER_BUFFER
 	}
 
-	state.moveExpires = time.Now().Add(timeLimit)
+	state.moveExpires = state.now().Add(timeLimit)
+}
+
+// The round is complete when the action gets back to a player that already moved and
+// matched the current bet (a check or bet that was called around the table)
+// Players that are all-in are skipped, and once at most one player can still bet there is nobody left to bet against.
+func (state *GameState) isRoundComplete() bool {
+	playersToAct := 0
+	waiting := false
+	for i := range state.Players {
+		player := &state.Players[i]
+		if !state.canAct(player) {
+			continue
+		}
+		playersToAct++
+		if player.Bet < state.currentBet {
+			return false
+		}
+		if player.Move == "" {
+			waiting = true
+		}
+	}
+	return playersToAct < 2 || !waiting
+}
+
+// If the player can still move this round. A player that is all-in only moves in the draw round
+func (state *GameState) canAct(player *Player) bool {
+	return player.Status == STATUS_PLAYING && (player.Purse > 0 || state.Round == state.variant.DrawRound)
 }
 
 func (state *GameState) nextValidPlayer() {
 	// Move to next player
 	state.ActivePlayer = (state.ActivePlayer + 1) % len(state.Players)
+	state.skipPlayersWhoCannotAct()
+	state.resetPlayerTimer(false)
+}
 
-	// Skip over player if not in this game (joined late / folded)
-	for state.Players[state.ActivePlayer].Status != STATUS_PLAYING {
+// Skip over players not in this game (joined late / folded) or all-in
+func (state *GameState) skipPlayersWhoCannotAct() {
+	for i := 0; i < len(state.Players) && !state.canAct(&state.Players[state.ActivePlayer]); i++ {
 		state.ActivePlayer = (state.ActivePlayer + 1) % len(state.Players)
 	}
-	state.resetPlayerTimer(false)
 }
 
 func (state *GameState) getValidMoves() []validMove {
 	moves := []validMove{}
 
+	// No betting in the draw round, only discarding
+	if state.Round == state.variant.DrawRound {
+		return append(moves, validMove{Move: "DR", Name: "Draw"})
+	}
+
 	// Any player after the bring-in player may fold
 	if state.currentBet > 0 || state.Round > 1 {
 		moves = append(moves, validMove{Move: "FO", Name: "Fold"})
@@ -787,45 +1165,61 @@ func (state *GameState) getValidMoves() []validMove {
 	player := state.Players[state.ActivePlayer]
 
 	// First check options if there is no BET yet (a BRINGIN is not considered a BET)
-	if state.currentBet < LOW {
+	if state.currentBet < state.betting.Low {
 		// If nothing has been bet, force BET BRINGIN (2) on round 1
 		// otherwise a CHECK.
 		// If there is a bet, allow for a CALL
 		if state.currentBet == 0 {
-			if state.Round == 1 {
-				moves = append(moves, validMove{Move: "BB", Name: fmt.Sprint("Post ", BRINGIN)})
+			if state.Round == 1 && state.variant.BringIn {
+				moves = append(moves, validMove{Move: "BB", Name: fmt.Sprint("Post ", state.betting.BringIn)})
 			} else {
 				moves = append(moves, validMove{Move: "CH", Name: "Check"})
 			}
 		} else if player.Purse >= state.currentBet-player.Bet {
 			moves = append(moves, validMove{Move: "CA", Name: "Call"})
+		} else {
+			return state.appendAllInMove(moves)
+		}
+
+		// Pot limit and no limit tables bet any amount
+		if state.betting.Type != BETTING_LIMIT {
+			return state.appendAllInMove(state.appendBetAmountMove(moves))
 		}
 
 		// Allow LOW bet on 2nd and 3rd street
-		if player.Purse >= LOW && state.Round < 3 {
-			moves = append(moves, validMove{Move: "BL", Name: fmt.Sprint("Bet ", LOW)})
+		if player.Purse >= state.betting.Low && state.Round < 3 {
+			moves = append(moves, validMove{Move: "BL", Name: fmt.Sprint("Bet ", state.betting.Low)})
 		}
 
 		// Allow HIGH bet if on 4th or 5th street, or 3rd street + pair showing
-		if player.Purse >= HIGH && (state.Round >= 3 ||
+		if player.Purse >= state.betting.High && (state.Round >= 3 ||
 			(state.Round == 2 && slices.IndexFunc(state.Players, func(p Player) bool {
-				return p.Status == STATUS_PLAYING && p.cards[1].value == p.cards[2].value
+				return p.Status == STATUS_PLAYING && hasPair(state.visibleCards(&p))
 			}) >= 0)) {
-			moves = append(moves, validMove{Move: "BH", Name: fmt.Sprint("Bet ", HIGH)})
+			moves = append(moves, validMove{Move: "BH", Name: fmt.Sprint("Bet ", state.betting.High)})
 		}
 	} else {
-		// A bet as already been made. Allow a call
-		if player.Purse >= state.currentBet-player.Bet {
+		// A bet as already been made. Allow a call, or a check for the big blind when nobody raised
+		if player.Bet == state.currentBet {
+			moves = append(moves, validMove{Move: "CH", Name: "Check"})
+		} else if player.Purse >= state.currentBet-player.Bet {
 			moves = append(moves, validMove{Move: "CA", Name: "Call"})
+		} else {
+			return state.appendAllInMove(moves)
+		}
+
+		// Pot limit and no limit tables raise any amount
+		if state.betting.Type != BETTING_LIMIT {
+			return state.appendAllInMove(state.appendBetAmountMove(moves))
 		}
 
 		// Allow a raise if max number of rounds for the round has not been met
-		if state.Players[state.ActivePlayer].Purse >= state.currentBet-player.Bet+state.raiseAmount && state.raiseCount < 3 {
+		if state.Players[state.ActivePlayer].Purse >= state.currentBet-player.Bet+state.raiseAmount && state.canRaise() {
 			moves = append(moves, validMove{Move: "RA", Name: fmt.Sprint("Raise ", state.raiseAmount)})
 		}
 	}
 
-	return moves
+	return state.appendAllInMove(moves)
 }
 
 // Creates a copy of the state and modifies it to be from the
@@ -843,8 +1237,7 @@ func (state *GameState) createClientState() *GameState {
 	// This lets the client perform end of round/game tasks/animation
 	if state.gameOver ||
 		len(stateCopy.Players) < 2 ||
-		(stateCopy.ActivePlayer > -1 && ((state.currentBet > 0 && state.Players[state.ActivePlayer].Bet == state.currentBet) ||
-			(state.currentBet == 0 && state.Players[state.ActivePlayer].Move != ""))) {
+		(stateCopy.ActivePlayer > -1 && state.isRoundComplete()) {
 		stateCopy.ActivePlayer = -1
 		setActivePlayer = true
 	}
@@ -857,8 +1250,27 @@ func (state *GameState) createClientState() *GameState {
 	stateCopy.Players = []Player{}
 
 	// When on observer is viewing the game, the clientPlayer will be -1, so just start at 0
-	// Also, set flag to let client know they are not actively part of the game
-	start := state.clientPlayer
+	// Also, set flag to let client know they are not actively part of the game.
+	// Without the token, the client is an observer too
+	client := state.clientPlayer
+	if !state.authorized {
+		client = -1
+	}
+	start := client
+	if state.authorized && state.clientPlayer >= 0 {
+		stateCopy.Token = state.Players[start].token
+	} else if state.authorized && state.clientSpectator >= 0 {
+		stateCopy.Token = state.spectators[state.clientSpectator].token
+	}
+	stateCopy.Spectators = state.spectatorCount()
+	if state.isTournament() {
+		stateCopy.Tournament = int(state.tournamentStatus())
+		stateCopy.Seats = state.tournament.Seats
+		stateCopy.Level = state.tournament.Level + 1
+		stateCopy.NextLevel = state.nextLevelIn()
+		stateCopy.Standings = state.standings()
+	}
+	stateCopy.Queue = state.queuePosition()
 	if start < 0 {
 		start = 0
 		stateCopy.Viewing = 1
@@ -886,7 +1298,7 @@ func (state *GameState) createClientState() *GameState {
 			// Loop through and build hand string, taking
 			// care to not disclose the first card of a hand to other players
 			for cardIndex, card := range player.cards {
-				if cardIndex > 0 || playerIndex == state.clientPlayer || (state.Round == 5 && !state.wonByFolds) {
+				if !state.variant.isDown(cardIndex) || playerIndex == client || (state.Round == state.variant.endRound() && !state.wonByFolds) {
 					player.Hand += valueLookup[card.value] + suitLookup[card.suit]
 				} else {
 					player.Hand += "??"
@@ -900,34 +1312,39 @@ func (state *GameState) createClientState() *GameState {
 		stateCopy.Players = append(stateCopy.Players, player)
 	}
 
-	// Determine valid moves for this player (if their turn)
-	if stateCopy.ActivePlayer == 0 {
+	stateCopy.Board = cardsToString(state.board)
+
+	// Determine valid moves for this player (if their turn). Nobody moves at a paused table
+	if state.isPaused() {
+		stateCopy.LastResult = PAUSED_MESSAGE
+	} else if stateCopy.ActivePlayer == 0 {
 		stateCopy.ValidMoves = state.getValidMoves()
 	}
 
 	// Determine the move time left. Reduce the number by the grace period, to allow for plenty of time for a response to be sent back and accepted
-	stateCopy.MoveTime = int(time.Until(stateCopy.moveExpires).Seconds())
+	stateCopy.MoveTime = int(stateCopy.moveExpires.Sub(state.now()).Seconds())
 
 	if stateCopy.ActivePlayer > -1 {
 		stateCopy.MoveTime -= MOVE_TIME_GRACE_SECONDS
 	}
 
 	// No need to send move time if the calling player isn't the active player
-	if stateCopy.MoveTime < 0 || stateCopy.ActivePlayer != 0 {
+	if stateCopy.MoveTime < 0 || stateCopy.ActivePlayer != 0 || state.isPaused() {
 		stateCopy.MoveTime = 0
 	}
 
 	// Compute hash - this will be compared with an incoming hash. If the same, the entire state does not
 	// need to be sent back. This speeds up checks for change in state
-	stateCopy.hash = "0"
+	stateCopy.Hash = "0"
 	hash, _ := hashstructure.Hash(stateCopy, hashstructure.FormatV2, nil)
-	stateCopy.hash = fmt.Sprintf("%d", hash)
+	stateCopy.Hash = fmt.Sprintf("%d", hash)
 
 	return &stateCopy
 }
 
 func (state *GameState) updateLobby() {
-	if !state.registerLobby {
+	// Lobby clients only know how to play the default variant
+	if !state.registerLobby || state.variant != defaultVariant {
 		return
 	}
 
@@ -937,11 +1354,19 @@ func (state *GameState) updateLobby() {
 	sendStateToLobby(humanPlayerSlots, humanPlayerCount, true, state.serverName, "?table="+state.table)
 }
 
+// Tells the lobby the table is gone
+func (state *GameState) removeFromLobby() {
+	if !state.registerLobby || state.variant != defaultVariant {
+		return
+	}
+	sendStateToLobby(0, 0, false, state.serverName, "?table="+state.table)
+}
+
 // Return number of active human players in the table, for the lobby
 func (state *GameState) getHumanPlayerCountInfo() (int, int) {
-	humanAvailSlots := 8
+	humanAvailSlots := state.maxPlayers()
 	humanPlayerCount := 0
-	cutoff := time.Now().Add(PLAYER_PING_TIMEOUT)
+	cutoff := state.now().Add(PLAYER_PING_TIMEOUT)
 
 	for _, player := range state.Players {
 		if player.isBot {
@@ -989,3 +1414,57 @@ func getRank(cards []card) []int {
 	}
 	return rank
 }
+
+// If there are two cards of the same value
+func hasPair(cards []card) bool {
+	for i := range cards {
+		for j := i + 1; j < len(cards); j++ {
+			if cards[i].value == cards[j].value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Parses the positions (1 based) of the cards to discard, e.g. "25". Returns the 0 based indexes
+func parseDiscards(positions string, handSize int, maxDiscard int) ([]int, bool) {
+	discards := []int{}
+	for _, c := range positions {
+		index := int(c - '1')
+		if index < 0 || index >= handSize || slices.Contains(discards, index) {
+			return nil, false
+		}
+		discards = append(discards, index)
+	}
+	return discards, len(discards) <= maxDiscard
+}
+
+// Bots keep their pairs (or better) and discard the rest. Without a pair they keep their two highest cards
+func botDiscards(cards []card, maxDiscard int) string {
+	sets := map[int]int{}
+	for _, card := range cards {
+		sets[card.value]++
+	}
+
+	discards := ""
+	if hasPair(cards) {
+		for i, card := range cards {
+			if sets[card.value] == 1 && len(discards) < maxDiscard {
+				discards += fmt.Sprint(i + 1)
+			}
+		}
+		return discards
+	}
+
+	// Discard the lowest cards
+	order := []int{}
+	for i := range cards {
+		order = append(order, i)
+	}
+	sort.Slice(order, func(i, j int) bool { return cards[order[i]].value < cards[order[j]].value })
+	for i := 0; i < len(order)-2 && i < maxDiscard; i++ {
+		discards += fmt.Sprint(order[i] + 1)
+	}
+	return discards
+}
@@ -8,6 +8,8 @@ require github.com/mitchellh/hashstructure/v2 v2.0.2
 
 require github.com/cardrank/cardrank v0.14.9 // indirect
 
+require go.etcd.io/bbolt v1.3.7
+
 require (
 	github.com/bytedance/sonic v1.8.0 // indirect
 	github.com/chenzhuoyu/base64x v0.0.0-20221115062448-fe3a3abad311 // indirect
@@ -33,5 +35,5 @@ require (
 	golang.org/x/sys v0.5.0 // indirect
 	golang.org/x/text v0.7.0 // indirect
 	google.golang.org/protobuf v1.28.1 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ericcarrgh/cardrank"
+	"github.com/goccy/go-json"
+)
+
+// Hand history - every game is recorded as text, in the style of the hand histories of online poker rooms:
+// the seats, the order of the shuffled deck, antes and blinds, the cards dealt, every move (marking moves made
+// when a player timed out), players that left or were dropped and the showdown as cardrank evaluated it.
+// The last HISTORY_SIZE hands of each table are kept in memory, and every hand is saved to the state store, if any.
+
+const HISTORY_SIZE = 100
+
+type HandHistory struct {
+	Table      string    `json:"t"`
+	Hand       int       `json:"h"`
+	Ended      time.Time `json:"e"`
+	Result     string    `json:"l"`
+	Commitment string    `json:"c"`
+	Seed       string    `json:"s"`
+	Text       string    `json:"x,omitempty"`
+}
+
+var histories = map[string][]*HandHistory{}
+var historyLoaded = map[string]bool{}
+var historyMutex sync.Mutex
+
+// Adds a line to the history of the game in progress, if any
+func (state *GameState) record(format string, args ...any) {
+	if state.history != nil {
+		state.history = append(state.history, fmt.Sprintf(format, args...))
+	}
+}
+
+// Starts the history of a new game, once the antes are in and the deck is shuffled
+func (state *GameState) startHistory() {
+	state.handNumber++
+	state.history = []string{}
+
+	state.record("Hand #%d: %s (%s) - %s", state.handNumber, state.variant.Name, state.betting, state.now().UTC().Format("2006/01/02 15:04:05 MST"))
+	state.record("Table '%s' (%s) Seat #%d is the dealer", state.table, state.serverName, state.dealer+1)
+
+	for i, player := range state.Players {
+		if player.Status == STATUS_PLAYING {
+			state.record("Seat %d: %s (%d in chips)", i+1, player.Name, player.Purse+player.totalBet)
+		}
+	}
+
+	state.record("Commitment: %s", state.Commitment)
+	state.record("Deck: %s", cardsToText(state.deck))
+
+	if !state.variant.Blinds {
+		for _, player := range state.Players {
+			if player.Status == STATUS_PLAYING {
+				state.record("%s: posts the ante %d", player.Name, state.betting.Ante)
+			}
+		}
+	}
+}
+
+// Records the cards dealt this round. dealt is the number of cards each player had before
+func (state *GameState) recordDeal(dealt []int, boardDealt int) {
+	header := fmt.Sprintf("*** ROUND %d ***", state.Round)
+	if len(state.board) > boardDealt {
+		header += fmt.Sprintf(" [%s]", cardsToText(state.board))
+	}
+	state.record("%s", header)
+
+	for i, player := range state.Players {
+		if player.Status == STATUS_PLAYING && len(player.cards) > dealt[i] {
+			if dealt[i] > 0 {
+				state.record("Dealt to %s [%s] [%s]", player.Name, cardsToText(player.cards[:dealt[i]]), cardsToText(player.cards[dealt[i]:]))
+			} else {
+				state.record("Dealt to %s [%s]", player.Name, cardsToText(player.cards))
+			}
+		}
+	}
+}
+
+// Records a betting move, once the bet is placed. before is the current bet before the move
+func (state *GameState) recordMove(player *Player, move string, before int, delta int, timedOut bool) {
+	action := ""
+	switch {
+	case move == "FO":
+		action = "folds"
+	case state.currentBet == before && delta == 0:
+		action = "checks"
+	case state.currentBet == before:
+		action = fmt.Sprint("calls ", delta)
+	case move == "BB":
+		action = fmt.Sprint("brings in for ", state.currentBet)
+	case before == 0:
+		action = fmt.Sprint("bets ", state.currentBet)
+	case before < state.betting.Low:
+		action = fmt.Sprint("completes to ", state.currentBet)
+	default:
+		action = fmt.Sprintf("raises %d to %d", state.currentBet-before, state.currentBet)
+	}
+
+	if move != "FO" && player.Purse == 0 {
+		action += " and is all-in"
+	}
+	if timedOut {
+		action += " (timed out)"
+	}
+	state.record("%s: %s", player.Name, action)
+}
+
+// Records the showdown, evaluated by cardrank
+func (state *GameState) recordShowdown(remainingPlayers []int, evs []*cardrank.Eval) {
+	state.record("*** SHOW DOWN ***")
+	for i, index := range remainingPlayers {
+		best := ""
+		for _, c := range evs[i].HiBest {
+			best += " " + strings.ToUpper(c.String())
+		}
+		player := &state.Players[index]
+		state.record("%s: shows [%s] (%s) best [%s]", player.Name, cardsToText(player.cards), handDescription(evs[i]), strings.TrimSpace(best))
+	}
+}
+
+// Ends the history of the game and keeps it
+func (state *GameState) finishHistory(result string) {
+	if state.history == nil {
+		return
+	}
+
+	state.record("*** SUMMARY ***")
+	state.record("Total pot %d", state.Pot)
+	if len(state.board) > 0 {
+		state.record("Board [%s]", cardsToText(state.board))
+	}
+	state.record("Result: %s", result)
+	state.record("Seed: %s", state.seed)
+
+	saveHistory(&HandHistory{
+		Table:      state.table,
+		Hand:       state.handNumber,
+		Ended:      state.now().UTC(),
+		Result:     result,
+		Commitment: state.Commitment,
+		Seed:       state.seed,
+		Text:       strings.Join(state.history, "\n") + "\n",
+	})
+	state.history = nil
+}
+
+// Keeps a finished hand in memory, and queues it for the store. The queued hands are always among the
+// last ones kept in memory, so they are found before they are written
+func saveHistory(history *HandHistory) {
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+
+	hands := append(histories[history.Table], history)
+	if len(hands) > HISTORY_SIZE {
+		hands = hands[len(hands)-HISTORY_SIZE:]
+	}
+	histories[history.Table] = hands
+
+	if stateStore == nil {
+		return
+	}
+
+	data, err := json.Marshal(history)
+	if err != nil {
+		log.Printf("Unable to persist hand %d of table %s: %s", history.Hand, history.Table, err)
+		return
+	}
+	queueWrite(func(batch *storeBatch) {
+		batch.Hands = append(batch.Hands, savedHand{Table: history.Table, Hand: history.Hand, Data: data})
+	})
+}
+
+// Returns the last finished hands of a table, newest first, without their text
+func recentHistory(table string) []HandHistory {
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+
+	loadRecentHistory(table)
+
+	result := []HandHistory{}
+	hands := histories[table]
+	for i := len(hands) - 1; i >= 0; i-- {
+		hand := *hands[i]
+		hand.Text = ""
+		result = append(result, hand)
+	}
+	return result
+}
+
+// Returns a finished hand of a table, or nil if there is none
+func findHistory(table string, hand int) *HandHistory {
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+
+	loadRecentHistory(table)
+
+	for _, history := range histories[table] {
+		if history.Hand == hand {
+			return history
+		}
+	}
+
+	// Older hands are only in the store
+	if stateStore == nil {
+		return nil
+	}
+
+	data, err := stateStore.LoadHistory(table, hand)
+	if err != nil || data == nil {
+		return nil
+	}
+
+	history := &HandHistory{}
+	if err := json.Unmarshal(data, history); err != nil {
+		log.Printf("Unable to load hand %d of table %s: %s", hand, table, err)
+		return nil
+	}
+	return history
+}
+
+// Loads the last hands of a table from the store the first time they are needed, e.g. after a restart.
+// historyMutex must be locked
+func loadRecentHistory(table string) {
+	if stateStore == nil || historyLoaded[table] {
+		return
+	}
+	historyLoaded[table] = true
+
+	saved, err := stateStore.LoadRecentHistory(table, HISTORY_SIZE)
+	if err != nil {
+		log.Printf("Unable to load the hand history of table %s: %s", table, err)
+		return
+	}
+
+	hands := []*HandHistory{}
+	for _, data := range saved {
+		history := &HandHistory{}
+		if err := json.Unmarshal(data, history); err == nil {
+			hands = append(hands, history)
+		}
+	}
+
+	// Hands finished since the start are already in memory
+	for _, history := range histories[table] {
+		if len(hands) == 0 || history.Hand > hands[len(hands)-1].Hand {
+			hands = append(hands, history)
+		}
+	}
+	if len(hands) > HISTORY_SIZE {
+		hands = hands[len(hands)-HISTORY_SIZE:]
+	}
+	histories[table] = hands
+}
+
+// Cards as shown in the hand history, e.g. "KS KH"
+func cardsToText(cards []card) string {
+	text := []string{}
+	for _, c := range cards {
+		text = append(text, valueLookup[c.value]+suitLookup[c.suit])
+	}
+	return strings.Join(text, " ")
+}
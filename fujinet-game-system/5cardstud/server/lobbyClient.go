@@ -2,9 +2,12 @@ package main
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/goccy/go-json"
 )
@@ -15,8 +18,19 @@ const (
 	LOBBY_ENDPOINT_UPSERT = "http://lobby.fujinet.online/server"
 )
 
+// Lobby updates are sent by a background worker, so a slow or unreachable lobby never holds up a table.
+// Updates of a table that come in while the worker is busy replace each other, and only the latest is sent.
+// A failed update is retried a few times, waiting longer each time, unless a newer update of the table
+// is waiting. Every LOBBY_HEARTBEAT_INTERVAL the last update of every online table is sent again, so a
+// lobby that restarted catches up. On shutdown every table is sent as offline.
+
+const LOBBY_TIMEOUT = 10 * time.Second
+const LOBBY_ATTEMPTS = 4
+const LOBBY_HEARTBEAT_INTERVAL = 5 * time.Minute
+const LOBBY_SHUTDOWN_TIMEOUT = 5 * time.Second
+
 // Defaults for this game server
-// Appkey/game are hard coded, but the others could be read from a config file
+// Appkey/game are hard coded, the others can be set in the config file, see config.go
 var DefaultGameServerDetails = GameServer{
 	Appkey:    1,
 	Game:      "5 Card Stud",
@@ -30,6 +44,18 @@ var DefaultGameServerDetails = GameServer{
 
 var UpdateLobby bool
 
+var lobbyClient = &http.Client{Timeout: LOBBY_TIMEOUT}
+var lobbyRetryDelay = time.Second // Doubled after each failed attempt
+
+// Updates waiting to be sent, and the last update sent of each online table, by table url
+var lobbyPending = map[string]lobbyUpdate{}
+var lobbySent = map[string]lobbyUpdate{}
+var lobbyOffline bool // Shutting down, so tables stay offline
+var lobbyMutex sync.Mutex
+
+var lobbySendMutex sync.Mutex // Held while sending
+var lobbyWake = make(chan struct{}, 1)
+
 type GameServer struct {
 	// Properties being sent from Game Server
 	Game       string       `json:"game"`
@@ -48,14 +74,20 @@ type GameClient struct {
 	Url      string `json:"url"`
 }
 
+type lobbyUpdate struct {
+	server   GameServer
+	endpoint string
+}
+
+// Queues the state of a table for the lobby worker. Returns at once
 func sendStateToLobby(maxPlayers int, curPlayers int, isOnline bool, server string, instanceUrlSuffix string) {
 
 	if !UpdateLobby {
 		return
 	}
 
-	// Start with copy of default game server details
-	serverDetails := DefaultGameServerDetails
+	// Start with copy of the game server details of the config
+	serverDetails, endpoint := lobbySettings()
 	serverDetails.Maxplayers = maxPlayers
 	serverDetails.Curplayers = curPlayers
 	if isOnline {
@@ -67,30 +99,166 @@ func sendStateToLobby(maxPlayers int, curPlayers int, isOnline bool, server stri
 	serverDetails.Server = server
 	serverDetails.Serverurl += instanceUrlSuffix
 
-	jsonPayload, err := json.Marshal(serverDetails)
+	lobbyMutex.Lock()
+	if lobbyOffline && isOnline {
+		lobbyMutex.Unlock()
+		return
+	}
+	lobbyPending[serverDetails.Serverurl] = lobbyUpdate{server: serverDetails, endpoint: endpoint}
+	lobbyMutex.Unlock()
+
+	select {
+	case lobbyWake <- struct{}{}:
+	default:
+	}
+}
+
+// Sends the queued updates, and the heartbeat, until the server stops
+func startLobbyClient() {
+	go func() {
+		heartbeat := time.NewTicker(LOBBY_HEARTBEAT_INTERVAL)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case <-lobbyWake:
+			case <-heartbeat.C:
+				queueLobbyHeartbeat()
+			}
+			sendLobbyUpdates()
+		}
+	}()
+}
+
+// Queues the last update of every online table again, unless a newer one is waiting
+func queueLobbyHeartbeat() {
+	lobbyMutex.Lock()
+	defer lobbyMutex.Unlock()
+
+	for key, update := range lobbySent {
+		if _, ok := lobbyPending[key]; !ok {
+			lobbyPending[key] = update
+		}
+	}
+}
+
+// Sends the queued updates until none are left
+func sendLobbyUpdates() {
+	lobbySendMutex.Lock()
+	defer lobbySendMutex.Unlock()
+
+	for {
+		key, update, ok := nextLobbyUpdate()
+		if !ok {
+			return
+		}
+
+		if postToLobby(key, update) {
+			lobbyMutex.Lock()
+			if update.server.Status == "online" {
+				lobbySent[key] = update
+			} else {
+				delete(lobbySent, key)
+			}
+			lobbyMutex.Unlock()
+		}
+	}
+}
+
+// Takes any one of the queued updates
+func nextLobbyUpdate() (string, lobbyUpdate, bool) {
+	lobbyMutex.Lock()
+	defer lobbyMutex.Unlock()
+
+	for key, update := range lobbyPending {
+		delete(lobbyPending, key)
+		return key, update, true
+	}
+	return "", lobbyUpdate{}, false
+}
+
+// Posts the update, trying again while the lobby does not answer or fails. Returns true if the lobby took it
+func postToLobby(key string, update lobbyUpdate) bool {
+	jsonPayload, err := json.Marshal(update.server)
 	if err != nil {
-		panic(err)
+		log.Printf("Unable to update the lobby with %s: %s", update.server.Server, err)
+		return false
 	}
 	log.Printf("Updating Lobby: %s", jsonPayload)
 
-	request, err := http.NewRequest("POST", LOBBY_ENDPOINT_UPSERT, bytes.NewBuffer(jsonPayload))
+	delay := lobbyRetryDelay
+	for attempt := 1; ; attempt++ {
+		retry, err := postLobbyPayload(update.endpoint, jsonPayload)
+		if err == nil {
+			return true
+		}
+		if !retry || attempt == LOBBY_ATTEMPTS {
+			log.Printf("Unable to update the lobby with %s: %s", update.server.Server, err)
+			return false
+		}
+
+		log.Printf("Unable to update the lobby with %s, trying again in %s: %s", update.server.Server, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+
+		// A newer update of the table replaces this one
+		lobbyMutex.Lock()
+		_, superseded := lobbyPending[key]
+		lobbyMutex.Unlock()
+		if superseded {
+			return false
+		}
+	}
+}
+
+// Returns an error if the lobby did not take the update, and whether it is worth trying again
+func postLobbyPayload(endpoint string, jsonPayload []byte) (bool, error) {
+	request, err := http.NewRequest("POST", endpoint, bytes.NewBuffer(jsonPayload))
 	if err != nil {
-		panic(err)
+		return false, err
 	}
 	request.Header.Set("Content-Type", "application/json; charset=UTF-8")
 
-	client := &http.Client{}
-	response, err := client.Do(request)
+	response, err := lobbyClient.Do(request)
 	if err != nil {
-		log.Println(err)
-		return
+		return true, err
 	}
 	defer response.Body.Close()
 
 	log.Printf("Lobby Response: %s", response.Status)
 	if response.StatusCode > 300 {
 		body, _ := io.ReadAll(response.Body)
-		log.Println("response Body:", string(body))
+		return response.StatusCode >= 500, fmt.Errorf("%s: %s", response.Status, body)
+	}
+	return true, nil
+}
+
+// Sends every table as offline, waiting up to LOBBY_SHUTDOWN_TIMEOUT. Tables stay offline from then on
+func takeTablesOffline() {
+	lobbyMutex.Lock()
+	lobbyOffline = true
+	lobbyMutex.Unlock()
+
+	for _, table := range getTables() {
+		func() {
+			unlock := tableMutex.Lock(table.Table)
+			defer unlock()
+
+			if value, ok := stateMap.Load(table.Table); ok {
+				value.(*GameState).removeFromLobby()
+			}
+		}()
 	}
 
+	done := make(chan struct{})
+	go func() {
+		sendLobbyUpdates()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(LOBBY_SHUTDOWN_TIMEOUT):
+		log.Printf("Gave up updating the lobby after %s", LOBBY_SHUTDOWN_TIMEOUT)
+	}
 }
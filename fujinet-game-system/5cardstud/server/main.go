@@ -1,12 +1,15 @@
 package main
 
 import (
-	"fmt"
+	"context"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
 	"sync"
-	"time"
+	"syscall"
 
 	"github.com/gin-gonic/gin"
 )
@@ -33,13 +36,22 @@ func (m *KeyedMutex) Lock(key string) func() {
 }
 
 func main() {
+	// Pit the bot strategies against each other instead of running the server
+	if games, _ := strconv.Atoi(os.Getenv("SIMULATE")); games > 0 {
+		runSimulation(games)
+		return
+	}
+
 	log.Print("Starting server...")
 
 	// Set environment flags
 	UpdateLobby = os.Getenv("GO_PROD") == "1"
+	IdentityURL = os.Getenv("IDENTITY_URL")
+	AdminKey = os.Getenv("ADMIN_KEY")
+	initializeConfig(os.Getenv("CONFIG_FILE"))
 
 	if UpdateLobby {
-		log.Printf("This instance will update the lobby at " + LOBBY_ENDPOINT_UPSERT)
+		log.Printf("This instance will update the lobby at " + getConfig().Lobby.Endpoint)
 		gin.SetMode(gin.ReleaseMode)
 	}
 
@@ -53,6 +65,16 @@ func main() {
 
 	router := gin.Default()
 
+	// Clients are limited in the tables they create by address. X-Forwarded-For is only
+	// trusted from the proxies in TRUSTED_PROXIES, otherwise any client could pick its own
+	proxies := []string{}
+	if list := os.Getenv("TRUSTED_PROXIES"); list != "" {
+		proxies = strings.Split(list, ",")
+	}
+	if err := router.SetTrustedProxies(proxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %s", err)
+	}
+
 	router.GET("/view", apiView)
 
 	router.GET("/state", apiState)
@@ -61,18 +83,63 @@ func main() {
 	router.GET("/move/:move", apiMove)
 	router.POST("/move/:move", apiMove)
 
+	router.GET("/events", apiEvents)
+
 	router.GET("/leave", apiLeave)
 	router.POST("/leave", apiLeave)
 
+	router.GET("/chat", apiChat)
+	router.POST("/chat", apiChat)
+
 	router.GET("/tables", apiTables)
+	router.POST("/create", apiCreateTable)
+	router.GET("/leaderboard", apiLeaderboard)
+	router.GET("/stats", apiStats)
+	router.GET("/history", apiHistory)
+	router.GET("/verify", apiVerify)
 	router.GET("/updateLobby", apiUpdateLobby)
+	addAdminRoutes(router)
 
 	//	router.GET("/REFRESHLOBBY", apiRefresh)
 
 	initializeGameServer()
+	initializeStateStore(os.Getenv("STATE_FILE"))
 	initializeTables()
+	restoreCustomTables()
+	startTableTickers()
+	startTableCleanup()
+	startConfigReload(os.Getenv("CONFIG_FILE"))
+	if UpdateLobby {
+		startLobbyClient()
+	}
+
+	serve(router, ":"+port)
+}
+
+// Serves until SIGTERM (or Ctrl-C), then takes the tables off the lobby and closes the state file
+func serve(router *gin.Engine, addr string) {
+	server := &http.Server{Addr: addr, Handler: router}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	log.Printf("Shutting down after %s", <-stop)
 
-	router.Run(":" + port)
+	ctx, cancel := context.WithTimeout(context.Background(), LOBBY_SHUTDOWN_TIMEOUT)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Unable to stop serving: %s", err)
+	}
+
+	takeTablesOffline()
+
+	closeStateStore()
 }
 
 // Api Request steps
@@ -92,12 +159,16 @@ func main() {
 func apiMove(c *gin.Context) {
 
 	state, unlock := getState(c)
+	unauthorized := false
 	func() {
 		defer unlock()
 
 		if state != nil {
-			// Access check - only move if the client is the active player
-			if state.clientPlayer == state.ActivePlayer {
+			// Access check - only move if the client is the active player, with their token
+			if unauthorized = state.clientPlayer >= 0 && !state.authorized; unauthorized {
+				return
+			}
+			if state.clientPlayer >= 0 && state.clientPlayer == state.ActivePlayer && !state.isPaused() {
 				move := strings.ToUpper(c.Param("move"))
 				state.performMove(move)
 				saveState(state)
@@ -106,28 +177,42 @@ func apiMove(c *gin.Context) {
 		}
 	}()
 
+	if unauthorized {
+		c.String(http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
 	serializeResults(c, state)
 }
 
-// Steps forward and returns the updated state
+// Steps forward and returns the updated state. With wait=N and hash, waits up to N seconds for the state to change
 func apiState(c *gin.Context) {
 	hash := c.Query("hash")
+	wait, _ := strconv.Atoi(c.Query("wait"))
 	state, unlock := getState(c)
 
 	func() {
 		defer unlock()
 
 		if state != nil {
+			state.requireToken()
 			if state.clientPlayer >= 0 {
 				state.runGameLogic()
 				saveState(state)
+			} else if state.clientSpectator >= 0 {
+				state.spectatorPing()
+				saveState(state)
 			}
 			state = state.createClientState()
 		}
 	}()
 
+	if state != nil && len(hash) > 0 && wait > 0 {
+		state = waitForChange(c, state, hash, wait)
+	}
+
 	// Check if passed in hash matches the state
-	if state != nil && len(hash) > 0 && hash == state.hash {
+	if state != nil && len(hash) > 0 && hash == state.Hash {
 		serializeResults(c, "1")
 		return
 	}
@@ -138,11 +223,19 @@ func apiState(c *gin.Context) {
 // Drop from the specified table
 func apiLeave(c *gin.Context) {
 	state, unlock := getState(c)
+	unauthorized := false
 
 	func() {
 		defer unlock()
 
 		if state != nil {
+			if unauthorized = (state.clientPlayer >= 0 || state.clientSpectator >= 0) && !state.authorized; unauthorized {
+				return
+			}
+			if state.clientSpectator >= 0 {
+				state.spectatorLeave()
+				saveState(state)
+			}
 			if state.clientPlayer >= 0 {
 				state.clientLeave()
 				state.updateLobby()
@@ -150,6 +243,11 @@ func apiLeave(c *gin.Context) {
 			}
 		}
 	}()
+
+	if unauthorized {
+		c.String(http.StatusUnauthorized, "Invalid token")
+		return
+	}
 	serializeResults(c, "bye")
 }
 
@@ -170,18 +268,23 @@ func apiView(c *gin.Context) {
 
 // Returns a list of real tables with player/slots for the client
 // If passing "dev=1", will return developer testing tables instead of the live tables
+// If passing "variant=X", will return the tables of that variant instead of 5 Card Stud
 func apiTables(c *gin.Context) {
 	returnDevTables := c.Query("dev") == "1"
+	variant := getVariant(c.Query("variant"))
 
 	tableOutput := []GameTable{}
-	for _, table := range tables {
+	for _, table := range getTables() {
 		value, ok := stateMap.Load(table.Table)
 		if ok {
 			state := value.(*GameState)
-			if (returnDevTables && !state.registerLobby) || (!returnDevTables && state.registerLobby) {
+			devTable := !state.registerLobby && !state.custom
+			if state.variant == variant && !state.private && returnDevTables == devTable {
 				humanPlayerSlots, humanPlayerCount := state.getHumanPlayerCountInfo()
 				table.CurPlayers = humanPlayerCount
 				table.MaxPlayers = humanPlayerSlots
+				table.Spectators = state.spectatorCount()
+				table.Tournament = int(state.tournamentStatus())
 				tableOutput = append(tableOutput, table)
 			}
 		}
@@ -189,9 +292,55 @@ func apiTables(c *gin.Context) {
 	serializeResults(c, tableOutput)
 }
 
+// Returns the top players by purse, hands won and best hand shown down, across all tables
+func apiLeaderboard(c *gin.Context) {
+	serializeResults(c, getLeaderboard())
+}
+
+// Returns the last finished hands of a table, or with "hand=N" the hand history of that hand as text
+func apiHistory(c *gin.Context) {
+	table := strings.ToLower(c.Query("table"))
+
+	if hand := c.Query("hand"); hand != "" {
+		number, _ := strconv.Atoi(hand)
+		history := findHistory(table, number)
+		if history == nil {
+			c.String(http.StatusNotFound, "Hand not found")
+			return
+		}
+		c.String(http.StatusOK, history.Text)
+		return
+	}
+
+	serializeResults(c, recentHistory(table))
+}
+
+// Checks that a deal was fixed before the game started - the seed shuffles the deck of the commitment.
+// Either pass the seed and commitment from the state, or the table and hand number of a finished hand
+func apiVerify(c *gin.Context) {
+	seed, commitment := c.Query("seed"), c.Query("commitment")
+
+	if hand := c.Query("hand"); hand != "" {
+		number, _ := strconv.Atoi(hand)
+		history := findHistory(strings.ToLower(c.Query("table")), number)
+		if history == nil {
+			c.String(http.StatusNotFound, "Hand not found")
+			return
+		}
+		seed, commitment = history.Seed, history.Commitment
+	}
+
+	if seed == "" {
+		c.String(http.StatusBadRequest, "Missing seed")
+		return
+	}
+
+	serializeResults(c, verifyDeal(seed, commitment))
+}
+
 // Forces an update of all tables to the lobby - useful for adhoc use if the Lobby restarts or loses info
 func apiUpdateLobby(c *gin.Context) {
-	for _, table := range tables {
+	for _, table := range getTables() {
 		value, ok := stateMap.Load(table.Table)
 		if ok {
 			state := value.(*GameState)
@@ -204,13 +353,39 @@ func apiUpdateLobby(c *gin.Context) {
 
 // Gets the current game state for the specified table and adds the player id of the client to it
 func getState(c *gin.Context) (*GameState, func()) {
-	table := c.Query("table")
+	return getTableState(newClientRequest(c))
+}
+
+// The table and client player of a request, from its query parameters
+type clientRequest struct {
+	table    string
+	player   string
+	token    string
+	password string
+	spectate bool // Watch, even if there is a free seat
+
+	identified bool // The identity service accepted the token, see auth.go
+}
+
+func newClientRequest(c *gin.Context) clientRequest {
+	return clientRequest{table: c.Query("table"), player: c.Query("player"), token: c.Query("k"), password: c.Query("pw"), spectate: c.Query("spectate") == "1"}
+}
 
+// The key of a table in the stateMap. Requests without a table are for the default table
+func tableKey(table string) string {
 	if table == "" {
 		table = "default"
 	}
-	table = strings.ToLower(table)
-	player := c.Query("player")
+	return strings.ToLower(table)
+}
+
+func getTableState(request clientRequest) (*GameState, func()) {
+	table := tableKey(request.table)
+
+	// Ask the identity service before locking, so a slow service doesn't hold up the table
+	if IdentityURL != "" && request.player != "" && request.token != "" {
+		request.identified = validateIdentity(request.player, request.token)
+	}
 
 	// Lock by the table so to avoid multiple threads updating the same table state
 	unlock := tableMutex.Lock(table)
@@ -223,44 +398,48 @@ func getState(c *gin.Context) (*GameState, func()) {
 	if ok {
 		stateCopy := *value.(*GameState)
 		state = &stateCopy
-		state.setClientPlayerByName(player)
+		state.setClientPlayerByName(request)
 	}
 
-	return state, unlock
+	// A request that doesn't save the table gives back the seats it claimed, see bankroll.go
+	return state, func() {
+		if value, ok := stateMap.Load(table); ok {
+			updateSeats(value.(*GameState))
+		}
+		unlock()
+	}
 }
 
 func saveState(state *GameState) {
 	stateMap.Store(state.table, state)
+	updateSeats(state)
+	persistState(state)
+	notifyTable(state.table)
 }
 
+// Creates the tables of the config, see config.go
 func initializeTables() {
+	for _, table := range getConfig().Tables {
+		openTable(table)
+	}
+}
 
-	// Create the real servers (hard coded for now)
-	createTable("The Basement", "basement", 0, true)
-	createTable("The Den", "den", 0, true)
-	createTable("AI Room - 2 bots", "ai2", 2, true)
-	createTable("AI Room - 4 bots", "ai4", 4, true)
-	createTable("AI Room - 6 bots", "ai6", 6, true)
-
-	// For client developers, create hidden tables for each # of bots (for ease of testing with a specific # of players in the game)
-	// These will not update the lobby
+func createTable(serverName string, table string, variant *Variant, betting BettingStructure, bots []BotStrategy, registerLobby bool) {
 
-	for i := 1; i < 8; i++ {
-		createTable(fmt.Sprintf("Dev Room - %d bots", i), fmt.Sprintf("dev%d", i), i, false)
+	// Continue the game of a table restored from the state file
+	var state *GameState
+	if value, ok := stateMap.Load(table); ok {
+		state = value.(*GameState)
+		log.Printf("Restored table %s with %d players", table, len(state.Players))
+	} else {
+		state = createGameState(variant, betting, bots, registerLobby)
 	}
 
-}
-
-func createTable(serverName string, table string, botCount int, registerLobby bool) {
-	state := createGameState(botCount, registerLobby)
 	state.table = table
 	state.serverName = serverName
+	state.registerLobby = registerLobby
 	saveState(state)
 	state.updateLobby()
 
-	tables = append([]GameTable{{Table: table, Name: serverName}}, tables...)
-
-	if UpdateLobby {
-		time.Sleep(time.Millisecond * time.Duration(100))
-	}
+	addTable(GameTable{Table: table, Name: serverName, Stakes: state.betting.String()})
 }
@@ -0,0 +1,567 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-json"
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/exp/slices"
+)
+
+// Table persistence - every table is snapshotted after saveState and reloaded on startup,
+// so a restart or deploy does not wipe the purses, hands and pots of games in progress.
+// saveState only queues the snapshot, as do the bankroll and stats updates and finished hands: everything queued is written together
+// every PERSIST_INTERVAL, off the request path, so neither polling clients nor tables wait for the disk.
+// The store is pluggable. By default it is a bbolt file set with the STATE_FILE env variable.
+// If STATE_FILE is not set, tables only live in memory.
+
+type StateStore interface {
+	Save(batch *storeBatch) error // In a single transaction
+	LoadAll() (map[string][]byte, error)
+	Delete(table string) error
+	LoadBankrolls() (map[string][]byte, error)
+	LoadStats() (map[string][]byte, error)
+	LoadHistory(table string, hand int) ([]byte, error)          // nil if there is no such hand
+	LoadRecentHistory(table string, count int) ([][]byte, error) // Oldest first
+	Close() error
+}
+
+var stateStore StateStore
+
+const PERSIST_INTERVAL = time.Second
+
+// Writes waiting for the next flush, only the latest of each key. pendingMutex is never held while writing
+type storeBatch struct {
+	Tables    map[string][]byte
+	Bankrolls map[string][]byte
+	Stats     map[string][]byte
+	Hands     []savedHand
+}
+
+// A finished hand of a table, see history.go
+type savedHand struct {
+	Table string
+	Hand  int
+	Data  []byte
+}
+
+func newStoreBatch() *storeBatch {
+	return &storeBatch{Tables: map[string][]byte{}, Bankrolls: map[string][]byte{}, Stats: map[string][]byte{}}
+}
+
+func (batch *storeBatch) isEmpty() bool {
+	return len(batch.Tables) == 0 && len(batch.Bankrolls) == 0 && len(batch.Stats) == 0 && len(batch.Hands) == 0
+}
+
+var pending = newStoreBatch()
+var pendingMutex sync.Mutex
+
+// Held while writing to the store, so a deleted table is not written back
+var writeMutex sync.Mutex
+
+var tablesBucket = []byte("tables")
+var bankrollsBucket = []byte("bankrolls")
+var historyBucket = []byte("history")
+var statsBucket = []byte("stats")
+
+// Saved form of a GameState. The exported fields are saved as-is (same json as the client sees)
+// while the internal fields, which json ignores, are copied to exported fields here.
+type savedState struct {
+	State         *GameState        `json:"state"`
+	Players       []savedPlayer     `json:"players"`
+	Variant       string            `json:"variant"`
+	Betting       *BettingStructure `json:"betting"`
+	Board         string            `json:"board"`
+	Dealer        int               `json:"dealer"`
+	Deck          string            `json:"deck"`
+	Seed          string            `json:"seed"`
+	DeckIndex     int               `json:"deckIndex"`
+	CurrentBet    int               `json:"currentBet"`
+	GameOver      bool              `json:"gameOver"`
+	Table         string            `json:"table"`
+	WonByFolds    bool              `json:"wonByFolds"`
+	MoveExpires   time.Time         `json:"moveExpires"`
+	ServerName    string            `json:"serverName"`
+	RaiseCount    int               `json:"raiseCount"`
+	RaiseAmount   int               `json:"raiseAmount"`
+	RegisterLobby bool              `json:"registerLobby"`
+	HandNumber    int               `json:"handNumber"`
+	History       []string          `json:"history"`
+	Custom        bool              `json:"custom,omitempty"`
+	Private       bool              `json:"private,omitempty"`
+	Password      string            `json:"password,omitempty"`
+	Creator       string            `json:"creator,omitempty"`
+	LastActive    time.Time         `json:"lastActive"`
+	Spectators    []savedSpectator  `json:"spectators,omitempty"`
+	Tournament    *Tournament       `json:"tournament,omitempty"`
+	BotDraws      int               `json:"botDraws,omitempty"`
+	PausedAt      time.Time         `json:"pausedAt"`
+}
+
+// Internal fields of a Player, in the same order as State.Players
+type savedPlayer struct {
+	IsBot      bool      `json:"isBot"`
+	Cards      string    `json:"cards"`
+	LastPing   time.Time `json:"lastPing"`
+	TotalBet   int       `json:"totalBet"`
+	Strategy   string    `json:"strategy,omitempty"`
+	Token      string    `json:"token,omitempty"`
+	Tournament bool      `json:"tournament,omitempty"`
+	Voluntary  bool      `json:"voluntary,omitempty"`
+}
+
+type savedSpectator struct {
+	Name     string    `json:"name"`
+	Token    string    `json:"token"`
+	LastPing time.Time `json:"lastPing"`
+	Queued   bool      `json:"queued"`
+}
+
+// bbolt implementation of StateStore - a single bucket with one key per table
+type boltStore struct {
+	db *bolt.DB
+}
+
+func openBoltStore(path string) (*boltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{tablesBucket, bankrollsBucket, historyBucket, statsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &boltStore{db: db}, nil
+}
+
+func (s *boltStore) Save(batch *storeBatch) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := putAll(tx.Bucket(tablesBucket), batch.Tables); err != nil {
+			return err
+		}
+		if err := putAll(tx.Bucket(bankrollsBucket), batch.Bankrolls); err != nil {
+			return err
+		}
+		if err := putAll(tx.Bucket(statsBucket), batch.Stats); err != nil {
+			return err
+		}
+
+		// Hands are kept in a bucket per table, keyed by the hand number so they are in order
+		for _, hand := range batch.Hands {
+			bucket, err := tx.Bucket(historyBucket).CreateBucketIfNotExists([]byte(hand.Table))
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put(handKey(hand.Hand), hand.Data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func putAll(bucket *bolt.Bucket, values map[string][]byte) error {
+	for key, data := range values {
+		if err := bucket.Put([]byte(key), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *boltStore) LoadAll() (map[string][]byte, error) {
+	return s.loadAll(tablesBucket)
+}
+
+func (s *boltStore) Delete(table string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(tablesBucket).Delete([]byte(table))
+	})
+}
+
+func (s *boltStore) LoadBankrolls() (map[string][]byte, error) {
+	return s.loadAll(bankrollsBucket)
+}
+
+func (s *boltStore) LoadStats() (map[string][]byte, error) {
+	return s.loadAll(statsBucket)
+}
+
+func (s *boltStore) LoadHistory(table string, hand int) ([]byte, error) {
+	var result []byte
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if bucket := tx.Bucket(historyBucket).Bucket([]byte(table)); bucket != nil {
+			if data := bucket.Get(handKey(hand)); data != nil {
+				result = append([]byte{}, data...)
+			}
+		}
+		return nil
+	})
+
+	return result, err
+}
+
+func (s *boltStore) LoadRecentHistory(table string, count int) ([][]byte, error) {
+	result := [][]byte{}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(historyBucket).Bucket([]byte(table))
+		if bucket == nil {
+			return nil
+		}
+		cursor := bucket.Cursor()
+		for k, v := cursor.Last(); k != nil && len(result) < count; k, v = cursor.Prev() {
+			result = append([][]byte{append([]byte{}, v...)}, result...)
+		}
+		return nil
+	})
+
+	return result, err
+}
+
+func handKey(hand int) []byte {
+	return binary.BigEndian.AppendUint32(nil, uint32(hand))
+}
+
+func (s *boltStore) loadAll(bucket []byte) (map[string][]byte, error) {
+	result := map[string][]byte{}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).ForEach(func(k, v []byte) error {
+			// Values are only valid during the transaction, so take a copy
+			result[string(k)] = append([]byte{}, v...)
+			return nil
+		})
+	})
+
+	return result, err
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
+
+// Opens the store at path (if set) and loads every saved table into stateMap
+func initializeStateStore(path string) {
+	if path == "" {
+		return
+	}
+
+	store, err := openBoltStore(path)
+	if err != nil {
+		log.Fatalf("Unable to open the table state file %s: %s", path, err)
+	}
+
+	stateStore = store
+	log.Printf("Persisting table state to %s", path)
+
+	loadStates()
+	loadBankrolls()
+	loadStats()
+
+	go func() {
+		for range time.Tick(PERSIST_INTERVAL) {
+			flushStore()
+		}
+	}()
+}
+
+// Loads every table from the store into stateMap. Tables that fail to load are skipped.
+func loadStates() {
+	saved, err := stateStore.LoadAll()
+	if err != nil {
+		log.Printf("Unable to load the saved tables: %s", err)
+		return
+	}
+
+	for table, data := range saved {
+		state, err := unmarshalState(data)
+		if err != nil {
+			log.Printf("Unable to load the saved table %s: %s", table, err)
+			continue
+		}
+		stateMap.Store(table, state)
+		updateSeats(state)
+	}
+
+	log.Printf("Loaded %d saved tables", len(saved))
+}
+
+// Queues the table to be written to the store, if there is one. Only the latest snapshot of a table is written
+func persistState(state *GameState) {
+	if stateStore == nil {
+		return
+	}
+
+	data, err := marshalState(state)
+	if err != nil {
+		log.Printf("Unable to persist table %s: %s", state.table, err)
+		return
+	}
+
+	queueWrite(func(batch *storeBatch) { batch.Tables[state.table] = data })
+}
+
+// Queues a write for the next flush
+func queueWrite(write func(batch *storeBatch)) {
+	pendingMutex.Lock()
+	defer pendingMutex.Unlock()
+	write(pending)
+}
+
+// Writes everything queued to the store
+func flushStore() {
+	writeMutex.Lock()
+	defer writeMutex.Unlock()
+
+	pendingMutex.Lock()
+	batch := pending
+	pending = newStoreBatch()
+	pendingMutex.Unlock()
+
+	if batch.isEmpty() || stateStore == nil {
+		return
+	}
+
+	if err := stateStore.Save(batch); err != nil {
+		log.Printf("Unable to persist %d tables, %d bankrolls, %d stats and %d hands: %s",
+			len(batch.Tables), len(batch.Bankrolls), len(batch.Stats), len(batch.Hands), err)
+	}
+}
+
+// Removes the table from the store, along with any snapshot still queued
+func deleteState(table string) {
+	if stateStore == nil {
+		return
+	}
+
+	writeMutex.Lock()
+	defer writeMutex.Unlock()
+
+	queueWrite(func(batch *storeBatch) { delete(batch.Tables, table) })
+
+	if err := stateStore.Delete(table); err != nil {
+		log.Printf("Unable to delete table %s: %s", table, err)
+	}
+}
+
+// Writes everything queued and closes the store, on shutdown
+func closeStateStore() {
+	if stateStore == nil {
+		return
+	}
+
+	flushStore()
+	if err := stateStore.Close(); err != nil {
+		log.Printf("Unable to close the state file: %s", err)
+	}
+}
+
+func marshalState(state *GameState) ([]byte, error) {
+	saved := savedState{
+		State:         state,
+		Players:       []savedPlayer{},
+		Variant:       state.variant.Code,
+		Betting:       &state.betting,
+		Board:         cardsToString(state.board),
+		Dealer:        state.dealer,
+		Deck:          cardsToString(state.deck),
+		Seed:          state.seed,
+		DeckIndex:     state.deckIndex,
+		CurrentBet:    state.currentBet,
+		GameOver:      state.gameOver,
+		Table:         state.table,
+		WonByFolds:    state.wonByFolds,
+		MoveExpires:   state.moveExpires,
+		ServerName:    state.serverName,
+		RaiseCount:    state.raiseCount,
+		RaiseAmount:   state.raiseAmount,
+		RegisterLobby: state.registerLobby,
+		HandNumber:    state.handNumber,
+		History:       state.history,
+		Custom:        state.custom,
+		Private:       state.private,
+		Password:      state.password,
+		Creator:       state.creator,
+		LastActive:    state.lastActive,
+		PausedAt:      state.pausedAt,
+	}
+
+	if state.isTournament() {
+		saved.Tournament = &state.tournament
+	}
+	if state.botRandom != nil {
+		saved.BotDraws = state.botRandom.draws
+	}
+
+	for _, spectator := range state.spectators {
+		saved.Spectators = append(saved.Spectators, savedSpectator{
+			Name:     spectator.name,
+			Token:    spectator.token,
+			LastPing: spectator.lastPing,
+			Queued:   spectator.queued,
+		})
+	}
+
+	for _, player := range state.Players {
+		saved.Players = append(saved.Players, savedPlayer{
+			IsBot:      player.isBot,
+			Cards:      cardsToString(player.cards),
+			LastPing:   player.lastPing,
+			TotalBet:   player.totalBet,
+			Strategy:   strategyName(player.strategy),
+			Token:      player.token,
+			Tournament: player.tournament,
+			Voluntary:  player.voluntary,
+		})
+	}
+
+	return json.Marshal(saved)
+}
+
+func unmarshalState(data []byte) (*GameState, error) {
+	saved := savedState{}
+
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, err
+	}
+
+	state := saved.State
+	if state == nil {
+		return nil, fmt.Errorf("missing state")
+	}
+
+	if len(saved.Players) != len(state.Players) {
+		return nil, fmt.Errorf("%d players but %d saved player details", len(state.Players), len(saved.Players))
+	}
+
+	if state.variant = getVariant(saved.Variant); state.variant == nil {
+		return nil, fmt.Errorf("unknown variant %q", saved.Variant)
+	}
+
+	// Tables saved before betting structures were added play the default limit game
+	state.betting = limitStakes
+	if saved.Betting != nil {
+		state.betting = *saved.Betting
+	}
+
+	var err error
+	if state.deck, err = stringToCards(saved.Deck); err != nil {
+		return nil, err
+	}
+	if state.board, err = stringToCards(saved.Board); err != nil {
+		return nil, err
+	}
+
+	for i := range state.Players {
+		player := &state.Players[i]
+		player.isBot = saved.Players[i].IsBot
+		player.lastPing = saved.Players[i].LastPing
+		player.totalBet = saved.Players[i].TotalBet
+		player.strategy = getBotStrategy(saved.Players[i].Strategy)
+		player.token = saved.Players[i].Token
+		player.tournament = saved.Players[i].Tournament
+		player.voluntary = saved.Players[i].Voluntary
+		if player.cards, err = stringToCards(saved.Players[i].Cards); err != nil {
+			return nil, err
+		}
+	}
+
+	state.dealer = saved.Dealer
+	state.deckIndex = saved.DeckIndex
+	state.seed = saved.Seed
+	state.currentBet = saved.CurrentBet
+	state.gameOver = saved.GameOver
+	state.table = saved.Table
+	state.wonByFolds = saved.WonByFolds
+	state.moveExpires = saved.MoveExpires
+	state.serverName = saved.ServerName
+	state.raiseCount = saved.RaiseCount
+	state.raiseAmount = saved.RaiseAmount
+	state.registerLobby = saved.RegisterLobby
+	state.handNumber = saved.HandNumber
+	state.history = saved.History
+	state.custom = saved.Custom
+	state.private = saved.Private
+	state.password = saved.Password
+	state.creator = saved.Creator
+	state.lastActive = saved.LastActive
+	state.pausedAt = saved.PausedAt
+	state.clientPlayer = -1
+	state.clientSpectator = -1
+	if saved.Tournament != nil {
+		state.tournament = *saved.Tournament
+	}
+	state.clock = systemClock{}
+	state.random = cryptoRandom{}
+
+	// The bots go on with the numbers they would have drawn without the restart
+	if saved.BotDraws > 0 {
+		state.botRandom = &seedStream{seed: state.seed + ":bots"}
+		for state.botRandom.draws < saved.BotDraws {
+			state.botRandom.next()
+		}
+	}
+
+	for _, spectator := range saved.Spectators {
+		state.spectators = append(state.spectators, Spectator{
+			name:     spectator.Name,
+			token:    spectator.Token,
+			lastPing: spectator.LastPing,
+			queued:   spectator.Queued,
+		})
+	}
+
+	return state, nil
+}
+
+// Cards are saved the same way they are shown to the client, e.g. "KSKH"
+func cardsToString(cards []card) string {
+	result := ""
+	for _, card := range cards {
+		result += valueLookup[card.value] + suitLookup[card.suit]
+	}
+	return result
+}
+
+func stringToCards(text string) ([]card, error) {
+	cards := []card{}
+
+	if len(text)%2 != 0 {
+		return nil, fmt.Errorf("invalid cards %q", text)
+	}
+
+	for i := 0; i < len(text); i += 2 {
+		value := slices.Index(valueLookup, text[i:i+1])
+		suit := slices.Index(suitLookup, text[i+1:i+2])
+		if value < 2 || suit < 0 {
+			return nil, fmt.Errorf("invalid card %q", text[i:i+2])
+		}
+		cards = append(cards, card{value: value, suit: suit})
+	}
+
+	return cards, nil
+}
+
+func strategyName(strategy BotStrategy) string {
+	if strategy == nil {
+		return ""
+	}
+	return strategy.Name()
+}
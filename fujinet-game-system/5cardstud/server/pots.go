@@ -0,0 +1,99 @@
+package main
+
+import (
+	"sort"
+
+	"golang.org/x/exp/slices"
+)
+
+// Side pots - a player that went all-in can only win, from each other player, as much as they put in themselves.
+// The main pot is contested by every player left at the showdown, and each side pot by the players that put in
+// more than the all-in player below them. Chips of players that folded or left stay in the pots they reached.
+
+type sidePot struct {
+	Amount  int
+	Players []int // Indexes of the players that can win this pot
+}
+
+// Splits the chips put in this game into the main pot followed by any side pots
+func (state *GameState) buildPots() []sidePot {
+	levels := []int{}
+	for _, player := range state.Players {
+		if player.Status == STATUS_PLAYING && !slices.Contains(levels, player.totalBet) {
+			levels = append(levels, player.totalBet)
+		}
+	}
+	sort.Ints(levels)
+
+	pots := []sidePot{}
+	counted := 0
+	previous := 0
+
+	for _, level := range levels {
+		pot := sidePot{}
+		for index, player := range state.Players {
+			pot.Amount += clamp(player.totalBet, previous, level) - previous
+			if player.Status == STATUS_PLAYING && player.totalBet >= level {
+				pot.Players = append(pot.Players, index)
+			}
+		}
+		counted += pot.Amount
+		previous = level
+		pots = append(pots, pot)
+	}
+
+	if len(pots) == 0 {
+		return pots
+	}
+
+	// Chips of folded players above the highest all-in go to the last pot, and chips of
+	// players that already left the table (and are no longer in Players) go to the main pot
+	for _, player := range state.Players {
+		if player.totalBet > previous {
+			pots[len(pots)-1].Amount += player.totalBet - previous
+			counted += player.totalBet - previous
+		}
+	}
+	pots[0].Amount += state.Pot - counted
+
+	return pots
+}
+
+// Splits a pot between the winners. Odd chips go one each to the winners closest to the left of the dealer.
+// An empty potName is the uncalled part of a bet going back to the player
+func (state *GameState) splitPot(amount int, winners []int, potName string) {
+	sort.Slice(winners, func(i, j int) bool {
+		return state.seatsAfterDealer(winners[i]) < state.seatsAfterDealer(winners[j])
+	})
+
+	share := amount / len(winners)
+	oddChips := amount % len(winners)
+
+	for i, index := range winners {
+		won := share
+		if i < oddChips {
+			won++
+		}
+		state.Players[index].Purse += won
+		if potName == "" {
+			state.record("Uncalled bet (%d) returned to %s", won, state.Players[index].Name)
+		} else {
+			state.record("%s collected %d from %s", state.Players[index].Name, won, potName)
+		}
+	}
+}
+
+// How many seats to the left of the dealer the player sits, from 1 (next to the dealer) to the number of players (the dealer)
+func (state *GameState) seatsAfterDealer(index int) int {
+	return (index-state.dealer+len(state.Players)-1)%len(state.Players) + 1
+}
+
+func clamp(value int, min int, max int) int {
+	if value < min {
+		return min
+	}
+	if value > max {
+		return max
+	}
+	return value
+}
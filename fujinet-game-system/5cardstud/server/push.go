@@ -0,0 +1,265 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/goccy/go-json"
+	"golang.org/x/exp/slices"
+)
+
+// Server pushed updates - every table has a background ticker that advances bots and move timers while
+// humans are seated, so the game goes on without clients polling. Saving a table wakes up the requests
+// waiting on it: /state?wait=N long-polls until the client's state changes, and /events streams it.
+
+const TICK_INTERVAL = time.Second
+const MAX_WAIT_SECONDS = 30
+const EVENTS_HEARTBEAT = 15 * time.Second
+
+// Per table channel, closed and replaced when the table changes
+var tableUpdates sync.Map
+var tableUpdatesMutex sync.Mutex
+
+// Returns a channel that is closed the next time the table changes
+func tableChanged(table string) <-chan struct{} {
+	tableUpdatesMutex.Lock()
+	defer tableUpdatesMutex.Unlock()
+
+	value, ok := tableUpdates.Load(table)
+	if !ok {
+		value = make(chan struct{})
+		tableUpdates.Store(table, value)
+	}
+	return value.(chan struct{})
+}
+
+// Wakes up everything waiting on the table
+func notifyTable(table string) {
+	tableUpdatesMutex.Lock()
+	defer tableUpdatesMutex.Unlock()
+
+	if value, ok := tableUpdates.Load(table); ok {
+		close(value.(chan struct{}))
+	}
+	tableUpdates.Store(table, make(chan struct{}))
+}
+
+func startTableTickers() {
+	for _, table := range getTables() {
+		startTableTicker(table.Table)
+	}
+}
+
+// Ticks the table until it is removed
+func startTableTicker(table string) {
+	go func() {
+		ticker := time.NewTicker(TICK_INTERVAL)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if !tickTable(table) {
+				return
+			}
+		}
+	}()
+}
+
+// Advances the game of a table, as a client's /state would, if there are any humans seated.
+// The table is only saved if the game moved on. Returns false if there is no such table
+func tickTable(table string) bool {
+	unlock := tableMutex.Lock(table)
+	defer unlock()
+
+	value, ok := stateMap.Load(table)
+	if !ok {
+		return false
+	}
+
+	stateCopy := *value.(*GameState)
+	state := &stateCopy
+	if !state.hasHumanPlayers() {
+		return true
+	}
+
+	before := state.progress()
+	state.clientPlayer = -1
+	state.clientSpectator = -1
+	state.authorized = false
+	state.runGameLogic()
+
+	if !state.progress().equal(before) {
+		saveState(state)
+	}
+	return true
+}
+
+func (state *GameState) hasHumanPlayers() bool {
+	for _, player := range state.Players {
+		if !player.isBot {
+			return true
+		}
+	}
+	return false
+}
+
+// What the game logic can change at a table, internal state included, e.g. a new move deadline
+type gameProgress struct {
+	table   tableProgress
+	players []playerProgress
+}
+
+type tableProgress struct {
+	round        int
+	activePlayer int
+	pot          int
+	currentBet   int
+	deckIndex    int
+	handNumber   int
+	gameOver     bool
+	moveExpires  time.Time
+	lastResult   string
+	board        int
+	spectators   int
+}
+
+type playerProgress struct {
+	name   string
+	status Status
+	bet    int
+	move   string
+	purse  int
+	cards  int
+}
+
+func (state *GameState) progress() gameProgress {
+	progress := gameProgress{table: tableProgress{
+		round:        state.Round,
+		activePlayer: state.ActivePlayer,
+		pot:          state.Pot,
+		currentBet:   state.currentBet,
+		deckIndex:    state.deckIndex,
+		handNumber:   state.handNumber,
+		gameOver:     state.gameOver,
+		moveExpires:  state.moveExpires,
+		lastResult:   state.LastResult,
+		board:        len(state.board),
+		spectators:   len(state.spectators),
+	}}
+	for _, player := range state.Players {
+		progress.players = append(progress.players, playerProgress{player.Name, player.Status, player.Bet, player.Move, player.Purse, len(player.cards)})
+	}
+	return progress
+}
+
+func (progress gameProgress) equal(other gameProgress) bool {
+	return progress.table == other.table && slices.Equal(progress.players, other.players)
+}
+
+// Returns the client state of the table without advancing the game, or nil if there is no such table
+func viewClientState(request clientRequest) *GameState {
+	state, unlock := getTableState(request)
+	defer unlock()
+
+	if state != nil {
+		state = state.createClientState()
+	}
+	return state
+}
+
+// Returns the client state, keeping the client player seated
+func pingClientState(request clientRequest) *GameState {
+	state, unlock := getTableState(request)
+	defer unlock()
+
+	if state != nil {
+		state.requireToken()
+		if state.clientPlayer >= 0 {
+			state.playerPing()
+			saveState(state)
+		} else if state.clientSpectator >= 0 {
+			state.spectatorPing()
+			saveState(state)
+		}
+		state = state.createClientState()
+	}
+	return state
+}
+
+// Long-poll - waits until the client state no longer has the hash, the wait time passes or the client goes away.
+// Returns the latest client state
+func waitForChange(c *gin.Context, state *GameState, hash string, wait int) *GameState {
+	if wait > MAX_WAIT_SECONDS {
+		wait = MAX_WAIT_SECONDS
+	}
+	timeout := time.After(time.Duration(wait) * time.Second)
+	request := newClientRequest(c)
+
+	for state != nil && state.Hash == hash {
+		changed := tableChanged(state.table)
+
+		// Check again, in case the table changed before waiting on it
+		if state = viewClientState(request); state == nil || state.Hash != hash {
+			break
+		}
+
+		select {
+		case <-changed:
+			state = viewClientState(request)
+		case <-timeout:
+			return state
+		case <-c.Request.Context().Done():
+			return state
+		}
+	}
+	return state
+}
+
+// Streams the client state as Server-Sent Events, each time it changes. Being connected keeps the player seated
+func apiEvents(c *gin.Context) {
+	table := tableKey(c.Query("table"))
+	if _, ok := stateMap.Load(table); !ok {
+		c.String(http.StatusNotFound, "Table not found")
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+
+	heartbeat := time.NewTicker(EVENTS_HEARTBEAT)
+	defer heartbeat.Stop()
+
+	// The first event joins the table, like /state. A player that just sat down gets their token with it,
+	// and the stream keeps using it
+	request := newClientRequest(c)
+	state := pingClientState(request)
+	if state != nil && state.Token != "" {
+		request.token = state.Token
+	}
+	changed := tableChanged(table)
+	lastHash := ""
+
+	for {
+		if state != nil && state.Hash != lastHash {
+			data, _ := json.Marshal(state)
+			fmt.Fprintf(c.Writer, "event: state\ndata: %s\n\n", data)
+			lastHash = state.Hash
+		}
+		c.Writer.Flush()
+
+		select {
+		case <-changed:
+			changed = tableChanged(table)
+			state = viewClientState(request)
+		case <-heartbeat.C:
+			state = pingClientState(request)
+			changed = tableChanged(table)
+			fmt.Fprint(c.Writer, ": heartbeat\n\n")
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
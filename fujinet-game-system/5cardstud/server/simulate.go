@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// Simulation - pits bot strategies against each other at one table over many games, instead of running the server:
+//
+//	SIMULATE=5000 SIMULATE_BOTS=montecarlo,tight,loose,classic SIMULATE_VARIANT=holdem SIMULATE_BETTING=nolimit go run .
+//
+// Every bot plays the strategy at its seat. Bots that go broke rebuy, as at the real tables.
+
+type SimulationResult struct {
+	Strategy string
+	Games    int
+	Won      int // Games that ended with more chips than the bot started with
+	Chips    int // Net chips won
+}
+
+// Plays games at a table of the given bots, and returns the results of each seat
+func simulate(variant *Variant, betting BettingStructure, bots []BotStrategy, games int) []SimulationResult {
+	state := createGameState(variant, betting, bots, false)
+	state.clientPlayer = 0
+
+	results := []SimulationResult{}
+	for _, strategy := range bots {
+		results = append(results, SimulationResult{Strategy: strategy.Name()})
+	}
+
+	// The chips of each bot when the game started, after any rebuy, and if it played that game
+	started := make([]int, len(bots))
+	playing := make([]bool, len(bots))
+	played := 0
+
+	for step := 0; played < games && step < games*1000; step++ {
+		state.moveExpires = time.Now().Add(-time.Second)
+		wasOver := state.gameOver
+		round := state.Round
+		state.runGameLogic()
+
+		if round != 1 && state.Round == 1 {
+			for i, player := range state.Players {
+				started[i] = player.Purse + player.totalBet + player.Bet
+				playing[i] = player.Status == STATUS_PLAYING
+			}
+		}
+
+		if state.gameOver && !wasOver {
+			played++
+			for i, player := range state.Players {
+				if !playing[i] {
+					continue
+				}
+				results[i].Games++
+				results[i].Chips += player.Purse - started[i]
+				if player.Purse > started[i] {
+					results[i].Won++
+				}
+			}
+		}
+	}
+
+	return results
+}
+
+// Runs the simulation set up by the SIMULATE_* environment variables and prints the results
+func runSimulation(games int) {
+	initializeGameServer()
+
+	variant := getVariant(os.Getenv("SIMULATE_VARIANT"))
+	if variant == nil {
+		log.Fatalf("Unknown SIMULATE_VARIANT %q", os.Getenv("SIMULATE_VARIANT"))
+	}
+
+	betting := limitStakes
+	if bettingType := os.Getenv("SIMULATE_BETTING"); bettingType != "" {
+		var ok bool
+		if betting, ok = getBettingStructure(bettingType); !ok {
+			log.Fatalf("Unknown SIMULATE_BETTING %q", bettingType)
+		}
+	}
+
+	bots := []BotStrategy{}
+	names := os.Getenv("SIMULATE_BOTS")
+	if names == "" {
+		names = "montecarlo,tight,loose,classic"
+	}
+	for _, name := range strings.Split(names, ",") {
+		strategy := getBotStrategy(strings.TrimSpace(name))
+		if strategy == nil {
+			log.Fatalf("Unknown bot strategy %q in SIMULATE_BOTS", name)
+		}
+		bots = append(bots, strategy)
+	}
+
+	if len(bots) < 2 || len(bots) > variant.MaxPlayers || len(bots) > len(botNames) {
+		log.Fatalf("SIMULATE_BOTS needs 2 to %d bots", variant.MaxPlayers)
+	}
+
+	log.Printf("Simulating %d games of %s, %s, with %s", games, variant.Name, betting, names)
+
+	// Games log their results, which is too much here
+	logger := log.Writer()
+	log.SetOutput(io.Discard)
+	results := simulate(variant, betting, bots, games)
+	log.SetOutput(logger)
+
+	fmt.Printf("%-4s %-12s %8s %8s %10s %10s\n", "Seat", "Strategy", "Games", "Won", "Chips", "Per game")
+	for i, result := range results {
+		perGame := 0.0
+		if result.Games > 0 {
+			perGame = float64(result.Chips) / float64(result.Games)
+		}
+		fmt.Printf("%-4d %-12s %8d %8d %10d %10.2f\n", i+1, result.Strategy, result.Games, result.Won, result.Chips, perGame)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/exp/slices"
+)
+
+// Spectators - a named client that can't (or with spectate=1, doesn't want to) sit down watches the table
+// from a spectator seat, up to MAX_SPECTATORS a table. Spectators see what anonymous viewers see, so never
+// the down cards of the players. At a full table, spectators that want to play wait in a queue and are
+// seated in turn as players leave. Players and spectators share a short chat log in the state.
+
+const MAX_SPECTATORS = 20
+const CHAT_SIZE = 10
+const CHAT_MESSAGE_LENGTH = 40
+
+type Spectator struct {
+	name     string
+	token    string
+	lastPing time.Time
+	queued   bool // Waiting for a seat
+}
+
+type chatMessage struct {
+	Name string `json:"n"`
+	Text string `json:"t"`
+}
+
+func (state *GameState) findSpectator(name string) int {
+	return slices.IndexFunc(state.spectators, func(s Spectator) bool { return strings.EqualFold(s.name, name) })
+}
+
+// Gives the client a spectator seat, if there is one left. Only spectators that know the password of
+// the table may queue for a seat
+func (state *GameState) addSpectator(request clientRequest) {
+	state.dropInactiveSpectators()
+	if len(state.spectators) >= MAX_SPECTATORS {
+		return
+	}
+
+	token, ok := seatToken(request)
+	if !ok {
+		// Rejected by the identity service, so only viewing
+		return
+	}
+
+	state.spectators = append(state.spectators, Spectator{
+		name:     request.player,
+		token:    token,
+		lastPing: state.now(),
+		queued:   !request.spectate && state.passwordMatches(request.password) && !state.registrationClosed(),
+	})
+	state.clientSpectator = len(state.spectators) - 1
+	state.authorized = true
+}
+
+func (state *GameState) passwordMatches(password string) bool {
+	return state.password == "" || strings.EqualFold(state.password, password)
+}
+
+// If a spectator is waiting for a seat
+func (state *GameState) hasQueue() bool {
+	return slices.ContainsFunc(state.spectators, func(s Spectator) bool { return s.queued })
+}
+
+// Seats the queued spectators, first come first served, while there are free seats.
+// Spectators seated at another table keep their place in the queue
+func (state *GameState) seatSpectators() {
+	for len(state.Players) < state.maxPlayers() && !state.registrationClosed() {
+		index := -1
+		for i, s := range state.spectators {
+			if s.queued && (state.isTournament() || claimSeat(s.name, state.table)) {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			return
+		}
+
+		spectator := state.spectators[index]
+		state.spectators = slices.Delete(append([]Spectator{}, state.spectators...), index, index+1)
+
+		state.addPlayer(spectator.name, false)
+		player := &state.Players[len(state.Players)-1]
+		player.token = spectator.token
+		player.lastPing = spectator.lastPing
+		state.updateLobby()
+	}
+}
+
+func (state *GameState) dropInactiveSpectators() {
+	cutoff := state.now().Add(PLAYER_PING_TIMEOUT)
+	var spectators []Spectator
+	for _, spectator := range state.spectators {
+		if spectator.lastPing.Compare(cutoff) > 0 {
+			spectators = append(spectators, spectator)
+		}
+	}
+	state.spectators = spectators
+}
+
+func (state *GameState) spectatorPing() {
+	state.spectators[state.clientSpectator].lastPing = state.now()
+	state.lastActive = state.now()
+}
+
+func (state *GameState) spectatorLeave() {
+	state.spectators = slices.Delete(append([]Spectator{}, state.spectators...), state.clientSpectator, state.clientSpectator+1)
+	state.clientSpectator = -1
+}
+
+// Position of the client spectator in the queue for a seat, starting at 1, or 0 if not queued
+func (state *GameState) queuePosition() int {
+	if state.clientSpectator < 0 || state.clientSpectator >= len(state.spectators) || !state.spectators[state.clientSpectator].queued {
+		return 0
+	}
+
+	position := 0
+	for _, spectator := range state.spectators[:state.clientSpectator+1] {
+		if spectator.queued {
+			position++
+		}
+	}
+	return position
+}
+
+// Number of spectators that are still watching
+func (state *GameState) spectatorCount() int {
+	cutoff := state.now().Add(PLAYER_PING_TIMEOUT)
+	count := 0
+	for _, spectator := range state.spectators {
+		if spectator.lastPing.Compare(cutoff) > 0 {
+			count++
+		}
+	}
+	return count
+}
+
+// Adds a message to the chat log, keeping the last CHAT_SIZE messages
+func (state *GameState) addChat(name string, text string) {
+	text = strings.TrimSpace(text)
+	if len(text) > CHAT_MESSAGE_LENGTH {
+		text = text[:CHAT_MESSAGE_LENGTH]
+	}
+	if text == "" {
+		return
+	}
+
+	chat := append(append([]chatMessage{}, state.Chat...), chatMessage{Name: name, Text: text})
+	if len(chat) > CHAT_SIZE {
+		chat = chat[len(chat)-CHAT_SIZE:]
+	}
+	state.Chat = chat
+}
+
+// Adds the message in msg to the chat log of the table, from the client player or spectator with their token.
+// Returns the updated state
+func apiChat(c *gin.Context) {
+	state, unlock := getState(c)
+	unauthorized := false
+
+	func() {
+		defer unlock()
+
+		if state != nil {
+			name := ""
+			if state.clientPlayer >= 0 {
+				name = state.Players[state.clientPlayer].Name
+			} else if state.clientSpectator >= 0 {
+				name = state.spectators[state.clientSpectator].name
+			}
+
+			if unauthorized = name == "" || !state.authorized; unauthorized {
+				return
+			}
+
+			state.addChat(name, c.Query("msg"))
+			saveState(state)
+			state = state.createClientState()
+		}
+	}()
+
+	if unauthorized {
+		c.String(http.StatusUnauthorized, "Invalid token")
+		return
+	}
+	serializeResults(c, state)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/goccy/go-json"
+)
+
+// Player statistics - for every human player, the games they were dealt in, how they played them and
+// the biggest pot they won, kept like the bankroll by case insensitive name and saved to the state store, if any.
+// A game counts once it is over. Games that were aborted, and games a player joined too late for, don't count.
+
+type PlayerStats struct {
+	Name         string `json:"name"`
+	Hands        int    `json:"hands"`
+	Voluntary    int    `json:"voluntary"` // Hands the player put chips in the pot by choice, not just the bring-in or blinds
+	Folds        int    `json:"folds"`     // Hands folded, or left before the end
+	Showdowns    int    `json:"showdowns"`
+	ShowdownsWon int    `json:"showdownsWon"`
+	BiggestPot   int    `json:"biggestPot"` // Chips won in a game, tournament chips aside
+}
+
+var playerStats = map[string]*PlayerStats{}
+var statsMutex sync.Mutex
+
+// Statistics of a player for the client, as percentages
+type StatsSummary struct {
+	Name        string `json:"n"`
+	Hands       int    `json:"h"`
+	VPIP        int    `json:"v"` // Voluntarily put chips in the pot, % of hands
+	FoldRate    int    `json:"f"` // % of hands folded
+	Showdowns   int    `json:"s"`
+	ShowdownWin int    `json:"w"` // % of showdowns won
+	BiggestPot  int    `json:"b"`
+}
+
+// Updates the statistics of a human player, creating them if needed
+func updateStats(player *Player, update func(*PlayerStats)) {
+	if player.isBot {
+		return
+	}
+
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+
+	key := strings.ToLower(player.Name)
+	stats, ok := playerStats[key]
+	if !ok {
+		stats = &PlayerStats{}
+		playerStats[key] = stats
+	}
+
+	stats.Name = player.Name
+	update(stats)
+	persistStats(key, stats)
+}
+
+// Counts the game that just ended for every player dealt in. purses are the purses before the pot was awarded,
+// and winners the players that won a pot at the showdown, if there was one
+func (state *GameState) recordStats(purses []int, showdown bool, winners map[int]bool) {
+	for i := range state.Players {
+		player := &state.Players[i]
+		if len(player.cards) == 0 {
+			continue
+		}
+
+		updateStats(player, func(s *PlayerStats) {
+			s.Hands++
+			if player.voluntary {
+				s.Voluntary++
+			}
+			if player.Status == STATUS_FOLDED || player.Status == STATUS_LEFT {
+				s.Folds++
+			}
+			if showdown && player.Status == STATUS_PLAYING {
+				s.Showdowns++
+				if winners[i] {
+					s.ShowdownsWon++
+				}
+			}
+			if won := player.Purse - purses[i]; won > s.BiggestPot && !player.tournament {
+				s.BiggestPot = won
+			}
+		})
+	}
+}
+
+func persistStats(key string, stats *PlayerStats) {
+	if stateStore == nil {
+		return
+	}
+
+	data, err := json.Marshal(stats)
+	if err != nil {
+		log.Printf("Unable to persist the stats of %s: %s", stats.Name, err)
+		return
+	}
+	queueWrite(func(batch *storeBatch) { batch.Stats[key] = data })
+}
+
+// Loads the statistics of every player from the store
+func loadStats() {
+	saved, err := stateStore.LoadStats()
+	if err != nil {
+		log.Printf("Unable to load the stats: %s", err)
+		return
+	}
+
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+
+	for key, data := range saved {
+		stats := &PlayerStats{}
+		if err := json.Unmarshal(data, stats); err != nil {
+			log.Printf("Unable to load the stats of %s: %s", key, err)
+			continue
+		}
+		playerStats[key] = stats
+	}
+
+	log.Printf("Loaded the stats of %d players", len(playerStats))
+}
+
+// Returns the statistics of the player, or false if they have not finished a game
+func getStats(playerName string) (StatsSummary, bool) {
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+
+	stats, ok := playerStats[strings.ToLower(playerName)]
+	if !ok {
+		return StatsSummary{}, false
+	}
+
+	percent := func(count int, total int) int {
+		if total == 0 {
+			return 0
+		}
+		return (100*count + total/2) / total
+	}
+
+	return StatsSummary{
+		Name:        stats.Name,
+		Hands:       stats.Hands,
+		VPIP:        percent(stats.Voluntary, stats.Hands),
+		FoldRate:    percent(stats.Folds, stats.Hands),
+		Showdowns:   stats.Showdowns,
+		ShowdownWin: percent(stats.ShowdownsWon, stats.Showdowns),
+		BiggestPot:  stats.BiggestPot,
+	}, true
+}
+
+// Returns the statistics of the player in "player"
+func apiStats(c *gin.Context) {
+	stats, ok := getStats(c.Query("player"))
+	if !ok {
+		c.String(http.StatusNotFound, "Player not found")
+		return
+	}
+	serializeResults(c, stats)
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Custom tables - anyone can open a table with POST /create, e.g. for a game with friends, without the server
+// being redeployed. A custom table is listed in /tables unless it is private, and may have a password
+// that is needed to sit down (pw query parameter). It is removed once nobody has played at it for a while.
+// Each client address may only have a few custom tables open, so a single client can't take them all.
+// A custom table can also be a sit-and-go tournament, see tournament.go
+
+const MAX_CUSTOM_TABLES = 50
+const MAX_CUSTOM_TABLES_PER_CLIENT = 3
+const CUSTOM_TABLE_IDLE_TIME = 15 * time.Minute
+const CUSTOM_TABLE_NAME_LENGTH = 20
+const CLEANUP_INTERVAL = time.Minute
+
+var tablesMutex sync.Mutex
+
+// Returns a copy of the list of tables
+func getTables() []GameTable {
+	tablesMutex.Lock()
+	defer tablesMutex.Unlock()
+	return append([]GameTable{}, tables...)
+}
+
+func addTable(table GameTable) {
+	tablesMutex.Lock()
+	defer tablesMutex.Unlock()
+	tables = append([]GameTable{table}, tables...)
+}
+
+var errTooManyTables = errors.New("Too many tables")
+var errTooManyClientTables = errors.New("Too many tables created from this address")
+
+// Opens a custom table for the creator, a tournament if the tournament has seats.
+// Returns an error if there are too many already, in all or from the creator
+func createCustomTable(creator string, name string, variant *Variant, betting BettingStructure, bots int, password string, private bool, tournament Tournament) (GameTable, error) {
+	tablesMutex.Lock()
+	defer tablesMutex.Unlock()
+
+	count, created := 0, 0
+	for _, table := range tables {
+		if value, ok := stateMap.Load(table.Table); ok && value.(*GameState).custom {
+			count++
+			if value.(*GameState).creator == creator {
+				created++
+			}
+		}
+	}
+	if count >= MAX_CUSTOM_TABLES {
+		return GameTable{}, errTooManyTables
+	}
+	if created >= MAX_CUSTOM_TABLES_PER_CLIENT {
+		return GameTable{}, errTooManyClientTables
+	}
+
+	// Table ids are 8 characters, to fit the binary table list
+	id := strings.ToLower(newToken())
+	for _, ok := stateMap.Load(id); ok; _, ok = stateMap.Load(id) {
+		id = strings.ToLower(newToken())
+	}
+
+	state := createGameState(variant, betting, nil, false)
+	state.tournament = tournament
+	state.seatBots(mixedBots(bots))
+	state.table = id
+	state.serverName = name
+	state.custom = true
+	state.private = private
+	state.password = password
+	state.creator = creator
+	state.lastActive = state.now()
+	saveState(state)
+
+	table := GameTable{Table: id, Name: name, Stakes: betting.String(), Password: password != "", Tournament: int(state.tournamentStatus())}
+	table.MaxPlayers, _ = state.getHumanPlayerCountInfo()
+	tables = append(tables, table)
+	startTableTicker(id)
+
+	log.Printf("Created table %s (%s) for %s", id, name, creator)
+	return table, nil
+}
+
+// Adds the custom tables restored from the state file to the list of tables
+func restoreCustomTables() {
+	stateMap.Range(func(key, value any) bool {
+		if state := value.(*GameState); state.custom {
+			addTable(GameTable{Table: state.table, Name: state.serverName, Stakes: state.betting.String(), Password: state.password != ""})
+		}
+		return true
+	})
+}
+
+// Removes the custom tables nobody has played at for CUSTOM_TABLE_IDLE_TIME
+func cleanupIdleTables() {
+	for _, table := range getTables() {
+		func() {
+			unlock := tableMutex.Lock(table.Table)
+			defer unlock()
+
+			value, ok := stateMap.Load(table.Table)
+			if !ok {
+				return
+			}
+			if state := value.(*GameState); !state.custom || state.now().Sub(state.lastActive) < CUSTOM_TABLE_IDLE_TIME {
+				return
+			}
+
+			deleteTable(table.Table)
+			log.Printf("Removed idle table %s (%s)", table.Table, table.Name)
+		}()
+	}
+}
+
+// Removes a table from the list, the state map and the store. The table must be locked
+func deleteTable(id string) {
+	stateMap.Delete(id)
+	releaseSeats(id)
+	deleteState(id)
+
+	tablesMutex.Lock()
+	tables = removeTable(tables, id)
+	tablesMutex.Unlock()
+
+	// Wake up anyone still waiting on the table
+	notifyTable(id)
+}
+
+func removeTable(tables []GameTable, id string) []GameTable {
+	result := []GameTable{}
+	for _, table := range tables {
+		if table.Table != id {
+			result = append(result, table)
+		}
+	}
+	return result
+}
+
+func startTableCleanup() {
+	go func() {
+		for range time.Tick(CLEANUP_INTERVAL) {
+			cleanupIdleTables()
+		}
+	}()
+}
+
+// Creates a table, POST only. name, bots (default 0), variant and stakes (limit, pot or nolimit) are optional.
+// With pw, players need the password to sit down. With private=1 the table is not listed in /tables.
+// With tournament=1 the table is a sit-and-go tournament, see parseTournament
+func apiCreateTable(c *gin.Context) {
+	variant := getVariant(c.Query("variant"))
+	betting, ok := limitStakes, true
+	if stakes := c.Query("stakes"); stakes != "" {
+		betting, ok = getBettingStructure(stakes)
+	}
+	bots, err := strconv.Atoi(c.DefaultQuery("bots", "0"))
+
+	// Leave at least one seat for a human
+	if variant == nil || !ok || err != nil || bots < 0 || bots >= variant.MaxPlayers {
+		c.String(http.StatusBadRequest, "Invalid table settings")
+		return
+	}
+
+	tournament := Tournament{}
+	if c.Query("tournament") == "1" {
+		if tournament, ok = parseTournament(c, variant, betting, bots); !ok {
+			c.String(http.StatusBadRequest, "Invalid table settings")
+			return
+		}
+	}
+
+	name := strings.TrimSpace(c.Query("name"))
+	if name == "" {
+		name = "Custom Table"
+	}
+	if len(name) > CUSTOM_TABLE_NAME_LENGTH {
+		name = name[:CUSTOM_TABLE_NAME_LENGTH]
+	}
+
+	table, err := createCustomTable(c.ClientIP(), name, variant, betting, bots, c.Query("pw"), c.Query("private") == "1", tournament)
+	switch {
+	case errors.Is(err, errTooManyClientTables):
+		c.String(http.StatusTooManyRequests, err.Error())
+		return
+	case err != nil:
+		c.String(http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	serializeResults(c, table)
+}
+
+// Tournament settings of /create - seats (default all the seats of the variant), and the length of a level
+// in hands (levelhands, default 10) or minutes (levelminutes)
+func parseTournament(c *gin.Context, variant *Variant, betting BettingStructure, bots int) (Tournament, bool) {
+	seats, err := strconv.Atoi(c.DefaultQuery("seats", strconv.Itoa(variant.MaxPlayers)))
+	if err != nil || seats < 2 || seats > variant.MaxPlayers || bots >= seats {
+		return Tournament{}, false
+	}
+
+	if minutes := c.Query("levelminutes"); minutes != "" {
+		levelMinutes, err := strconv.Atoi(minutes)
+		if err != nil || levelMinutes < 1 || levelMinutes > TOURNAMENT_MAX_LEVEL_MINUTES {
+			return Tournament{}, false
+		}
+		return newTournament(seats, 0, time.Duration(levelMinutes)*time.Minute, betting), true
+	}
+
+	levelHands, err := strconv.Atoi(c.DefaultQuery("levelhands", strconv.Itoa(TOURNAMENT_DEFAULT_LEVEL_HANDS)))
+	if err != nil || levelHands < 1 || levelHands > TOURNAMENT_MAX_LEVEL_HANDS {
+		return Tournament{}, false
+	}
+	return newTournament(seats, levelHands, 0, betting), true
+}
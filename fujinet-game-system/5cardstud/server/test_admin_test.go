@@ -0,0 +1,225 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/goccy/go-json"
+)
+
+func createAdminTable(t *testing.T, table string) (*gin.Engine, *GameState) {
+	resetBankrolls()
+	state := createGameState(defaultVariant, limitStakes, mixedBots(2, classicBot{}), false)
+	state.table = table
+	state.serverName = "Admin Room"
+	makeDeterministic(state, table)
+	stateMap.Store(table, state)
+	addTable(GameTable{Table: table, Name: state.serverName})
+
+	AdminKey = "secret"
+	t.Cleanup(func() {
+		AdminKey = ""
+		stateMap.Delete(table)
+		tablesMutex.Lock()
+		tables = removeTable(tables, table)
+		tablesMutex.Unlock()
+	})
+
+	router := gin.New()
+	router.GET("/state", apiState)
+	addAdminRoutes(router)
+	return router, state
+}
+
+// Makes an admin request with the key in the header
+func adminResponse(router *gin.Engine, method string, url string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(method, url, nil)
+	r.Header.Set("X-Admin-Key", "secret")
+	router.ServeHTTP(w, r)
+	return w
+}
+
+// Makes an admin request with the key, returning the table
+func adminRequest(t *testing.T, router *gin.Engine, method string, url string) AdminTable {
+	t.Helper()
+	w := adminResponse(router, method, url)
+	if w.Code != http.StatusOK {
+		t.Fatalf("%s %s returned %d %q", method, url, w.Code, w.Body.String())
+	}
+
+	table := AdminTable{}
+	json.Unmarshal(w.Body.Bytes(), &table)
+	return table
+}
+
+func TestAdminKey(t *testing.T) {
+	router, _ := createAdminTable(t, "adminkey")
+
+	for key, code := range map[string]int{"": http.StatusUnauthorized, "Secret": http.StatusUnauthorized, "secret": http.StatusOK} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/admin/tables", nil)
+		r.Header.Set("X-Admin-Key", key)
+		router.ServeHTTP(w, r)
+		if w.Code != code {
+			t.Errorf("key %q returned %d, expected %d", key, w.Code, code)
+		}
+	}
+
+	// The key is never taken from the url, where access logs keep it
+	if w, _ := request(router, "/admin/tables?key=secret"); w.Code != http.StatusUnauthorized {
+		t.Errorf("key in the url returned %d", w.Code)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/admin/pause?table=adminkey&key=secret", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("key in the url of a post returned %d", w.Code)
+	}
+
+	AdminKey = ""
+	if w := adminResponse(router, "GET", "/admin/tables"); w.Code != http.StatusNotFound {
+		t.Errorf("disabled admin api returned %d", w.Code)
+	}
+}
+
+func TestAdminTables(t *testing.T) {
+	router, _ := createAdminTable(t, "admintables")
+	request(router, "/state?table=admintables&player=Ann")
+
+	w := adminResponse(router, "GET", "/admin/tables")
+	all := []AdminTable{}
+	json.Unmarshal(w.Body.Bytes(), &all)
+
+	for _, table := range all {
+		if table.Table != "admintables" {
+			continue
+		}
+		if len(table.Players) != 3 || !table.Players[0].Bot || table.Players[0].Strategy != "classic" || table.Players[2].Name != "Ann" {
+			t.Errorf("players %+v", table.Players)
+		}
+		// Everyone was dealt a down and an up card
+		if table.Round != 1 || table.DeckIndex != 6 || len(table.Deck) != 104 || len(table.Players[2].Cards) != 4 {
+			t.Errorf("round %d, deck at %d of %q, Ann has %q", table.Round, table.DeckIndex, table.Deck, table.Players[2].Cards)
+		}
+		return
+	}
+	t.Errorf("table not listed in %s", w.Body.String())
+}
+
+func TestAdminPause(t *testing.T) {
+	router, state := createAdminTable(t, "adminpause")
+	clock := state.clock.(*fakeClock)
+	request(router, "/state?table=adminpause&player=Ann")
+
+	before := adminRequest(t, router, "POST", "/admin/pause?table=adminpause")
+	if !before.Paused {
+		t.Fatalf("table not paused")
+	}
+
+	// Nothing moves, however long the table is paused
+	clock.now = clock.now.Add(time.Hour)
+	if _, client := request(router, "/state?table=adminpause&player=Ann"); client.LastResult != PAUSED_MESSAGE || len(client.ValidMoves) != 0 {
+		t.Errorf("paused state %q with moves %v", client.LastResult, client.ValidMoves)
+	}
+
+	// The move timer is held for the time the table was paused
+	after := adminRequest(t, router, "POST", "/admin/resume?table=adminpause")
+	if after.Paused || after.Round != before.Round || after.Active != before.Active || !after.MoveExpires.Equal(before.MoveExpires.Add(time.Hour)) {
+		t.Errorf("resumed %+v, paused %+v", after, before)
+	}
+}
+
+func TestAdminPlayers(t *testing.T) {
+	router, _ := createAdminTable(t, "adminplayers")
+	request(router, "/state?table=adminplayers&player=Ann")
+
+	table := adminRequest(t, router, "POST", "/admin/purse?table=adminplayers&player=ann&purse=500")
+	if table.Players[2].Purse != 500 || bankrolls["ann"].Purse != 500 {
+		t.Errorf("purse %d, bankroll %d", table.Players[2].Purse, bankrolls["ann"].Purse)
+	}
+
+	// A bot joins the next game, and one leaves the game in progress
+	if table = adminRequest(t, router, "POST", "/admin/bots?table=adminplayers&add=1"); len(table.Players) != 4 || !table.Players[3].Bot {
+		t.Errorf("players after adding a bot %+v", table.Players)
+	}
+	if table = adminRequest(t, router, "POST", "/admin/bots?table=adminplayers&remove=1"); table.Players[3].Status != STATUS_LEFT {
+		t.Errorf("players after removing a bot %+v", table.Players)
+	}
+
+	// Without a human left the game is over, and those that left are gone
+	if table = adminRequest(t, router, "POST", "/admin/kick?table=adminplayers&player=Ann"); len(table.Players) != 2 || !table.Players[1].Bot {
+		t.Errorf("players after the kick %+v", table.Players)
+	}
+
+	for _, url := range []string{"/admin/kick?table=adminplayers&player=Zed", "/admin/kick?table=nothere&player=Ann"} {
+		if w := adminResponse(router, "POST", url); w.Code != http.StatusNotFound {
+			t.Errorf("%s returned %d", url, w.Code)
+		}
+	}
+	for _, url := range []string{"/admin/purse?table=adminplayers&player=Clyd&purse=-1", "/admin/bots?table=adminplayers&add=9"} {
+		if w := adminResponse(router, "POST", url); w.Code != http.StatusBadRequest {
+			t.Errorf("%s returned %d", url, w.Code)
+		}
+	}
+}
+
+func TestAdminReset(t *testing.T) {
+	router, _ := createAdminTable(t, "adminreset")
+	_, client := request(router, "/state?table=adminreset&player=Ann")
+	token := client.Token
+
+	// Ann's ante goes back to them, and they keep the seat
+	table := adminRequest(t, router, "POST", "/admin/reset?table=adminreset")
+	if table.Round != 0 || table.Pot != 0 || len(table.Players) != 3 || table.Players[2].Purse != STARTING_PURSE || table.Players[2].Cards != "" {
+		t.Errorf("table after the reset %+v", table)
+	}
+	if _, client = request(router, "/state?table=adminreset&player=Ann&k="+token); client.Token != token || client.Round != 1 {
+		t.Errorf("Ann rejoined with token %q in round %d", client.Token, client.Round)
+	}
+}
+
+func TestAdminDashboard(t *testing.T) {
+	router, _ := createAdminTable(t, "admindash")
+	request(router, "/state?table=admindash&player=Ann")
+
+	// Without the key the dashboard asks for it
+	if w, _ := request(router, "/admin"); w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "name='key'") {
+		t.Errorf("dashboard without the key returned %d %q", w.Code, w.Body.String())
+	}
+
+	// The key posted to the dashboard is kept in a cookie
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/admin", strings.NewReader("key=secret"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	router.ServeHTTP(w, r)
+	cookies := w.Result().Cookies()
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/admin" || len(cookies) != 1 || !cookies[0].HttpOnly {
+		t.Fatalf("login returned %d to %q with cookies %v", w.Code, w.Header().Get("Location"), cookies)
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "/admin", nil)
+	r.AddCookie(cookies[0])
+	router.ServeHTTP(w, r)
+	for _, text := range []string{"Admin Room", "admindash", "action='/admin/kick'", "value='Ann'"} {
+		if !strings.Contains(w.Body.String(), text) {
+			t.Errorf("dashboard is missing %q", text)
+		}
+	}
+	if strings.Contains(w.Body.String(), "secret") {
+		t.Errorf("dashboard shows the key")
+	}
+
+	// Dashboard forms come back to the dashboard, without the key in the url
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("POST", "/admin/pause?table=admindash&dashboard=1", nil)
+	r.AddCookie(cookies[0])
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/admin" {
+		t.Errorf("dashboard form returned %d to %q", w.Code, w.Header().Get("Location"))
+	}
+}
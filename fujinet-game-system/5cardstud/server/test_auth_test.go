@@ -0,0 +1,170 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/goccy/go-json"
+)
+
+func createAuthTable(table string) *gin.Engine {
+	state := createGameState(defaultVariant, limitStakes, mixedBots(2, classicBot{}), false)
+	state.table = table
+	stateMap.Store(table, state)
+
+	router := gin.New()
+	router.GET("/state", apiState)
+	router.GET("/move/:move", apiMove)
+	router.GET("/leave", apiLeave)
+	return router
+}
+
+func request(router *gin.Engine, url string) (*httptest.ResponseRecorder, *GameState) {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+
+	state := &GameState{}
+	json.Unmarshal(w.Body.Bytes(), state)
+	return w, state
+}
+
+func TestPlayerTokens(t *testing.T) {
+	resetBankrolls()
+	router := createAuthTable("auth")
+
+	// The token comes with the seat
+	_, state := request(router, "/state?table=auth&player=Ann")
+	token := state.Token
+	if len(token) != 8 || state.Viewing != 0 {
+		t.Fatalf("joined with token %q, viewing %d", token, state.Viewing)
+	}
+
+	// Only requests that have it see it again
+	if _, state = request(router, "/state?table=auth&player=Ann"); state.Token != "" {
+		t.Errorf("token %q returned without the token", state.Token)
+	}
+	if _, state = request(router, "/state?table=auth&player=ann&k="+token); state.Token != token {
+		t.Errorf("token %q returned, expected %q", state.Token, token)
+	}
+
+	for _, url := range []string{
+		"/move/FO?table=auth&player=Ann",
+		"/move/FO?table=auth&player=Ann&k=AAAAAAAA",
+		"/leave?table=auth&player=Ann",
+		"/leave?table=auth&player=Ann&k=" + token[1:],
+	} {
+		if w, _ := request(router, url); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s returned %d, expected %d", url, w.Code, http.StatusUnauthorized)
+		}
+	}
+
+	// Tokens are case insensitive, for clients using uc=1 or lc=1
+	if w, _ := request(router, "/leave?table=auth&player=Ann&lc=1&k="+strings.ToLower(token)); w.Code != http.StatusOK {
+		t.Errorf("leave with the token returned %d", w.Code)
+	}
+
+	// Ann was the last human at the table, so the seat is dropped right away
+	value, _ := stateMap.Load("auth")
+	if players := value.(*GameState).Players; len(players) != 2 {
+		t.Errorf("%d players after Ann left, expected 2", len(players))
+	}
+}
+
+// Without the token, a request naming a seat is an observer: no down cards, no ping and no game logic
+func TestTokenlessViewer(t *testing.T) {
+	resetBankrolls()
+	router := createAuthTable("authview")
+
+	_, state := request(router, "/state?table=authview&player=Ann")
+	token := state.Token
+	if _, state = request(router, "/state?table=authview&player=Ann&k="+token); len(state.Players) != 3 || state.Players[0].Hand == "" || strings.Contains(state.Players[0].Hand, "??") {
+		t.Fatalf("Ann sees %+v", state.Players)
+	}
+
+	value, _ := stateMap.Load("authview")
+	before := value.(*GameState)
+
+	for _, url := range []string{"/state?table=authview&player=Ann", "/state?table=authview&player=ann&k=AAAAAAAA"} {
+		_, state = request(router, url)
+		if state.Viewing != 1 {
+			t.Errorf("%s is not viewing", url)
+		}
+		for _, player := range state.Players {
+			if player.Name == "Ann" && !strings.HasPrefix(player.Hand, "??") {
+				t.Errorf("%s sees Ann's hand %q", url, player.Hand)
+			}
+		}
+	}
+
+	if value, _ = stateMap.Load("authview"); value.(*GameState) != before {
+		t.Errorf("requests without the token saved the table")
+	}
+}
+
+func TestIdentityService(t *testing.T) {
+	resetBankrolls()
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("player") != "Ann" || r.URL.Query().Get("token") != "good" {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer service.Close()
+
+	IdentityURL = service.URL
+	defer func() { IdentityURL = "" }()
+
+	router := createAuthTable("identity")
+
+	if _, state := request(router, "/state?table=identity&player=Ann&k=bad"); state.Viewing != 1 || len(state.Players) != 2 {
+		t.Errorf("player with a rejected token sat down")
+	}
+
+	if _, state := request(router, "/state?table=identity&player=Ann&k=good"); state.Viewing != 0 || state.Token != "good" {
+		t.Errorf("player with a valid token did not sit down with it, token %q", state.Token)
+	}
+}
+
+func TestIdentityOutsideLock(t *testing.T) {
+	resetBankrolls()
+	called, release := make(chan struct{}), make(chan struct{})
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(called)
+		<-release
+	}))
+	defer service.Close()
+
+	IdentityURL = service.URL
+	defer func() { IdentityURL = "" }()
+
+	router := createAuthTable("identityslow")
+
+	// Ann waits on a slow identity service
+	joined := make(chan *GameState)
+	go func() {
+		_, state := request(router, "/state?table=identityslow&player=Ann&k=slow")
+		joined <- state
+	}()
+	<-called
+
+	// Meanwhile the table answers others
+	viewed := make(chan struct{})
+	go func() {
+		request(router, "/state?table=identityslow")
+		close(viewed)
+	}()
+	select {
+	case <-viewed:
+	case <-time.After(time.Second):
+		t.Errorf("table locked while the identity service was asked")
+	}
+
+	close(release)
+	if state := <-joined; state.Viewing != 0 || state.Token != "slow" {
+		t.Errorf("Ann did not sit down once the service answered, token %q", state.Token)
+	}
+	<-viewed
+}
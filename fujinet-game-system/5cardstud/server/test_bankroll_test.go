@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func resetBankrolls() {
+	bankrollMutex.Lock()
+	bankrolls = map[string]*Bankroll{}
+	bankrollMutex.Unlock()
+
+	seatsMutex.Lock()
+	seats = map[string]string{}
+	seatsMutex.Unlock()
+}
+
+func TestBankrollFollowsPlayer(t *testing.T) {
+	resetBankrolls()
+
+	state := createGameState(defaultVariant, limitStakes, mixedBots(0), false)
+	state.addPlayer("Ann", false)
+
+	if purse := state.Players[0].Purse; purse != STARTING_PURSE {
+		t.Fatalf("new player purse = %d, expected %d", purse, STARTING_PURSE)
+	}
+
+	state.Players[0].Purse = 350
+	state.clientPlayer = 0
+	state.clientLeave()
+
+	// Joining another table, with a different case
+	other := createGameState(defaultVariant, limitStakes, mixedBots(2), false)
+	other.addPlayer("ANN", false)
+
+	if purse := other.Players[2].Purse; purse != 350 {
+		t.Errorf("returning player purse = %d, expected 350", purse)
+	}
+
+	// Bots never have a bankroll
+	if _, ok := bankrolls["clyd"]; ok {
+		t.Errorf("bot has a bankroll")
+	}
+
+	// A broke player starts over
+	other.Players[2].Purse = 1
+	recordPurse(&other.Players[2])
+
+	if purse := bankrollPurse("ann", STARTING_PURSE); purse != STARTING_PURSE {
+		t.Errorf("broke player purse = %d, expected %d", purse, STARTING_PURSE)
+	}
+}
+
+func TestBankrollOneSeat(t *testing.T) {
+	resetBankrolls()
+	router := createAuthTable("seata")
+	createAuthTable("seatb")
+
+	_, state := request(router, "/state?table=seata&player=Ann")
+	token := state.Token
+
+	// The same chips can't be played at two tables
+	if _, state = request(router, "/state?table=seatb&player=ann"); state.Viewing != 1 || len(state.Players) != 2 {
+		t.Errorf("sat down at a second table with %d players, viewing %d", len(state.Players), state.Viewing)
+	}
+
+	// Once dropped from the first table, the player can sit down at the other
+	if w, _ := request(router, "/leave?table=seata&player=Ann&k="+token); w.Code != http.StatusOK {
+		t.Fatalf("leave returned %d", w.Code)
+	}
+	if _, state = request(router, "/state?table=seatb&player=Ann"); state.Viewing != 0 || len(state.Players) != 3 {
+		t.Errorf("did not sit down after leaving, %d players, viewing %d", len(state.Players), state.Viewing)
+	}
+}
+
+func TestBankrollViewDoesNotClaim(t *testing.T) {
+	resetBankrolls()
+	router := createAuthTable("viewa")
+	router.GET("/view", apiView)
+	createAuthTable("viewb")
+
+	// The view of a table is never saved, so it doesn't keep a seat
+	if _, state := request(router, "/view?table=viewa&player=Ann"); state.Viewing != 0 {
+		t.Fatalf("view did not show Ann seated")
+	}
+	if value, _ := stateMap.Load("viewa"); len(value.(*GameState).Players) != 2 {
+		t.Fatalf("view saved the table")
+	}
+
+	if _, state := request(router, "/state?table=viewb&player=Ann"); state.Viewing != 0 || len(state.Players) != 3 {
+		t.Errorf("did not sit down after a view, %d players, viewing %d", len(state.Players), state.Viewing)
+	}
+}
+
+func TestLeaderboard(t *testing.T) {
+	resetBankrolls()
+
+	// Showdown between two players with known hands
+	state := createGameState(defaultVariant, limitStakes, mixedBots(0), false)
+	state.addPlayer("Ann", false)
+	state.addPlayer("Bob", false)
+	state.Round = 4
+	state.Pot = 100
+
+	hands := []string{"ASAHADKSKH", "2C3D5H7S9C"}
+	for i, hand := range hands {
+		state.Players[i].Status = STATUS_PLAYING
+		state.Players[i].Purse = 150
+		state.Players[i].cards, _ = stringToCards(hand)
+	}
+
+	state.endGame(false)
+
+	if state.LastResult != "Ann won with Full House, Aces full of Kings" {
+		t.Errorf("result = %q", state.LastResult)
+	}
+
+	leaderboard := getLeaderboard()
+
+	expectedPurses := []LeaderboardValue{{"Ann", 250}, {"Bob", 150}}
+	if len(leaderboard.Purses) != 2 || leaderboard.Purses[0] != expectedPurses[0] || leaderboard.Purses[1] != expectedPurses[1] {
+		t.Errorf("purses = %v, expected %v", leaderboard.Purses, expectedPurses)
+	}
+
+	if len(leaderboard.HandsWon) != 1 || leaderboard.HandsWon[0] != (LeaderboardValue{"Ann", 1}) {
+		t.Errorf("hands won = %v, expected Ann with 1", leaderboard.HandsWon)
+	}
+
+	if len(leaderboard.BestHands) != 2 || leaderboard.BestHands[0].Name != "Ann" || leaderboard.BestHands[0].Hand != "Full House, Aces full of Kings" {
+		t.Errorf("best hands = %v, expected Ann's Full House first", leaderboard.BestHands)
+	}
+
+	// Binary version for 8-bit clients: version, then per list a count, then name[9] + uint16 per entry; hands are name[9] hand[33] cards[11]
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/leaderboard?bin=1", nil)
+	apiLeaderboard(c)
+
+	buf := w.Body.Bytes()
+	expectedLength := 1 + 1 + 2*11 + 1 + 1*11 + 1 + 2*53
+	if len(buf) != expectedLength {
+		t.Fatalf("binary leaderboard is %d bytes, expected %d", len(buf), expectedLength)
+	}
+
+	if buf[0] != BIN_VERSION || buf[1] != 2 || string(buf[2:5]) != "ann" || binary.LittleEndian.Uint16(buf[11:13]) != 250 {
+		t.Errorf("unexpected binary purses % x", buf[0:24])
+	}
+}
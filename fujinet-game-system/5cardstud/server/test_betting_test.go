@@ -0,0 +1,158 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+func TestBetAmountMove(t *testing.T) {
+	// No limit Hold'em - the first player can raise the big blind to 10, up to their whole purse.
+	// The amount is the total bet
+	state := createGameState(variantHoldem, noLimitStakes, mixedBots(3), false)
+	state.clientPlayer = 0
+	state.newRound()
+
+	moves := state.getValidMoves()
+	if index := slices.IndexFunc(moves, func(m validMove) bool { return m.Move == "BA" }); index < 0 || moves[index].Name != "Raise 10-200" {
+		t.Fatalf("moves = %v, expected Raise 10-200", moves)
+	}
+
+	for _, invalid := range []string{"BA9", "BA201", "BAX", "BL", "RA"} {
+		if state.performMove(invalid, true) {
+			t.Errorf("performMove(%q) should fail", invalid)
+		}
+	}
+
+	raiser := state.ActivePlayer
+	if !state.performMove("BA55", true) {
+		t.Fatalf("performMove(BA55) failed")
+	}
+	if state.currentBet != 55 || state.Players[raiser].Bet != 55 || state.Players[raiser].Move != "RAISE" {
+		t.Errorf("after BA55: current bet %d, player bet %d, move %q", state.currentBet, state.Players[raiser].Bet, state.Players[raiser].Move)
+	}
+
+	// The next raise must be at least as big, to 105
+	if state.performMove("BA104", true) {
+		t.Errorf("performMove(BA104) should fail after a raise of 50")
+	}
+
+	// Pot limit - the raise is capped by the pot after calling: blinds 2+5 plus the 5 to call, to 5+12
+	state = createGameState(variantHoldem, potLimitStakes, mixedBots(3), false)
+	state.clientPlayer = 0
+	state.newRound()
+
+	if state.performMove("BA18", true) || !state.performMove("BA17", true) {
+		t.Errorf("pot limit raise should be capped at 17")
+	}
+
+	// Stud - a bet completes the bring-in
+	state = createGameState(defaultVariant, noLimitStakes, mixedBots(2), false)
+	state.clientPlayer = 0
+	state.newRound()
+	state.performMove("BB", true)
+
+	if !state.performMove("BA", true) || state.currentBet != LOW {
+		t.Errorf("bet without an amount = %d, expected the minimum of %d", state.currentBet, LOW)
+	}
+}
+
+// The largest bet is every chip the player has, whether it completes the bring-in or raises
+func TestBetAmountAllIn(t *testing.T) {
+	state := createGameState(defaultVariant, noLimitStakes, mixedBots(2), false)
+	state.clientPlayer = 0
+	state.newRound()
+	state.performMove("BB", true)
+
+	player := &state.Players[state.ActivePlayer]
+	player.Purse = 30
+	if _, max := state.betRange(); max != 30 {
+		t.Errorf("max bet over the bring-in = %d, expected 30", max)
+	}
+	if state.performMove("BA31", true) || !state.performMove("BA30", true) {
+		t.Fatalf("bet of the whole purse over the bring-in should be allowed, and no more")
+	}
+	if state.currentBet != 30 || player.Purse != 0 {
+		t.Errorf("after BA30: current bet %d, purse %d", state.currentBet, player.Purse)
+	}
+
+	// Raising, with 5 in already
+	state = createGameState(variantHoldem, noLimitStakes, mixedBots(3), false)
+	state.clientPlayer = 0
+	state.newRound()
+	state.performMove("CA", true)
+
+	for state.Players[state.ActivePlayer].Bet != LOW {
+		state.performMove("CA", true)
+	}
+	player = &state.Players[state.ActivePlayer]
+	player.Purse = 40
+	if moves := state.getValidMoves(); !slices.ContainsFunc(moves, func(m validMove) bool { return m.Name == "Raise 10-45" }) {
+		t.Errorf("moves = %v, expected Raise 10-45", moves)
+	}
+	if state.performMove("BA46", true) || !state.performMove("BA45", true) {
+		t.Fatalf("raise of the whole purse should be allowed, and no more")
+	}
+	if state.currentBet != 45 || player.Purse != 0 {
+		t.Errorf("after BA45: current bet %d, purse %d", state.currentBet, player.Purse)
+	}
+}
+
+func TestMaxRaises(t *testing.T) {
+	betting := limitStakes
+	betting.MaxRaises = 1
+
+	state := createGameState(variantHoldem, betting, mixedBots(3), false)
+	state.clientPlayer = 0
+	state.newRound()
+
+	if !state.performMove("RA", true) {
+		t.Fatalf("first raise failed")
+	}
+	if slices.ContainsFunc(state.getValidMoves(), func(m validMove) bool { return m.Move == "RA" }) {
+		t.Errorf("raise offered after the max number of raises")
+	}
+}
+
+// Bots play pot limit and no limit tables without getting stuck
+func TestBettingStructuresPlayToTheEnd(t *testing.T) {
+	for _, betting := range []BettingStructure{potLimitStakes, noLimitStakes} {
+		t.Run(string(betting.Type), func(t *testing.T) {
+			state := createGameState(variantHoldem, betting, mixedBots(6), false)
+			state.clientPlayer = 0
+
+			games := 0
+			for step := 0; step < 10000 && games < 20; step++ {
+				state.moveExpires = time.Now().Add(-time.Second)
+				wasOver := state.gameOver
+				state.runGameLogic()
+
+				if state.gameOver && !wasOver {
+					games++
+				}
+				for _, player := range state.Players {
+					if player.Purse < 0 {
+						t.Fatalf("%s has a negative purse", player.Name)
+					}
+				}
+			}
+
+			if games < 20 {
+				t.Errorf("only %d games were played", games)
+			}
+		})
+	}
+}
+
+func TestTablesShowStakes(t *testing.T) {
+	for betting, expected := range map[BettingStructure]string{
+		limitStakes:    "5/10 Limit",
+		potLimitStakes: "2/5 Pot Limit",
+		noLimitStakes:  "2/5 No Limit",
+	} {
+		if betting.String() != expected {
+			t.Errorf("stakes = %q, expected %q", betting.String(), expected)
+		}
+	}
+}
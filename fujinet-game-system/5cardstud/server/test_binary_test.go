@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// go test -run Binary -update rewrites the golden files after an intended change to the layout (bump BIN_VERSION)
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata")
+
+func serializeBinary(obj any, query string) []byte {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/?bin=1"+query, nil)
+	serializeResults(c, obj)
+	return w.Body.Bytes()
+}
+
+func checkGolden(t *testing.T, name string, data []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+
+	if *updateGolden {
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatalf("unable to update %s: %v", path, err)
+		}
+	}
+
+	golden, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unable to read %s: %v", path, err)
+	}
+	if !bytes.Equal(data, golden) {
+		t.Errorf("%s does not match:\n got  % x\n want % x", name, data, golden)
+	}
+}
+
+func binaryTestState() *GameState {
+	return &GameState{
+		LastResult:   "",
+		Round:        3,
+		Pot:          300,
+		ActivePlayer: 0,
+		MoveTime:     35,
+		Viewing:      0,
+		ValidMoves: []validMove{
+			{Move: "FO", Name: "Fold"},
+			{Move: "CA", Name: "Call"},
+			{Move: "RA", Name: "Raise 10"},
+		},
+		Players: []Player{
+			{Name: "Ann", Status: STATUS_PLAYING, Bet: 10, Move: "", Purse: 190, Hand: "ASKH9D"},
+			{Name: "Clyd BOT", Status: STATUS_PLAYING, Bet: 20, Move: "RAISE", Purse: 1000, Hand: "??QS7C"},
+			{Name: "Jim BOT", Status: STATUS_FOLDED, Bet: 0, Move: "FOLD", Purse: 150, Hand: "??"},
+		},
+		Token:      "DEFNLSKT",
+		Hash:       "1234567890",
+		Spectators: 2,
+		Chat:       []chatMessage{{Name: "Ann", Text: "gl all"}},
+	}
+}
+
+func TestBinaryState(t *testing.T) {
+	state := binaryTestState()
+	data := serializeBinary(state, "")
+	checkGolden(t, "state.bin", data)
+
+	// version + result + round, pot, active player, move time, viewing + board, token, hash
+	// + 3 valid moves + 3 players + spectators, queue and 1 chat message
+	if expected := 1 + 61 + 6 + 11 + 9 + 21 + 1 + 3*(3+16) + 1 + 3*(9+1+2+9+2+15) + 3 + (9 + CHAT_MESSAGE_LENGTH + 1); len(data) != expected {
+		t.Errorf("state is %d bytes, expected %d", len(data), expected)
+	}
+	if data[0] != BIN_VERSION {
+		t.Errorf("version byte %d, expected %d", data[0], BIN_VERSION)
+	}
+
+	// The pot, 300, after the version and result
+	if be := serializeBinary(state, "&be=1"); data[63] != 0x2c || data[64] != 0x01 || be[63] != 0x01 || be[64] != 0x2c {
+		t.Errorf("pot bytes % x little endian, % x big endian", data[63:65], be[63:65])
+	}
+
+	// At the end of the game, nobody is active
+	state.ActivePlayer = -1
+	state.LastResult = "Clyd BOT won with Two Pair, Queens and Sevens"
+	checkGolden(t, "state_end.bin", serializeBinary(state, "&be=1"))
+}
+
+func TestBinaryTables(t *testing.T) {
+	checkGolden(t, "tables.bin", serializeBinary([]GameTable{
+		{Table: "basement", Name: "The Basement", CurPlayers: 1, MaxPlayers: 8, Stakes: "5/10 Limit", Spectators: 3},
+		{Table: "holdemnl", Name: "No Limit Hold'em - 3 bots", CurPlayers: 0, MaxPlayers: 5, Stakes: "2/5 No Limit", Password: true},
+		{Table: "sitandgo", Name: "Sit and Go", CurPlayers: 2, MaxPlayers: 4, Stakes: "5/10 Limit", Tournament: int(TOURNAMENT_PLAYING)},
+	}, ""))
+}
+
+func TestBinaryLeaderboard(t *testing.T) {
+	checkGolden(t, "leaderboard.bin", serializeBinary(Leaderboard{
+		Purses:    []LeaderboardValue{{"Ann", 1250}, {"Bob", 150}},
+		HandsWon:  []LeaderboardValue{{"Ann", 12}},
+		BestHands: []LeaderboardHand{{"Ann", "Full House, Aces full of Kings", "ASAHADKSKH"}},
+	}, "&be=1"))
+}
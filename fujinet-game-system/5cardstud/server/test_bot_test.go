@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+var testBotStrategies = []BotStrategy{classicBot{}, tightBot{}, looseAggressiveBot{}, monteCarloBot{Trials: 20}}
+
+// Every strategy only picks valid moves, in every variant and betting structure
+func TestBotStrategiesMakeValidMoves(t *testing.T) {
+	for _, strategy := range testBotStrategies {
+		for _, variant := range variants {
+			for _, betting := range []BettingStructure{limitStakes, noLimitStakes} {
+				state := createGameState(variant, betting, mixedBots(4, strategy), false)
+				state.clientPlayer = 0
+
+				for step := 0; step < 300; step++ {
+					state.moveExpires = time.Now().Add(-time.Second)
+					if state.gameOver || state.ActivePlayer < 0 || state.Round == variant.DrawRound || state.isRoundComplete() {
+						state.runGameLogic()
+						continue
+					}
+
+					moves := state.getValidMoves()
+					if move := strategy.ChooseMove(state, moves); !state.performMove(move, true) {
+						t.Fatalf("%s bot picked %q in %s %s, valid moves %v", strategy.Name(), move, variant.Code, betting, moves)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestEstimateEquity(t *testing.T) {
+	// Pocket aces against one unknown hand win about 85% of the time
+	state := createGameState(variantHoldem, limitStakes, mixedBots(2), false)
+	state.newRound()
+	state.Players[0].cards, _ = stringToCards("ASAH")
+	state.Players[1].cards, _ = stringToCards("2C7D")
+
+	if equity := state.estimateEquity(0, 2000); equity < 0.75 || equity > 0.95 {
+		t.Errorf("pocket aces equity = %.2f, expected about 0.85", equity)
+	}
+
+	// Four of a kind can't lose to what the other player shows. Only the first card is hidden
+	state = createGameState(defaultVariant, limitStakes, mixedBots(2), false)
+	state.Round = 4
+	for i, hand := range []string{"KSKHKDKC2H", "3C2C7D9HJS"} {
+		state.Players[i].Status = STATUS_PLAYING
+		state.Players[i].cards, _ = stringToCards(hand)
+	}
+
+	if equity := state.estimateEquity(0, 100); equity != 1 {
+		t.Errorf("four of a kind equity = %.2f, expected 1", equity)
+	}
+}
+
+func TestSimulation(t *testing.T) {
+	results := simulate(variantHoldem, noLimitStakes, testBotStrategies, 50)
+
+	chips := 0
+	for _, result := range results {
+		if result.Games != 50 {
+			t.Errorf("%s played %d games, expected 50", result.Strategy, result.Games)
+		}
+		chips += result.Chips
+	}
+
+	// Chips only move between the bots
+	if chips != 0 {
+		t.Errorf("net chips of all bots = %d, expected 0", chips)
+	}
+}
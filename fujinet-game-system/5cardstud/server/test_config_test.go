@@ -0,0 +1,125 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, name string, text string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExampleConfig(t *testing.T) {
+	config, err := loadConfig("config.example.yaml")
+	if err != nil {
+		t.Fatalf("example config: %s", err)
+	}
+
+	expected := defaultConfig
+	expected.setDefaults()
+	if !reflect.DeepEqual(config, expected) {
+		t.Errorf("example config differs from the defaults:\n%+v\n%+v", config, expected)
+	}
+}
+
+func TestConfigDefaults(t *testing.T) {
+	// A regional instance only sets what differs
+	config, err := loadConfig(writeConfig(t, "eu.json", `{"lobby": {"region": "eu", "serverUrl": "https://eu.example.com/"}, "tables": [{"name": "Euro Room", "id": "euro", "bots": 3, "lobby": true}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setConfig(config)
+	defer setConfig(defaultConfig)
+	if details, endpoint := lobbySettings(); details.Region != "eu" || details.Serverurl != "https://eu.example.com/" || endpoint != LOBBY_ENDPOINT_UPSERT || len(details.Clients) != 2 {
+		t.Errorf("lobby %+v at %s", details, endpoint)
+	}
+	if table := config.Tables[0]; len(config.Tables) != 1 || table.Variant != "5cs" || table.Stakes != "limit" || len(config.BotNames) != len(defaultConfig.BotNames) {
+		t.Errorf("tables %+v with bot names %v", config.Tables, config.BotNames)
+	}
+}
+
+func TestConfigValidation(t *testing.T) {
+	for problem, text := range map[string]string{
+		"field bot not found":          "tables: [{name: A, id: a, bot: 1}]",
+		"id must have":                 "tables: [{name: A, id: Big Room}]",
+		"listed twice":                 "tables: [{name: A, id: a}, {name: B, id: a}]",
+		"needs a name":                 "tables: [{id: a}]",
+		"unknown variant":              "tables: [{name: A, id: a, variant: omaha}]",
+		"unknown stakes":               "tables: [{name: A, id: a, stakes: spread}]",
+		"too many bots":                "tables: [{name: A, id: a, variant: 7cs, bots: 7}]",
+		"unknown bot strategy":         "tables: [{name: A, id: a, bots: 1, botMix: [clever]}]",
+		"must have 1 to 4 characters":  "botNames: [Alexa, Bo]",
+		"table \"a\": too many bots":   "botNames: [Al, Bo]\ntables: [{name: A, id: a, bots: 2}]",
+		"invalid url":                  "lobby: {endpoint: lobby.fujinet.online}",
+		"needs a platform and url":     "lobby: {clients: [{platform: atari}]}",
+		"cannot unmarshal !!str `two`": "tables: [{name: A, id: a, bots: two}]",
+	} {
+		if _, err := loadConfig(writeConfig(t, "config.yaml", text)); err == nil || !strings.Contains(err.Error(), problem) {
+			t.Errorf("%q returned %v, expected %q", text, err, problem)
+		}
+	}
+
+	// An empty file keeps the defaults
+	if config, err := loadConfig(writeConfig(t, "config.yaml", "")); err != nil || len(config.Tables) != len(defaultConfig.Tables) {
+		t.Errorf("empty config returned %v", err)
+	}
+}
+
+func TestConfigReload(t *testing.T) {
+	path := writeConfig(t, "config.yaml", "tables: [{name: Room A, id: cfga}, {name: Room B, id: cfgb}, {name: Room C, id: cfgc, bots: 2}]")
+	config, _ := loadConfig(path)
+	setConfig(config)
+	updateTables(nil, config.Tables)
+	defer func() {
+		for _, id := range []string{"cfga", "cfgb", "cfgc", "cfgd"} {
+			deleteTable(id)
+		}
+		setConfig(defaultConfig)
+	}()
+
+	// A player at the table that is renamed keeps their seat
+	router := createTablesRouter()
+	request(router, "/state?table=cfgb&player=Ann")
+
+	os.WriteFile(path, []byte("tables: [{name: Room B2, id: cfgb}, {name: Room C, id: cfgc, bots: 3, stakes: nolimit}, {name: Room D, id: cfgd}]"), 0644)
+	reloadConfig(path)
+
+	listed := map[string]GameTable{}
+	for _, table := range getTables() {
+		listed[table.Table] = table
+	}
+	if _, ok := listed["cfga"]; ok {
+		t.Errorf("removed table still listed")
+	}
+	if _, ok := stateMap.Load("cfga"); ok {
+		t.Errorf("removed table still has a state")
+	}
+	if _, ok := listed["cfgd"]; !ok {
+		t.Errorf("added table not listed")
+	}
+
+	if _, state := request(router, "/state?table=cfgb&player=Ann"); listed["cfgb"].Name != "Room B2" || len(state.Players) != 1 {
+		t.Errorf("renamed table %+v with players %+v", listed["cfgb"], state.Players)
+	}
+
+	value, _ := stateMap.Load("cfgc")
+	if state := value.(*GameState); listed["cfgc"].Stakes != noLimitStakes.String() || len(state.Players) != 3 || state.betting.Type != BETTING_NO_LIMIT {
+		t.Errorf("changed table %+v with %d players", listed["cfgc"], len(state.Players))
+	}
+
+	// An invalid file changes nothing
+	os.WriteFile(path, []byte("tables: [{name: Room B, id: cfgb, variant: omaha}]"), 0644)
+	reloadConfig(path)
+	if tables := getConfig().Tables; len(tables) != 3 || tables[0].Name != "Room B2" {
+		t.Errorf("invalid config applied: %+v", tables)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/goccy/go-json"
+	"golang.org/x/exp/slices"
+)
+
+func TestShuffleDeck(t *testing.T) {
+	// The same seed always gives the same deck, so anyone can repeat the shuffle
+	deck := shuffleDeck("test")
+	if cardsToString(deck) != cardsToString(shuffleDeck("test")) {
+		t.Errorf("the same seed shuffled two different decks")
+	}
+	if cardsToString(deck) == cardsToString(shuffleDeck("test2")) || cardsToString(deck) == cardsToString(newDeck()) {
+		t.Errorf("the deck was not shuffled")
+	}
+
+	// Every card is still there once
+	for _, c := range newDeck() {
+		if !slices.Contains(deck, c) {
+			t.Errorf("%s is missing", cardsToText([]card{c}))
+		}
+	}
+	if len(deck) != 52 {
+		t.Errorf("deck has %d cards", len(deck))
+	}
+
+	if seed := newSeed(); len(seed) != 2*SEED_BYTES || seed == newSeed() {
+		t.Errorf("seed %q is not a new %d byte seed", seed, SEED_BYTES)
+	}
+}
+
+func TestCommitReveal(t *testing.T) {
+	state := createGameState(defaultVariant, limitStakes, mixedBots(3, classicBot{}), false)
+	state.clientPlayer = 0
+	state.newRound()
+
+	// The commitment is published with the first round, the seed is kept secret until the game is over
+	commitment := state.createClientState().Commitment
+	if commitment == "" || state.createClientState().Seed != "" {
+		t.Fatalf("commitment %q, seed %q at the start of the game", commitment, state.createClientState().Seed)
+	}
+	dealt := cardsToString(state.Players[1].cards)
+
+	for step := 0; step < 100 && !state.gameOver; step++ {
+		state.moveExpires = time.Now().Add(-time.Second)
+		state.runGameLogic()
+	}
+
+	end := state.createClientState()
+	if end.Commitment != commitment || end.Seed == "" {
+		t.Fatalf("commitment %q, seed %q at the end of the game", end.Commitment, end.Seed)
+	}
+
+	// Player 1 was dealt the second card of the deck
+	verification := verifyDeal(end.Seed, end.Commitment)
+	if !verification.Valid || verification.Deck[2:4] != dealt[0:2] {
+		t.Errorf("verification %v does not match the deal of %s", verification, dealt)
+	}
+
+	if verifyDeal(end.Seed, commitment[1:]+"0").Valid || verifyDeal(newSeed(), commitment).Valid {
+		t.Errorf("verification passed with the wrong seed or commitment")
+	}
+
+	// The next game has a new commitment
+	state.runGameLogic()
+	if state.Round == 1 && state.Commitment == commitment {
+		t.Errorf("the next game reused the commitment")
+	}
+}
+
+func TestVerifyApi(t *testing.T) {
+	resetHistory()
+	resetBankrolls()
+	playHands(t, "verify", 1)
+
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("GET", url, nil)
+		apiVerify(c)
+		return w
+	}
+
+	verification := Verification{}
+	json.Unmarshal(get("/verify?table=verify&hand=1").Body.Bytes(), &verification)
+	if !verification.Valid || len(verification.Deck) != 104 {
+		t.Errorf("hand 1 verification = %v", verification)
+	}
+
+	json.Unmarshal(get("/verify?seed="+verification.Seed+"&commitment="+verification.Commitment).Body.Bytes(), &verification)
+	if !verification.Valid {
+		t.Errorf("seed and commitment verification = %v", verification)
+	}
+
+	if w := get("/verify?table=verify&hand=2"); w.Code != http.StatusNotFound {
+		t.Errorf("unfinished hand returned %d, expected %d", w.Code, http.StatusNotFound)
+	}
+	if w := get("/verify"); w.Code != http.StatusBadRequest {
+		t.Errorf("missing seed returned %d, expected %d", w.Code, http.StatusBadRequest)
+	}
+}
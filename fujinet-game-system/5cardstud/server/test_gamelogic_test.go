@@ -0,0 +1,316 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// Test harness - a game of human players dealt a stacked deck, so every hand and every decision is known.
+// Nobody acts on their own: moves are made with play, and the fake clock moves on with expireTimer.
+
+var testPlayers = []string{"Ann", "Bob", "Cy", "Dee"}
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (clock *fakeClock) Now() time.Time { return clock.now }
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 20, 0, 0, 0, time.UTC)}
+}
+
+// Seeds "<seed>-1", "<seed>-2", .. Every game is dealt the stacked deck, if there is one
+type fixedRandom struct {
+	seed  string
+	count int
+	deck  []card
+}
+
+func (random *fixedRandom) NewSeed() string {
+	random.count++
+	return fmt.Sprintf("%s-%d", random.seed, random.count)
+}
+
+func (random *fixedRandom) Shuffle(seed string) []card {
+	if random.deck != nil {
+		return append([]card{}, random.deck...)
+	}
+	return shuffleDeck(seed)
+}
+
+// Uses a fake clock and fixed seeds at the table
+func makeDeterministic(state *GameState, seed string) *fakeClock {
+	clock := newFakeClock()
+	state.clock = clock
+	state.random = &fixedRandom{seed: seed}
+	return clock
+}
+
+// Creates a game with a player for each hand. Hands are the cards of each player in the order they are dealt, e.g. "AS2C"
+func newTestGame(variant *Variant, hands ...string) *GameState {
+	resetBankrolls()
+	state := createGameState(variant, limitStakes, nil, false)
+	makeDeterministic(state, "test")
+	for i := range hands {
+		state.addPlayer(testPlayers[i], false)
+		state.Players[i].lastPing = state.now()
+	}
+	state.clientPlayer = -1
+	stackDeck(state, hands...)
+	return state
+}
+
+// Stacks the deck so each player is dealt their hand, as long as nobody folds. The other cards follow in order
+func stackDeck(state *GameState, hands ...string) {
+	cards := [][]card{}
+	for _, hand := range hands {
+		handCards, err := stringToCards(hand)
+		if err != nil {
+			panic(err)
+		}
+		cards = append(cards, handCards)
+	}
+
+	deck := []card{}
+	for i := 0; len(deck) < len(strings.Join(hands, ""))/2; i++ {
+		for _, hand := range cards {
+			if i < len(hand) {
+				deck = append(deck, hand[i])
+			}
+		}
+	}
+	for _, card := range newDeck() {
+		if !slices.Contains(deck, card) {
+			deck = append(deck, card)
+		}
+	}
+	state.random.(*fixedRandom).deck = deck
+}
+
+// Moves the clock past the move timer
+func expireTimer(state *GameState) {
+	state.clock.(*fakeClock).now = state.moveExpires.Add(time.Second)
+}
+
+// Makes the moves, in turn, for the active players
+func play(t *testing.T, state *GameState, moves ...string) {
+	t.Helper()
+	for _, move := range moves {
+		player := state.Players[state.ActivePlayer].Name
+		if !state.performMove(move, true) {
+			t.Fatalf("%s could not %s in round %d, valid moves %v", player, move, state.Round, state.getValidMoves())
+		}
+	}
+}
+
+func hasMove(state *GameState, move string) bool {
+	return slices.ContainsFunc(state.getValidMoves(), func(m validMove) bool { return m.Move == move })
+}
+
+func TestBringIn(t *testing.T) {
+	// Up cards KD, 2D and 2C - the lowest card, by suit on a tie, brings in
+	state := newTestGame(variant5CardStud, "ASKD", "AH2D", "AD2C")
+	state.newRound()
+
+	if state.ActivePlayer != 2 || state.Pot != 3*ANTE || state.Players[0].Purse != STARTING_PURSE-ANTE {
+		t.Fatalf("active player %d, pot %d", state.ActivePlayer, state.Pot)
+	}
+
+	// The bring-in can't fold or check, only post or bet
+	if moves := state.getValidMoves(); moves[0].Move != "BB" || hasMove(state, "FO") || hasMove(state, "CH") || !hasMove(state, "BL") {
+		t.Errorf("bring-in moves %v", moves)
+	}
+
+	play(t, state, "BB")
+	if state.ActivePlayer != 0 || state.currentBet != BRINGIN || !hasMove(state, "FO") || !hasMove(state, "CA") || !hasMove(state, "BL") {
+		t.Errorf("after the bring-in: active player %d, bet %d, moves %v", state.ActivePlayer, state.currentBet, state.getValidMoves())
+	}
+}
+
+func TestBettingRounds(t *testing.T) {
+	state := newTestGame(variant5CardStud, "ASKDQS", "AH2D3H", "AD2C2H")
+	state.newRound()
+
+	// Cy brings in, Ann calls, Bob bets low and the others call
+	play(t, state, "BB", "CA", "BL", "CA", "CA")
+	if !state.isRoundComplete() {
+		t.Fatalf("round 1 is not complete")
+	}
+
+	state.runGameLogic()
+	if state.Round != 2 || state.Pot != 3*ANTE+3*LOW || state.currentBet != 0 {
+		t.Errorf("round %d, pot %d, bet %d", state.Round, state.Pot, state.currentBet)
+	}
+
+	// The pair of twos showing acts first, and a pair showing allows the high bet
+	if state.ActivePlayer != 2 || !hasMove(state, "CH") || !hasMove(state, "BH") {
+		t.Errorf("active player %d with moves %v", state.ActivePlayer, state.getValidMoves())
+	}
+
+	// Checked around, the round is complete
+	play(t, state, "CH", "CH")
+	if state.isRoundComplete() {
+		t.Errorf("round complete before Bob checked")
+	}
+	play(t, state, "CH")
+	if !state.isRoundComplete() {
+		t.Errorf("round not complete after everyone checked")
+	}
+}
+
+func TestRaiseCap(t *testing.T) {
+	state := newTestGame(variant5CardStud, "ASKD", "AH2D", "AD2C")
+	state.newRound()
+
+	play(t, state, "BB", "BL", "RA", "RA", "RA")
+	if hasMove(state, "RA") || !hasMove(state, "CA") {
+		t.Errorf("moves %v after %d raises", state.getValidMoves(), state.raiseCount)
+	}
+
+	// Bob's low bet plus three raises
+	if state.currentBet != 4*LOW {
+		t.Errorf("current bet %d, expected %d", state.currentBet, 4*LOW)
+	}
+}
+
+func TestFoldToWinner(t *testing.T) {
+	state := newTestGame(variant5CardStud, "ASKD", "AH2D", "AD2C")
+	state.newRound()
+
+	play(t, state, "BB", "BL", "FO", "FO")
+	state.runGameLogic()
+
+	// Ann wins the antes, the bring-in and their own bet back, and nobody sees Ann's down card
+	if !state.gameOver || !state.wonByFolds || state.LastResult != "Ann won by default" {
+		t.Fatalf("game over %t, result %q", state.gameOver, state.LastResult)
+	}
+	if purse := state.Players[0].Purse; purse != STARTING_PURSE+2*ANTE+BRINGIN {
+		t.Errorf("Ann has %d", purse)
+	}
+	if hand := state.createClientState().Players[0].Hand; hand != "??KD" {
+		t.Errorf("Ann's hand is shown as %q", hand)
+	}
+}
+
+func TestShowdown(t *testing.T) {
+	// Ann makes a pair of aces, Bob a pair of kings
+	state := newTestGame(variant5CardStud, "AS2C7DAD9H", "KS3CKD8H4S")
+	state.newRound()
+
+	for step := 0; step < 20 && !state.gameOver; step++ {
+		if state.isRoundComplete() {
+			state.runGameLogic()
+			continue
+		}
+		for _, move := range []string{"CH", "BB", "CA"} {
+			if hasMove(state, move) {
+				play(t, state, move)
+				break
+			}
+		}
+	}
+
+	if !state.gameOver || state.wonByFolds || !strings.HasPrefix(state.LastResult, "Ann won with Pair, Aces") {
+		t.Fatalf("game over %t, result %q", state.gameOver, state.LastResult)
+	}
+
+	// Everyone sees the hands shown down
+	if players := state.createClientState().Players; players[0].Hand != "AS2C7DAD9H" || players[1].Hand != "KS3CKD8H4S" {
+		t.Errorf("hands shown as %q and %q", players[0].Hand, players[1].Hand)
+	}
+}
+
+func TestClientStateHidesDownCards(t *testing.T) {
+	state := newTestGame(variant5CardStud, "ASKD", "AH2D", "AD2C")
+	state.newRound()
+	play(t, state, "BB", "FO")
+
+	// Bob sees their own down card, the others' up cards, and Ann's folded hand
+	state.clientPlayer = 1
+	state.authorized = true
+	client := state.createClientState()
+	if hands := []string{client.Players[0].Hand, client.Players[1].Hand, client.Players[2].Hand}; !slices.Equal(hands, []string{"AH2D", "??2C", "??"}) {
+		t.Errorf("Bob sees %v", hands)
+	}
+	if client.Players[0].Name != "Bob" || client.ActivePlayer != 0 || len(client.ValidMoves) == 0 {
+		t.Errorf("Bob is not first and active: %+v", client.Players[0])
+	}
+
+	// A viewer sees no down cards, and can't move
+	state.clientPlayer = -1
+	client = state.createClientState()
+	if hands := []string{client.Players[0].Hand, client.Players[1].Hand, client.Players[2].Hand}; client.Viewing != 1 || !slices.Equal(hands, []string{"??", "??2D", "??2C"}) || len(client.ValidMoves) != 0 {
+		t.Errorf("viewer sees %v with moves %v", hands, client.ValidMoves)
+	}
+}
+
+func TestPlayerDropsMidHand(t *testing.T) {
+	state := newTestGame(variant5CardStud, "ASKD", "AH2D", "AD2C")
+	state.newRound()
+	play(t, state, "BB")
+
+	// Ann stopped responding long ago, but stays until the end of the game
+	state.Players[0].lastPing = state.now().Add(2 * PLAYER_PING_TIMEOUT)
+	state.dropInactivePlayers(true, false)
+	if len(state.Players) != 3 {
+		t.Fatalf("%d players left mid-hand", len(state.Players))
+	}
+
+	// Out of time, Ann folds rather than calls
+	expireTimer(state)
+	state.runGameLogic()
+	if state.Players[0].Status != STATUS_FOLDED || state.ActivePlayer != 1 {
+		t.Errorf("Ann's status %d, active player %d", state.Players[0].Status, state.ActivePlayer)
+	}
+
+	// Bob leaves while it is their move, and is skipped
+	state.clientPlayer = 1
+	state.clientLeave()
+	state.clientPlayer = -1
+	expireTimer(state)
+	state.runGameLogic()
+	if state.Players[1].Status != STATUS_LEFT || !state.gameOver || state.LastResult != "Cy won by default" {
+		t.Errorf("Bob's status %d, game over %t, result %q", state.Players[1].Status, state.gameOver, state.LastResult)
+	}
+
+	// Both are gone when the next game starts, so Cy waits for players
+	expireTimer(state)
+	state.runGameLogic()
+	if len(state.Players) != 1 || state.Players[0].Name != "Cy" || state.LastResult != WAITING_MESSAGE {
+		t.Errorf("%d players, result %q", len(state.Players), state.LastResult)
+	}
+}
+
+// The same seeds and clock replay the same games, bot decisions included
+func TestReplayGames(t *testing.T) {
+	play := func() []string {
+		resetHistory()
+		resetBankrolls()
+		state := createGameState(defaultVariant, limitStakes, mixedBots(4), false)
+		state.table = "replay"
+		clock := makeDeterministic(state, "replay")
+		state.addPlayer("Ann", false)
+		state.clientPlayer = 4
+
+		for step := 0; step < 2000 && len(recentHistory("replay")) < 5; step++ {
+			clock.now = clock.now.Add(time.Second)
+			state.runGameLogic()
+		}
+
+		hands := []string{}
+		for _, hand := range recentHistory("replay") {
+			hands = append(hands, findHistory("replay", hand.Hand).Text)
+		}
+		return hands
+	}
+
+	first, second := play(), play()
+	if len(first) < 5 || !slices.Equal(first, second) {
+		t.Errorf("replayed %d hands differently from the %d played:\n%s\n%s", len(second), len(first), strings.Join(second, "\n"), strings.Join(first, "\n"))
+	}
+}
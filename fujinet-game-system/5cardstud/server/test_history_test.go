@@ -0,0 +1,156 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/goccy/go-json"
+)
+
+func resetHistory() {
+	historyMutex.Lock()
+	histories = map[string][]*HandHistory{}
+	historyLoaded = map[string]bool{}
+	historyMutex.Unlock()
+}
+
+// Plays games at a table of a human and bots until the given number of hands were recorded
+func playHands(t *testing.T, table string, hands int) *GameState {
+	t.Helper()
+
+	state := createGameState(defaultVariant, limitStakes, mixedBots(2, classicBot{}), false)
+	state.table = table
+	state.serverName = "History Room"
+	state.addPlayer("Ann", false)
+	state.clientPlayer = 2
+
+	for step := 0; step < 1000 && len(recentHistory(table)) < hands; step++ {
+		state.moveExpires = time.Now().Add(-time.Second)
+		state.runGameLogic()
+	}
+
+	if len(recentHistory(table)) < hands {
+		t.Fatalf("only %d hands were recorded", len(recentHistory(table)))
+	}
+	return state
+}
+
+func TestHandHistory(t *testing.T) {
+	resetHistory()
+	resetBankrolls()
+
+	state := playHands(t, "history", 1)
+	history := findHistory("history", 1)
+
+	lines := strings.Split(history.Text, "\n")
+	if !strings.HasPrefix(lines[0], "Hand #1: 5 Card Stud (5/10 Limit) - ") || !strings.HasPrefix(lines[1], "Table 'history' (History Room) Seat #") {
+		t.Errorf("unexpected header:\n%s", strings.Join(lines[0:2], "\n"))
+	}
+
+	// The deck order is kept, so the hand can be replayed
+	deck := ""
+	for _, line := range lines {
+		if strings.HasPrefix(line, "Deck: ") {
+			deck = line[6:]
+		}
+	}
+	if len(strings.Fields(deck)) != 52 {
+		t.Errorf("deck = %q, expected 52 cards", deck)
+	}
+
+	for _, expected := range []string{
+		"Seat 3: Ann (200 in chips)",
+		"Ann: posts the ante 1",
+		"*** ROUND 1 ***",
+		"*** SUMMARY ***",
+		"Result: " + history.Result,
+	} {
+		if !strings.Contains(history.Text, expected+"\n") {
+			t.Errorf("history is missing %q:\n%s", expected, history.Text)
+		}
+	}
+
+	// Ann never moves, so the server moves for her
+	if !strings.Contains(history.Text, "Ann: checks (timed out)") && !strings.Contains(history.Text, "Ann: folds (timed out)") {
+		t.Errorf("history is missing Ann's timed out move:\n%s", history.Text)
+	}
+
+	// Only finished hands are in the history
+	state.runGameLogic()
+	if findHistory("history", state.handNumber) != nil && state.history != nil {
+		t.Errorf("hand %d is in the history while in progress", state.handNumber)
+	}
+}
+
+func TestHistoryApi(t *testing.T) {
+	resetHistory()
+	resetBankrolls()
+	playHands(t, "historyapi", 2)
+
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("GET", url, nil)
+		apiHistory(c)
+		return w
+	}
+
+	hands := []HandHistory{}
+	json.Unmarshal(get("/history?table=HistoryApi").Body.Bytes(), &hands)
+	if len(hands) != 2 || hands[0].Hand != 2 || hands[1].Hand != 1 || hands[0].Text != "" {
+		t.Errorf("hands = %v, expected hands 2 and 1 without their text", hands)
+	}
+
+	if w := get("/history?table=historyapi&hand=1"); w.Code != http.StatusOK || !strings.HasPrefix(w.Body.String(), "Hand #1: ") {
+		t.Errorf("hand 1 = %d %q", w.Code, w.Body.String())
+	}
+
+	if w := get("/history?table=historyapi&hand=9"); w.Code != http.StatusNotFound {
+		t.Errorf("missing hand returned %d, expected %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestHistoryStore(t *testing.T) {
+	store, err := openBoltStore(filepath.Join(t.TempDir(), "tables.db"))
+	if err != nil {
+		t.Fatalf("openBoltStore() error = %v", err)
+	}
+
+	stateStore = store
+	defer func() {
+		flushStore()
+		stateStore = nil
+		store.Close()
+	}()
+
+	resetHistory()
+	resetBankrolls()
+	playHands(t, "historystore", 2)
+
+	// Hands are only queued until the flush
+	if saved, _ := store.LoadRecentHistory("historystore", HISTORY_SIZE); len(saved) != 0 {
+		t.Fatalf("%d hands written before the flush", len(saved))
+	}
+	flushStore()
+
+	// Simulate a restart
+	resetHistory()
+
+	if hands := recentHistory("historystore"); len(hands) != 2 || hands[0].Hand != 2 {
+		t.Errorf("reloaded hands = %v, expected 2", hands)
+	}
+
+	// Hands that are no longer in memory come from the store
+	historyMutex.Lock()
+	histories["historystore"] = histories["historystore"][1:]
+	historyMutex.Unlock()
+
+	if history := findHistory("historystore", 1); history == nil || !strings.HasPrefix(history.Text, "Hand #1: ") {
+		t.Errorf("hand 1 was not loaded from the store")
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+// A lobby that records the updates it is sent, answering with the given status codes in turn
+type fakeLobby struct {
+	mutex    sync.Mutex
+	statuses []int
+	updates  []GameServer
+}
+
+func (lobby *fakeLobby) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	var update GameServer
+	json.Unmarshal(body, &update)
+
+	lobby.mutex.Lock()
+	defer lobby.mutex.Unlock()
+	lobby.updates = append(lobby.updates, update)
+	status := http.StatusOK
+	if len(lobby.statuses) > 0 {
+		status, lobby.statuses = lobby.statuses[0], lobby.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func (lobby *fakeLobby) received() []GameServer {
+	lobby.mutex.Lock()
+	defer lobby.mutex.Unlock()
+	return append([]GameServer{}, lobby.updates...)
+}
+
+// Points the lobby client at the handler, returning a func that restores it
+func useLobby(t *testing.T, handler http.Handler) func() {
+	t.Helper()
+	server := httptest.NewServer(handler)
+
+	config := getConfig()
+	previous := config
+	config.Lobby.Endpoint = server.URL
+	setConfig(config)
+
+	UpdateLobby = true
+	lobbyRetryDelay = time.Millisecond
+	resetLobby()
+
+	return func() {
+		UpdateLobby = false
+		lobbyRetryDelay = time.Second
+		resetLobby()
+		setConfig(previous)
+		server.Close()
+	}
+}
+
+func resetLobby() {
+	lobbyMutex.Lock()
+	defer lobbyMutex.Unlock()
+	lobbyPending = map[string]lobbyUpdate{}
+	lobbySent = map[string]lobbyUpdate{}
+	lobbyOffline = false
+}
+
+func TestLobbyCoalesce(t *testing.T) {
+	lobby := &fakeLobby{}
+	defer useLobby(t, lobby)()
+
+	// Returns at once, and only the latest update of each table is sent
+	for players := 1; players <= 3; players++ {
+		sendStateToLobby(8, players, true, "Room A", "?table=a")
+	}
+	sendStateToLobby(8, 5, true, "Room B", "?table=b")
+	if len(lobby.received()) != 0 {
+		t.Fatalf("sendStateToLobby() did not wait for the worker")
+	}
+
+	sendLobbyUpdates()
+	updates := lobby.received()
+	if len(updates) != 2 {
+		t.Fatalf("%d updates sent, want 2: %+v", len(updates), updates)
+	}
+	for _, update := range updates {
+		if update.Server == "Room A" && update.Curplayers != 3 {
+			t.Errorf("Room A sent with %d players, want 3", update.Curplayers)
+		}
+		if update.Status != "online" {
+			t.Errorf("%s sent as %s", update.Server, update.Status)
+		}
+	}
+}
+
+func TestLobbyRetry(t *testing.T) {
+	lobby := &fakeLobby{statuses: []int{http.StatusServiceUnavailable, http.StatusBadGateway}}
+	defer useLobby(t, lobby)()
+
+	sendStateToLobby(8, 1, true, "Room A", "?table=a")
+	sendLobbyUpdates()
+	if updates := lobby.received(); len(updates) != 3 {
+		t.Fatalf("%d attempts, want 3", len(updates))
+	}
+
+	// A rejected update is not tried again
+	lobby.statuses = []int{http.StatusBadRequest}
+	sendStateToLobby(8, 2, true, "Room A", "?table=a")
+	sendLobbyUpdates()
+	if updates := lobby.received(); len(updates) != 4 {
+		t.Fatalf("%d attempts, want 4", len(updates))
+	}
+
+	// Gives up after LOBBY_ATTEMPTS
+	lobby.statuses = make([]int, LOBBY_ATTEMPTS)
+	for i := range lobby.statuses {
+		lobby.statuses[i] = http.StatusInternalServerError
+	}
+	sendStateToLobby(8, 3, true, "Room A", "?table=a")
+	sendLobbyUpdates()
+	if updates := lobby.received(); len(updates) != 4+LOBBY_ATTEMPTS {
+		t.Fatalf("%d attempts, want %d", len(updates), 4+LOBBY_ATTEMPTS)
+	}
+
+	// The heartbeat repeats the last update the lobby took
+	queueLobbyHeartbeat()
+	sendLobbyUpdates()
+	updates := lobby.received()
+	if last := updates[len(updates)-1]; last.Curplayers != 1 {
+		t.Errorf("heartbeat sent %d players, want 1", last.Curplayers)
+	}
+}
+
+func TestLobbyTimeout(t *testing.T) {
+	release := make(chan struct{})
+	var attempts atomic.Int32
+	defer useLobby(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		<-release
+	}))()
+	defer close(release)
+
+	client := lobbyClient
+	lobbyClient = &http.Client{Timeout: 10 * time.Millisecond}
+	defer func() { lobbyClient = client }()
+
+	sendStateToLobby(8, 1, true, "Room A", "?table=a")
+	start := time.Now()
+	sendLobbyUpdates()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("sendLobbyUpdates() took %s", elapsed)
+	}
+	if attempts.Load() != LOBBY_ATTEMPTS {
+		t.Errorf("%d attempts, want %d", attempts.Load(), LOBBY_ATTEMPTS)
+	}
+	if len(lobbySent) != 0 {
+		t.Errorf("update that timed out kept for the heartbeat")
+	}
+}
+
+func TestLobbyHeartbeat(t *testing.T) {
+	lobby := &fakeLobby{}
+	defer useLobby(t, lobby)()
+
+	sendStateToLobby(8, 1, true, "Room A", "?table=a")
+	sendStateToLobby(8, 2, true, "Room B", "?table=b")
+	sendLobbyUpdates()
+
+	queueLobbyHeartbeat()
+	sendLobbyUpdates()
+	if updates := lobby.received(); len(updates) != 4 {
+		t.Fatalf("%d updates after the heartbeat, want 4", len(updates))
+	}
+
+	// A table that went offline is left out
+	sendStateToLobby(0, 0, false, "Room B", "?table=b")
+	sendLobbyUpdates()
+	queueLobbyHeartbeat()
+	sendLobbyUpdates()
+	updates := lobby.received()
+	if len(updates) != 6 {
+		t.Fatalf("%d updates after the heartbeat, want 6", len(updates))
+	}
+	if last := updates[5]; last.Server != "Room A" {
+		t.Errorf("heartbeat sent %s", last.Server)
+	}
+}
+
+func TestLobbyOffline(t *testing.T) {
+	lobby := &fakeLobby{}
+	defer useLobby(t, lobby)()
+
+	resetBankrolls()
+	createTable("Offline Room", "offline", defaultVariant, limitStakes, mixedBots(3), true)
+	defer deleteTable("offline")
+
+	takeTablesOffline()
+
+	serverURL := getConfig().Lobby.ServerURL + "?table=offline"
+	found := false
+	for _, update := range lobby.received() {
+		if update.Serverurl == serverURL {
+			found = true
+			if update.Status != "offline" {
+				t.Errorf("table sent as %s on shutdown", update.Status)
+			}
+		}
+	}
+	if !found {
+		t.Fatalf("table not sent on shutdown")
+	}
+
+	// Tables stay offline
+	count := len(lobby.received())
+	sendStateToLobby(8, 1, true, "Offline Room", "?table=offline")
+	sendLobbyUpdates()
+	if len(lobby.received()) != count {
+		t.Errorf("online update sent after shutdown")
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Creates a table in the middle of a game, with cards dealt and bets made
+func createGameInProgress() *GameState {
+	state := createGameState(defaultVariant, limitStakes, mixedBots(3), true)
+	state.table = "persist"
+	state.serverName = "Persist Room"
+	state.addPlayer("Human", false)
+	state.clientPlayer = 3
+	state.playerPing()
+	state.newRound()
+	state.newRound()
+	state.currentBet = LOW
+	state.raiseCount = 1
+	state.raiseAmount = LOW
+	state.Players[0].Bet = LOW
+	state.Players[0].voluntary = true
+	return state
+}
+
+func TestStateRoundTrip(t *testing.T) {
+	state := createGameInProgress()
+
+	data, err := marshalState(state)
+	if err != nil {
+		t.Fatalf("marshalState() error = %v", err)
+	}
+
+	loaded, err := unmarshalState(data)
+	if err != nil {
+		t.Fatalf("unmarshalState() error = %v", err)
+	}
+
+	if len(loaded.deck) != 52 || loaded.deckIndex != state.deckIndex {
+		t.Errorf("deck not restored: %d cards, index %d, expected 52, %d", len(loaded.deck), loaded.deckIndex, state.deckIndex)
+	}
+
+	if !loaded.moveExpires.Equal(state.moveExpires) {
+		t.Errorf("moveExpires = %v, expected %v", loaded.moveExpires, state.moveExpires)
+	}
+
+	for i := range state.Players {
+		if !loaded.Players[i].lastPing.Equal(state.Players[i].lastPing) {
+			t.Errorf("player %d lastPing = %v, expected %v", i, loaded.Players[i].lastPing, state.Players[i].lastPing)
+		}
+		if len(loaded.Players[i].cards) != 3 {
+			t.Errorf("player %d has %d cards, expected 3", i, len(loaded.Players[i].cards))
+		}
+		if loaded.Players[i].voluntary != state.Players[i].voluntary {
+			t.Errorf("player %d voluntary = %t, expected %t", i, loaded.Players[i].voluntary, state.Players[i].voluntary)
+		}
+	}
+
+	// Times lose their monotonic clock reading and location in the round trip, so compare the rest
+	// without them. The client player is per request and never restored.
+	normalize := func(s *GameState) {
+		s.moveExpires = time.Time{}
+		s.lastActive = time.Time{}
+		s.clientPlayer = -1
+		for i := range s.Players {
+			s.Players[i].lastPing = time.Time{}
+		}
+	}
+	normalize(state)
+	normalize(loaded)
+
+	if !reflect.DeepEqual(state, loaded) {
+		t.Errorf("restored state differs\n got: %+v\nwant: %+v", loaded, state)
+	}
+}
+
+func TestStateStoreReload(t *testing.T) {
+	store, err := openBoltStore(filepath.Join(t.TempDir(), "tables.db"))
+	if err != nil {
+		t.Fatalf("openBoltStore() error = %v", err)
+	}
+
+	stateStore = store
+	defer func() {
+		flushStore()
+		stateStore = nil
+		store.Close()
+	}()
+
+	state := createGameInProgress()
+	saveState(state)
+	flushStore()
+
+	// Simulate a restart
+	stateMap.Delete(state.table)
+	loadStates()
+
+	value, ok := stateMap.Load(state.table)
+	if !ok {
+		t.Fatalf("table %s was not reloaded", state.table)
+	}
+
+	loaded := value.(*GameState)
+	if loaded.Pot != state.Pot || loaded.Round != state.Round || len(loaded.Players) != len(state.Players) {
+		t.Errorf("reloaded pot %d round %d players %d, expected %d %d %d",
+			loaded.Pot, loaded.Round, len(loaded.Players), state.Pot, state.Round, len(state.Players))
+	}
+
+	// The restored table keeps playing
+	createTable("Persist Room", state.table, defaultVariant, limitStakes, mixedBots(3), true)
+	value, _ = stateMap.Load(state.table)
+	if value.(*GameState).Players[3].Name != "Human" {
+		t.Errorf("createTable() replaced the restored table")
+	}
+
+	stateMap.Delete(state.table)
+}
+
+func TestStatePersistBatched(t *testing.T) {
+	store, err := openBoltStore(filepath.Join(t.TempDir(), "tables.db"))
+	if err != nil {
+		t.Fatalf("openBoltStore() error = %v", err)
+	}
+
+	stateStore = store
+	defer func() {
+		flushStore()
+		stateStore = nil
+		store.Close()
+	}()
+
+	// Saving only queues the table and bankroll, and the latest snapshot is the one written
+	resetBankrolls()
+	resetStats()
+	defer resetStats()
+	state := createGameInProgress()
+	saveState(state)
+	state.Pot = 123
+	saveState(state)
+	recordPurse(&state.Players[3])
+	updateStats(&state.Players[3], func(s *PlayerStats) { s.Hands++ })
+	defer stateMap.Delete(state.table)
+
+	if saved, _ := store.LoadAll(); len(saved) != 0 {
+		t.Fatalf("saveState() wrote %d tables, expected them queued", len(saved))
+	}
+	if saved, _ := store.LoadBankrolls(); len(saved) != 0 {
+		t.Fatalf("recordPurse() wrote %d bankrolls, expected them queued", len(saved))
+	}
+	if saved, _ := store.LoadStats(); len(saved) != 0 {
+		t.Fatalf("updateStats() wrote %d stats, expected them queued", len(saved))
+	}
+
+	flushStore()
+	saved, _ := store.LoadAll()
+	loaded, err := unmarshalState(saved[state.table])
+	if err != nil || loaded.Pot != 123 {
+		t.Fatalf("flushStore() wrote %v, %v, expected pot 123", loaded, err)
+	}
+	if bankrolls, _ := store.LoadBankrolls(); bankrolls["human"] == nil {
+		t.Errorf("flushStore() did not write the bankroll")
+	}
+	if stats, _ := store.LoadStats(); stats["human"] == nil {
+		t.Errorf("flushStore() did not write the stats")
+	}
+
+	// A deleted table is not written back
+	saveState(state)
+	deleteState(state.table)
+	flushStore()
+	if saved, _ := store.LoadAll(); len(saved) != 0 {
+		t.Errorf("deleted table written back: %d tables", len(saved))
+	}
+}
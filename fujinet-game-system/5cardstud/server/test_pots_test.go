@@ -0,0 +1,190 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// Creates a 5 Card Stud game at the showdown, with the given hands and chips put in by each player
+func createShowdown(hands []string, totalBets []int) *GameState {
+	resetBankrolls()
+
+	state := createGameState(defaultVariant, limitStakes, mixedBots(0), false)
+	for i, hand := range hands {
+		state.addPlayer(string(rune('A'+i))+"nn", false)
+		player := &state.Players[i]
+		player.Status = STATUS_PLAYING
+		player.Purse = 0
+		player.totalBet = totalBets[i]
+		player.cards, _ = stringToCards(hand)
+		state.Pot += totalBets[i]
+	}
+	state.Round = defaultVariant.Rounds
+	return state
+}
+
+func TestSidePots(t *testing.T) {
+	// Ann is all-in for 50 with the best hand, Bnn and Cnn put in 150 each
+	state := createShowdown([]string{"ASAHADKSKH", "QSQHQD2C3D", "2S4H6D8C9C"}, []int{50, 150, 150})
+
+	pots := state.buildPots()
+	if len(pots) != 2 || pots[0].Amount != 150 || len(pots[0].Players) != 3 || pots[1].Amount != 200 || len(pots[1].Players) != 2 {
+		t.Fatalf("pots = %v, expected a main pot of 150 and a side pot of 200", pots)
+	}
+
+	state.endGame(false)
+
+	for i, expected := range []int{150, 200, 0} {
+		if state.Players[i].Purse != expected {
+			t.Errorf("%s purse = %d, expected %d", state.Players[i].Name, state.Players[i].Purse, expected)
+		}
+	}
+
+	if !strings.HasPrefix(state.LastResult, "Ann won with Full House") || !strings.HasSuffix(state.LastResult, ", Bnn won a side pot") {
+		t.Errorf("result = %q", state.LastResult)
+	}
+
+	// A folded player's chips stay in the pots they reached. Cnn folded after putting in 100, more than the all-in Ann
+	state = createShowdown([]string{"ASAHADKSKH", "QSQHQD2C3D", "2S4H6D8C9C"}, []int{50, 150, 100})
+	state.Players[2].Status = STATUS_FOLDED
+	state.endGame(false)
+
+	if state.Players[0].Purse != 150 || state.Players[1].Purse != 150 {
+		t.Errorf("purses = %d and %d, expected 150 and 150", state.Players[0].Purse, state.Players[1].Purse)
+	}
+
+	// The uncalled part of a bet goes back to the player, and is not a win
+	state = createShowdown([]string{"2S4H6D8C9C", "ASAHADKSKH"}, []int{50, 120})
+	state.endGame(false)
+
+	if state.Players[1].Purse != 170 || state.LastResult != "Bnn won with Full House, Aces full of Kings" {
+		t.Errorf("purse = %d, result = %q", state.Players[1].Purse, state.LastResult)
+	}
+}
+
+func TestSplitPotOddChips(t *testing.T) {
+	// Ann and Cnn tie, Bnn folded
+	state := createShowdown([]string{"ASKSQSJD9H", "2S4H6D8C9C", "AHKHQHJC9D"}, []int{5, 1, 5})
+	state.Players[1].Status = STATUS_FOLDED
+
+	// The odd chip goes to the first winner left of the dealer
+	state.dealer = 0
+	state.endGame(false)
+
+	if state.Players[0].Purse != 5 || state.Players[2].Purse != 6 {
+		t.Errorf("purses = %d and %d, expected 5 and 6 with the dealer at seat 0", state.Players[0].Purse, state.Players[2].Purse)
+	}
+	if state.LastResult != "Ann and Cnn won with Ace-high, kicker King" {
+		t.Errorf("result = %q", state.LastResult)
+	}
+
+	state = createShowdown([]string{"ASKSQSJD9H", "2S4H6D8C9C", "AHKHQHJC9D"}, []int{5, 1, 5})
+	state.Players[1].Status = STATUS_FOLDED
+	state.dealer = 2
+	state.endGame(false)
+
+	if state.Players[0].Purse != 6 || state.Players[2].Purse != 5 {
+		t.Errorf("purses = %d and %d, expected 6 and 5 with the dealer at seat 2", state.Players[0].Purse, state.Players[2].Purse)
+	}
+}
+
+func TestAllInMove(t *testing.T) {
+	state := createGameState(defaultVariant, limitStakes, mixedBots(3), false)
+	state.clientPlayer = 0
+	state.newRound()
+	state.newRound()
+
+	// The first player bets 5, the next one only has 3 left
+	bettor := state.ActivePlayer
+	short := (bettor + 1) % 3
+	state.Players[short].Purse = 3
+
+	if !state.performMove("BL", true) {
+		t.Fatalf("bet failed, moves %v", state.getValidMoves())
+	}
+
+	moves := state.getValidMoves()
+	if slices.ContainsFunc(moves, func(m validMove) bool { return m.Move == "CA" }) ||
+		!slices.ContainsFunc(moves, func(m validMove) bool { return m.Move == "AI" && m.Name == "All-in 3" }) {
+		t.Fatalf("short stack moves = %v, expected All-in 3 instead of a call", moves)
+	}
+
+	if !state.performMove("AI", true) {
+		t.Fatalf("all-in failed")
+	}
+	if player := state.Players[short]; player.Purse != 0 || player.Bet != 3 || player.Move != "ALL-IN" || state.currentBet != LOW {
+		t.Errorf("after all-in: purse %d, bet %d, move %q, current bet %d", player.Purse, player.Bet, player.Move, state.currentBet)
+	}
+
+	// The all-in player is skipped from now on
+	state.performMove("CA", true)
+	if !state.isRoundComplete() {
+		t.Errorf("round not complete after the last call")
+	}
+
+	state.newRound()
+	for i := 0; i < 2; i++ {
+		if state.ActivePlayer == short {
+			t.Fatalf("all-in player is active")
+		}
+		state.performMove("CH", true)
+	}
+	if !state.isRoundComplete() {
+		t.Errorf("round not complete after both other players checked")
+	}
+}
+
+// Bots with short stacks go all-in all the time. No chips may be created or lost in a game.
+func TestAllInChipsAddUp(t *testing.T) {
+	for _, betting := range []BettingStructure{limitStakes, noLimitStakes} {
+		for _, variant := range []*Variant{variant5CardStud, variantHoldem} {
+			t.Run(variant.Code+"-"+string(betting.Type), func(t *testing.T) {
+				betting.StartingPurse = 30
+				state := createGameState(variant, betting, mixedBots(6, classicBot{}, looseAggressiveBot{}), false)
+				state.clientPlayer = 0
+
+				expected := -1
+				allIns := 0
+				for step := 0; step < 20000; step++ {
+					state.moveExpires = time.Now().Add(-time.Second)
+					state.runGameLogic()
+
+					chips := 0
+					for _, player := range state.Players {
+						if player.Purse < 0 {
+							t.Fatalf("%s has a negative purse", player.Name)
+						}
+						if player.Move == "ALL-IN" {
+							allIns++
+						}
+						chips += player.Purse
+						if !state.gameOver {
+							chips += player.Bet
+						}
+					}
+
+					if state.gameOver {
+						if expected >= 0 && chips != expected {
+							t.Fatalf("%d chips after the game, expected %d (%s)", chips, expected, state.LastResult)
+						}
+						expected = -1
+					} else if state.Round > 0 {
+						chips += state.Pot
+						if expected < 0 {
+							expected = chips
+						} else if chips != expected {
+							t.Fatalf("%d chips in round %d, expected %d", chips, state.Round, expected)
+						}
+					}
+				}
+
+				if allIns == 0 {
+					t.Errorf("nobody went all-in")
+				}
+			})
+		}
+	}
+}
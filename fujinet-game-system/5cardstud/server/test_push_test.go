@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func createPushTable(table string, bots int) *gin.Engine {
+	state := createGameState(defaultVariant, limitStakes, mixedBots(bots, classicBot{}), false)
+	state.table = table
+	stateMap.Store(table, state)
+
+	router := gin.New()
+	router.GET("/state", apiState)
+	router.GET("/events", apiEvents)
+	return router
+}
+
+func loadTable(table string) *GameState {
+	value, _ := stateMap.Load(table)
+	return value.(*GameState)
+}
+
+func TestTableTicker(t *testing.T) {
+	resetBankrolls()
+
+	// Bots alone don't play
+	createPushTable("tickbots", 3)
+	for i := 0; i < 3; i++ {
+		tickTable("tickbots")
+	}
+	if state := loadTable("tickbots"); state.Round != 0 {
+		t.Errorf("bots only table is in round %d, expected 0", state.Round)
+	}
+
+	// With a human seated, the game goes on without polling
+	router := createPushTable("tick", 3)
+	request(router, "/state?table=tick&player=Ann")
+
+	hands := 0
+	for i := 0; i < 200 && hands < 1; i++ {
+		loadTable("tick").moveExpires = time.Now().Add(-time.Second)
+		wasOver := loadTable("tick").gameOver
+		tickTable("tick")
+		if loadTable("tick").gameOver && !wasOver {
+			hands++
+		}
+	}
+	if hands < 1 {
+		t.Errorf("the ticker did not finish a game")
+	}
+}
+
+func TestTableProgress(t *testing.T) {
+	state := createGameState(defaultVariant, limitStakes, mixedBots(3, classicBot{}), false)
+	state.newRound()
+	before := state.progress()
+
+	if !state.progress().equal(before) {
+		t.Errorf("unchanged table made progress")
+	}
+
+	// Internal state is saved by the ticker too
+	for name, change := range map[string]func(state *GameState){
+		"move deadline": func(state *GameState) { state.moveExpires = state.moveExpires.Add(time.Second) },
+		"deck":          func(state *GameState) { state.deckIndex++ },
+		"player":        func(state *GameState) { state.Players[1].Move = "FOLD" },
+	} {
+		changed := *state
+		changed.Players = append([]Player{}, state.Players...)
+		change(&changed)
+		if changed.progress().equal(before) {
+			t.Errorf("a change of the %s is not progress", name)
+		}
+	}
+}
+
+func TestLongPoll(t *testing.T) {
+	resetBankrolls()
+	router := createPushTable("longpoll", 0)
+
+	// Waiting for more players, so the state doesn't change by itself
+	if _, state := request(router, "/state?table=longpoll&player=Ann"); state.Round != 0 {
+		t.Fatalf("round %d, expected to wait for players", state.Round)
+	}
+	state := *loadTable("longpoll")
+	state.setClientPlayerByName(clientRequest{player: "Ann"})
+	hash := state.createClientState().Hash
+
+	// Nothing changes - the wait times out and returns the unchanged response
+	start := time.Now()
+	if w, _ := request(router, "/state?table=longpoll&player=Ann&wait=1&hash="+hash); w.Body.String() != `"1"` || time.Since(start) < time.Second {
+		t.Errorf("unchanged long-poll returned %q after %s", w.Body.String(), time.Since(start))
+	}
+
+	// Another player joins while waiting
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		request(router, "/state?table=longpoll&player=Bob")
+	}()
+
+	start = time.Now()
+	_, changed := request(router, "/state?table=longpoll&player=Ann&wait=10&hash="+hash)
+	if len(changed.Players) != 2 || time.Since(start) > 5*time.Second {
+		t.Errorf("long-poll returned %d players after %s, expected Bob to wake it up", len(changed.Players), time.Since(start))
+	}
+}
+
+func TestEvents(t *testing.T) {
+	resetBankrolls()
+	router := createPushTable("events", 0)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	response, err := http.Get(server.URL + "/events?table=events&player=Ann")
+	if err != nil {
+		t.Fatalf("GET /events error = %v", err)
+	}
+	defer response.Body.Close()
+
+	if contentType := response.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Errorf("content type %q", contentType)
+	}
+
+	reader := bufio.NewReader(response.Body)
+	nextEvent := func() string {
+		event := ""
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil || line == "\n" {
+				return event
+			}
+			event += line
+		}
+	}
+
+	// Connecting joins the table, and the stream keeps the token
+	if event := nextEvent(); !strings.HasPrefix(event, "event: state\ndata: {") || !strings.Contains(event, `"n":"Ann"`) {
+		t.Errorf("first event = %q", event)
+	}
+
+	request(router, "/state?table=events&player=Bob")
+	if event := nextEvent(); !strings.Contains(event, `"n":"Bob"`) {
+		t.Errorf("event after Bob joined = %q", event)
+	}
+
+	if unknown, _ := http.Get(server.URL + "/events?table=nothere"); unknown.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown table returned %d", unknown.StatusCode)
+	}
+
+	// Without a table, like /state, it is the default table
+	createPushTable("default", 0)
+	defer stateMap.Delete("default")
+	if response, err := http.Get(server.URL + "/events?player=Cy"); err != nil || response.StatusCode != http.StatusOK {
+		t.Errorf("events of the default table returned %v %v", response, err)
+	} else {
+		response.Body.Close()
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A full table - 7 bots and a seat for Ann
+func createSpectatorTable(table string) *gin.Engine {
+	state := createGameState(defaultVariant, limitStakes, mixedBots(7, classicBot{}), false)
+	state.table = table
+	stateMap.Store(table, state)
+	addTable(GameTable{Table: table, Name: table})
+
+	router := gin.New()
+	router.GET("/state", apiState)
+	router.GET("/leave", apiLeave)
+	router.GET("/chat", apiChat)
+	router.GET("/tables", apiTables)
+	return router
+}
+
+func TestSpectatorQueue(t *testing.T) {
+	resetBankrolls()
+	router := createSpectatorTable("spectate")
+
+	_, ann := request(router, "/state?table=spectate&player=Ann")
+	if ann.Viewing != 0 {
+		t.Fatalf("Ann did not sit down")
+	}
+
+	// Bob waits for a seat, Cy only watches
+	_, bob := request(router, "/state?table=spectate&player=Bob")
+	if bob.Viewing != 1 || bob.Queue != 1 || len(bob.Token) != 8 {
+		t.Errorf("Bob is viewing %d, queued %d with token %q", bob.Viewing, bob.Queue, bob.Token)
+	}
+	_, cy := request(router, "/state?table=spectate&player=Cy&spectate=1")
+	if cy.Viewing != 1 || cy.Queue != 0 || cy.Spectators != 2 {
+		t.Errorf("Cy is viewing %d, queued %d with %d spectators", cy.Viewing, cy.Queue, cy.Spectators)
+	}
+
+	// Spectators never see the down cards
+	if cy.Round > 0 && cy.Round < 5 {
+		for _, player := range cy.Players {
+			if player.Hand != "" && !strings.HasPrefix(player.Hand, "??") {
+				t.Errorf("spectator sees the hand %q of %s", player.Hand, player.Name)
+			}
+		}
+	}
+
+	if tables := listedTables(router, "dev=1"); !isListed(tables, "spectate") || tables[0].Spectators != 2 {
+		t.Errorf("tables = %+v, expected 2 spectators", tables)
+	}
+
+	// Once Ann is gone, Bob takes the seat with the same token
+	request(router, "/leave?table=spectate&player=Ann&k="+ann.Token)
+	unlock := tableMutex.Lock("spectate")
+	loadTable("spectate").dropInactivePlayers(false, false)
+	unlock()
+
+	if _, bob = request(router, "/state?table=spectate&player=Bob&k="+bob.Token); bob.Viewing != 0 || bob.Queue != 0 || bob.Spectators != 1 {
+		t.Errorf("Bob is viewing %d, queued %d with %d spectators", bob.Viewing, bob.Queue, bob.Spectators)
+	}
+
+	if w, _ := request(router, "/leave?table=spectate&player=Cy&k="+cy.Token); w.Code != http.StatusOK {
+		t.Errorf("Cy could not leave, %d", w.Code)
+	}
+	if w, state := request(router, "/state?table=spectate"); state.Spectators != 0 || strings.Contains(w.Body.String(), `"o":`) || strings.Contains(w.Body.String(), `"q":`) {
+		t.Errorf("%d spectators after Cy left, in %s", state.Spectators, w.Body.String())
+	}
+}
+
+func TestSpectatorLimit(t *testing.T) {
+	resetBankrolls()
+	router := createSpectatorTable("spectatefull")
+
+	for i := 0; i < MAX_SPECTATORS; i++ {
+		request(router, fmt.Sprintf("/state?table=spectatefull&player=Fan%d&spectate=1", i))
+	}
+
+	// Without a spectator seat, only viewing
+	if _, state := request(router, "/state?table=spectatefull&player=Late&spectate=1"); state.Viewing != 1 || state.Token != "" || state.Spectators != MAX_SPECTATORS {
+		t.Errorf("late spectator has token %q with %d spectators", state.Token, state.Spectators)
+	}
+}
+
+func TestChat(t *testing.T) {
+	resetBankrolls()
+	router := createSpectatorTable("chat")
+
+	_, ann := request(router, "/state?table=chat&player=Ann")
+	_, cy := request(router, "/state?table=chat&player=Cy&spectate=1")
+
+	for _, url := range []string{"/chat?table=chat&player=Ann&msg=hi", "/chat?table=chat&player=Cy&k=AAAAAAAA&msg=hi", "/chat?table=chat&msg=hi"} {
+		if w, _ := request(router, url); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s returned %d, expected %d", url, w.Code, http.StatusUnauthorized)
+		}
+	}
+
+	if _, state := request(router, "/chat?table=chat&player=Cy&msg=gl+all&k="+cy.Token); len(state.Chat) != 1 || state.Chat[0] != (chatMessage{Name: "Cy", Text: "gl all"}) {
+		t.Errorf("chat = %+v", state.Chat)
+	}
+
+	long := strings.Repeat("a", CHAT_MESSAGE_LENGTH+10)
+	for i := 0; i < CHAT_SIZE; i++ {
+		request(router, "/chat?table=chat&player=Ann&msg="+long+"&k="+ann.Token)
+	}
+
+	_, state := request(router, "/state?table=chat")
+	if len(state.Chat) != CHAT_SIZE || state.Chat[0].Name != "Ann" || len(state.Chat[0].Text) != CHAT_MESSAGE_LENGTH {
+		t.Errorf("chat = %+v", state.Chat)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func resetStats() {
+	statsMutex.Lock()
+	playerStats = map[string]*PlayerStats{}
+	statsMutex.Unlock()
+}
+
+func TestStatsFoldToWinner(t *testing.T) {
+	resetStats()
+	state := newTestGame(variant5CardStud, "ASKD", "AH2D", "AD2C")
+	state.newRound()
+
+	// Cy's bring-in is forced, Ann bets by choice and wins when the others fold
+	play(t, state, "BB", "BL", "FO", "FO")
+	state.runGameLogic()
+
+	expected := map[string]PlayerStats{
+		"ann": {Name: "Ann", Hands: 1, Voluntary: 1, BiggestPot: 3*ANTE + BRINGIN + LOW},
+		"bob": {Name: "Bob", Hands: 1, Folds: 1},
+		"cy":  {Name: "Cy", Hands: 1, Folds: 1},
+	}
+	for key, stats := range expected {
+		if playerStats[key] == nil || *playerStats[key] != stats {
+			t.Errorf("stats of %s = %+v, expected %+v", key, playerStats[key], stats)
+		}
+	}
+}
+
+func TestStatsShowdown(t *testing.T) {
+	resetStats()
+
+	// Ann brings in and wins with a pair of aces, then calls Bob and loses to a pair of kings
+	for _, hands := range [][]string{{"AS2C7DAD9H", "KS3CKD8H4S"}, {"2S3D7D8D9H", "KS3CKD8H4S"}} {
+		state := newTestGame(variant5CardStud, hands...)
+		state.newRound()
+		for step := 0; step < 20 && !state.gameOver; step++ {
+			if state.isRoundComplete() {
+				state.runGameLogic()
+				continue
+			}
+			for _, move := range []string{"CH", "BB", "CA"} {
+				if hasMove(state, move) {
+					play(t, state, move)
+					break
+				}
+			}
+		}
+	}
+
+	stats, ok := getStats("ANN")
+	if !ok {
+		t.Fatalf("no stats for Ann")
+	}
+	expected := StatsSummary{Name: "Ann", Hands: 2, VPIP: 50, FoldRate: 0, Showdowns: 2, ShowdownWin: 50, BiggestPot: 2*ANTE + 2*BRINGIN}
+	if stats != expected {
+		t.Errorf("stats = %+v, expected %+v", stats, expected)
+	}
+
+	if _, ok := getStats("Dee"); ok {
+		t.Errorf("stats for a player that never played")
+	}
+}
+
+func TestStatsApi(t *testing.T) {
+	resetStats()
+	router := gin.New()
+	router.GET("/stats", apiStats)
+	updateStats(&Player{Name: "Ann"}, func(s *PlayerStats) {
+		*s = PlayerStats{Name: "Ann", Hands: 3, Voluntary: 2, Folds: 1, Showdowns: 2, ShowdownsWon: 1, BiggestPot: 42}
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/stats?player=ann", nil))
+	if expected := `{"n":"Ann","h":3,"v":67,"f":33,"s":2,"w":50,"b":42}`; w.Code != 200 || w.Body.String() != expected {
+		t.Errorf("/stats returned %d %s, expected %s", w.Code, w.Body.String(), expected)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/stats?player=Bob", nil))
+	if w.Code != 404 {
+		t.Errorf("/stats for an unknown player returned %d", w.Code)
+	}
+
+	stats, _ := getStats("Ann")
+	data := serializeBinary(stats, "")
+	checkGolden(t, "stats.bin", data)
+	if data[0] != BIN_VERSION || len(data) != 1+9+2+1+1+2+1+2 {
+		t.Errorf("stats are %d bytes with version %d", len(data), data[0])
+	}
+}
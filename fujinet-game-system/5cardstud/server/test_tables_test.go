@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/goccy/go-json"
+	"golang.org/x/exp/slices"
+)
+
+func createTablesRouter() *gin.Engine {
+	router := gin.New()
+	router.POST("/create", apiCreateTable)
+	router.GET("/tables", apiTables)
+	router.GET("/state", apiState)
+	return router
+}
+
+var createClients = 0
+
+// Posts /create from a new client address, so tests don't run into the limit of tables per client
+func createPost(router *gin.Engine, query string) *httptest.ResponseRecorder {
+	createClients++
+	return createPostFrom(router, query, fmt.Sprintf("198.51.100.%d:1234", createClients))
+}
+
+func createPostFrom(router *gin.Engine, query string, addr string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/create?"+query, nil)
+	r.RemoteAddr = addr
+	router.ServeHTTP(w, r)
+	return w
+}
+
+func createTableRequest(t *testing.T, router *gin.Engine, query string) GameTable {
+	t.Helper()
+	w := createPost(router, query)
+	if w.Code != http.StatusOK {
+		t.Fatalf("/create?%s returned %d %q", query, w.Code, w.Body.String())
+	}
+
+	table := GameTable{}
+	json.Unmarshal(w.Body.Bytes(), &table)
+	return table
+}
+
+func listedTables(router *gin.Engine, query string) []GameTable {
+	w, _ := request(router, "/tables?"+query)
+	tables := []GameTable{}
+	json.Unmarshal(w.Body.Bytes(), &tables)
+	return tables
+}
+
+func isListed(tables []GameTable, id string) bool {
+	return slices.ContainsFunc(tables, func(table GameTable) bool { return table.Table == id })
+}
+
+func TestCreateTable(t *testing.T) {
+	resetBankrolls()
+	router := createTablesRouter()
+
+	table := createTableRequest(t, router, "name=Friday+Night&bots=2&variant=holdem&stakes=nolimit")
+	if len(table.Table) != 8 || table.Name != "Friday Night" || table.Stakes != "2/5 No Limit" || table.MaxPlayers != variantHoldem.MaxPlayers-2 || table.Password {
+		t.Errorf("created table = %+v", table)
+	}
+
+	// Listed with the other tables of its variant, not the dev tables
+	if !isListed(listedTables(router, "variant=holdem"), table.Table) || isListed(listedTables(router, "variant=holdem&dev=1"), table.Table) {
+		t.Errorf("table %s is not listed with the holdem tables", table.Table)
+	}
+
+	if _, state := request(router, "/state?table="+table.Table+"&player=Ann"); state.Viewing != 0 || len(state.Players) != 3 {
+		t.Errorf("joined with %d players, viewing %d", len(state.Players), state.Viewing)
+	}
+
+	private := createTableRequest(t, router, "private=1")
+	if private.Name != "Custom Table" || isListed(listedTables(router, ""), private.Table) {
+		t.Errorf("private table %+v is listed", private)
+	}
+
+	for _, query := range []string{"bots=8", "bots=-1", "bots=x", "stakes=huge", "variant=bridge"} {
+		if w := createPost(router, query); w.Code != http.StatusBadRequest {
+			t.Errorf("/create?%s returned %d, expected %d", query, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestCreateLimits(t *testing.T) {
+	resetBankrolls()
+	router := createTablesRouter()
+
+	// Creating a table changes the server, so it is never a GET
+	if w, _ := request(router, "/create"); w.Code != http.StatusNotFound && w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /create returned %d", w.Code)
+	}
+
+	// A single client can't take all the custom tables
+	for i := 0; i < MAX_CUSTOM_TABLES_PER_CLIENT; i++ {
+		if w := createPostFrom(router, "", "203.0.113.1:1234"); w.Code != http.StatusOK {
+			t.Fatalf("table %d returned %d %q", i+1, w.Code, w.Body.String())
+		}
+	}
+	if w := createPostFrom(router, "", "203.0.113.1:5678"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("table over the client limit returned %d, expected %d", w.Code, http.StatusTooManyRequests)
+	}
+	if w := createPostFrom(router, "", "203.0.113.2:1234"); w.Code != http.StatusOK {
+		t.Errorf("table for another client returned %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestTablePassword(t *testing.T) {
+	resetBankrolls()
+	router := createTablesRouter()
+
+	table := createTableRequest(t, router, "bots=1&pw=secret")
+	if !table.Password {
+		t.Errorf("table with a password is listed without one")
+	}
+
+	// Without the password, Ann only watches
+	_, state := request(router, "/state?table="+table.Table+"&player=Ann&pw=wrong")
+	if state.Viewing != 1 || state.Queue != 0 {
+		t.Errorf("sat down or queued with the wrong password")
+	}
+	if _, state = request(router, "/state?table="+table.Table+"&player=Ann&pw=SECRET&k="+state.Token); state.Viewing != 0 {
+		t.Errorf("did not sit down with the password")
+	}
+}
+
+func TestIdleTablesAreRemoved(t *testing.T) {
+	resetBankrolls()
+	router := createTablesRouter()
+
+	active := createTableRequest(t, router, "")
+	idle := createTableRequest(t, router, "")
+	createAuthTable("notcustom")
+	addTable(GameTable{Table: "notcustom"})
+
+	for _, table := range []string{idle.Table, "notcustom"} {
+		unlock := tableMutex.Lock(table)
+		loadTable(table).lastActive = time.Now().Add(-CUSTOM_TABLE_IDLE_TIME - time.Minute)
+		unlock()
+	}
+
+	cleanupIdleTables()
+
+	if _, ok := stateMap.Load(idle.Table); ok || isListed(getTables(), idle.Table) {
+		t.Errorf("idle table %s was not removed", idle.Table)
+	}
+	for _, table := range []string{active.Table, "notcustom"} {
+		if _, ok := stateMap.Load(table); !ok || !isListed(getTables(), table) {
+			t.Errorf("table %s was removed", table)
+		}
+	}
+}
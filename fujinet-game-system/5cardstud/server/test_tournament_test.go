@@ -0,0 +1,147 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTournamentRegistration(t *testing.T) {
+	resetBankrolls()
+	router := createTablesRouter()
+
+	table := createTableRequest(t, router, "tournament=1&seats=3&bots=1")
+	if table.Tournament != int(TOURNAMENT_REGISTERING) || table.MaxPlayers != 2 {
+		t.Errorf("created table = %+v", table)
+	}
+	if bot := loadTable(table.Table).Players[0]; !bot.tournament {
+		t.Errorf("bot %s plays without tournament chips", bot.Name)
+	}
+
+	// Ann's bankroll stays out of the tournament
+	bankrolls["ann"] = &Bankroll{Name: "Ann", Purse: 1000}
+	_, state := request(router, "/state?table="+table.Table+"&player=Ann")
+	if state.Tournament != int(TOURNAMENT_REGISTERING) || state.Seats != 3 || state.Round != 0 || state.Players[0].Purse != STARTING_PURSE {
+		t.Errorf("registered state = %+v", state)
+	}
+	if state.LastResult != "Waiting for 1 more players" {
+		t.Errorf("result %q while registering", state.LastResult)
+	}
+
+	// The last seat starts the tournament
+	request(router, "/state?table="+table.Table+"&player=Bob")
+	if _, state = request(router, "/state?table="+table.Table+"&player=Ann"); state.Tournament != int(TOURNAMENT_PLAYING) || state.Level != 1 || state.Round == 0 {
+		t.Errorf("tournament %d at level %d in round %d, expected to play", state.Tournament, state.Level, state.Round)
+	}
+
+	// Registration is closed, even for spectators that want to play
+	if _, state = request(router, "/state?table="+table.Table+"&player=Cy"); state.Viewing != 1 || state.Queue != 0 || len(state.Players) != 3 {
+		t.Errorf("Cy is viewing %d, queued %d, with %d players", state.Viewing, state.Queue, len(state.Players))
+	}
+
+	for _, query := range []string{"tournament=1&seats=1", "tournament=1&seats=9", "tournament=1&seats=3&bots=3", "tournament=1&levelhands=0", "tournament=1&levelminutes=x"} {
+		if w := createPost(router, query); w.Code != 400 {
+			t.Errorf("/create?%s returned %d, expected 400", query, w.Code)
+		}
+	}
+}
+
+func TestTournamentLevels(t *testing.T) {
+	state := createGameState(defaultVariant, limitStakes, mixedBots(3), false)
+	state.tournament = newTournament(3, 2, 0, limitStakes)
+	state.tournament.Started = time.Now()
+
+	for hand, low := range []int{LOW, LOW, 2 * LOW, 2 * LOW, 3 * LOW} {
+		state.updateLevel()
+		if state.betting.Low != low || state.betting.Ante != low/LOW*ANTE {
+			t.Errorf("hand %d stakes %+v, expected low %d", hand+1, state.betting, low)
+		}
+	}
+	if next := state.nextLevelIn(); next != 1 {
+		t.Errorf("next level in %d hands, expected 1", next)
+	}
+
+	// By time, the level follows the clock however many hands were played
+	state.tournament = newTournament(3, 0, 10*time.Minute, limitStakes)
+	state.tournament.Started = time.Now().Add(-25 * time.Minute)
+	state.updateLevel()
+	if state.tournament.Level != 2 || state.betting.High != 3*HIGH || state.nextLevelIn() != 5 {
+		t.Errorf("level %d, stakes %+v, next level in %d minutes", state.tournament.Level, state.betting, state.nextLevelIn())
+	}
+
+	// The last level lasts until the end
+	state.tournament.Started = time.Now().Add(-24 * time.Hour)
+	state.updateLevel()
+	if state.tournament.Level != len(tournamentLevels)-1 || state.nextLevelIn() != 0 {
+		t.Errorf("level %d, next level in %d minutes", state.tournament.Level, state.nextLevelIn())
+	}
+}
+
+func TestTournamentElimination(t *testing.T) {
+	resetBankrolls()
+	bankrolls["ann"] = &Bankroll{Name: "Ann", Purse: 1000}
+
+	state := createGameState(defaultVariant, limitStakes, mixedBots(2), false)
+	state.tournament = newTournament(3, 10, 0, limitStakes)
+	state.addPlayer("Ann", false)
+	state.tournament.Started = time.Now()
+
+	// Both bots bust in the same hand, the first with the smaller stack
+	state.Players[0].Purse, state.Players[0].totalBet = 0, 100
+	state.Players[1].Purse, state.Players[1].totalBet = 0, 200
+	state.Players[2].Purse = 600
+	recordPurse(&state.Players[2])
+	state.eliminateBustedPlayers()
+
+	expected := []Standing{{"Ann", 1, 3 * TOURNAMENT_PRIZE_PER_SEAT}, {botNames[1], 2, 0}, {botNames[0], 3, 0}}
+	if standings := state.standings(); state.tournamentStatus() != TOURNAMENT_FINISHED || len(standings) != 3 {
+		t.Fatalf("status %d with standings %+v", state.tournamentStatus(), standings)
+	} else {
+		for i := range expected {
+			if standings[i] != expected[i] {
+				t.Errorf("standing %d = %+v, expected %+v", i+1, standings[i], expected[i])
+			}
+		}
+	}
+
+	// Nobody bought in, so neither the tournament chips nor the prize reach the bankroll
+	if purse := bankrolls["ann"].Purse; purse != 1000 {
+		t.Errorf("bankroll %d after winning", purse)
+	}
+	if state.LastResult != "Ann won the tournament" {
+		t.Errorf("result %q", state.LastResult)
+	}
+}
+
+func TestTournamentPlaysToTheEnd(t *testing.T) {
+	resetBankrolls()
+
+	state := createGameState(defaultVariant, limitStakes, mixedBots(4, classicBot{}), false)
+	state.table = "sitandgo"
+	state.tournament = newTournament(4, 1, 0, limitStakes)
+	state.clientPlayer = -1
+
+	for step := 0; step < 20000 && state.tournamentStatus() != TOURNAMENT_FINISHED; step++ {
+		state.moveExpires = time.Now().Add(-time.Second)
+		state.runGameLogic()
+	}
+
+	standings := state.standings()
+	if state.tournamentStatus() != TOURNAMENT_FINISHED || len(standings) != 4 {
+		t.Fatalf("status %d after %d hands with standings %+v", state.tournamentStatus(), state.tournament.Hands, standings)
+	}
+
+	// The winner has the chips of everyone, less any odd chips of split pots
+	for _, player := range state.Players {
+		if player.Name == standings[0].Name && (player.Purse <= 3*STARTING_PURSE || player.Purse > 4*STARTING_PURSE) {
+			t.Errorf("winner %s has %d chips", player.Name, player.Purse)
+		}
+	}
+
+	// A finished tournament does not deal again
+	hands := state.tournament.Hands
+	state.moveExpires = time.Now().Add(-time.Second)
+	state.runGameLogic()
+	if state.tournament.Hands != hands || !state.gameOver {
+		t.Errorf("a new game started after the tournament")
+	}
+}
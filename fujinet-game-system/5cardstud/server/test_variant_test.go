@@ -0,0 +1,143 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Plays a table of bots until a number of games were played, checking the hands at every showdown
+func TestVariantsPlayToTheEnd(t *testing.T) {
+	for _, variant := range variants {
+		t.Run(variant.Code, func(t *testing.T) {
+			state := createGameState(variant, limitStakes, mixedBots(variant.MaxPlayers), false)
+			state.clientPlayer = 0
+
+			games := 0
+			for step := 0; step < 10000 && games < 20; step++ {
+				state.moveExpires = time.Now().Add(-time.Second)
+				wasOver := state.gameOver
+				state.runGameLogic()
+
+				if state.gameOver && !wasOver {
+					games++
+					if state.Round != variant.endRound() {
+						t.Fatalf("game over at round %d, expected %d", state.Round, variant.endRound())
+					}
+					if !state.wonByFolds {
+						checkShowdownHands(t, state)
+					}
+				}
+			}
+
+			if games < 20 {
+				t.Errorf("only %d games were played", games)
+			}
+		})
+	}
+}
+
+func checkShowdownHands(t *testing.T, state *GameState) {
+	t.Helper()
+
+	expectedCards := len(state.variant.down)
+	expectedBoard := strings.Count(strings.Join(state.variant.Deal, ""), "b")
+
+	seen := map[card]bool{}
+	for _, c := range state.board {
+		seen[c] = true
+	}
+
+	for _, player := range state.Players {
+		if player.Status != STATUS_PLAYING {
+			continue
+		}
+		if len(player.cards) != expectedCards {
+			t.Fatalf("%s has %d cards at the showdown, expected %d", player.Name, len(player.cards), expectedCards)
+		}
+		for _, c := range player.cards {
+			if seen[c] {
+				t.Fatalf("card %s was dealt twice", cardsToString([]card{c}))
+			}
+			seen[c] = true
+		}
+	}
+
+	if len(state.board) != expectedBoard {
+		t.Fatalf("board has %d cards at the showdown, expected %d", len(state.board), expectedBoard)
+	}
+}
+
+func TestClientSeesVariantCards(t *testing.T) {
+	state := createGameState(variant7CardStud, limitStakes, mixedBots(2), false)
+	state.addPlayer("Ann", false)
+	state.clientPlayer = 2
+	state.authorized = true
+	state.playerPing()
+	state.newRound()
+
+	// 7 Card Stud - two down cards and one up card on 3rd street
+	client := state.createClientState()
+	if hand := client.Players[0].Hand; len(hand) != 6 || hand[0:4] == "????" {
+		t.Errorf("client hand = %q, expected three visible cards", hand)
+	}
+	if hand := client.Players[1].Hand; len(hand) != 6 || hand[0:4] != "????" || hand[4:6] == "??" {
+		t.Errorf("other hand = %q, expected two hidden cards and one up card", hand)
+	}
+
+	// Texas Hold'em - blinds are posted and the board is shared
+	state = createGameState(variantHoldem, limitStakes, mixedBots(3), false)
+	state.clientPlayer = 0
+	state.newRound()
+
+	smallBlind := &state.Players[(state.dealer+1)%3]
+	bigBlind := &state.Players[(state.dealer+2)%3]
+	if state.currentBet != LOW || smallBlind.Bet != BRINGIN || bigBlind.Bet != LOW || state.ActivePlayer != state.dealer {
+		t.Errorf("blinds not posted: current bet %d, blinds %d %d, active %d", state.currentBet, smallBlind.Bet, bigBlind.Bet, state.ActivePlayer)
+	}
+
+	// The big blind can check when nobody raised
+	state.performMove("CA", true)
+	state.performMove("CA", true)
+	if moves := state.getValidMoves(); state.ActivePlayer != (state.dealer+2)%3 || moves[1].Move != "CH" {
+		t.Errorf("big blind moves = %v, expected a check", moves)
+	}
+
+	state.newRound()
+	if client := state.createClientState(); len(client.Board) != 6 {
+		t.Errorf("board after the flop = %q, expected 3 cards", client.Board)
+	}
+}
+
+func TestDrawMove(t *testing.T) {
+	state := createGameState(variant5CardDraw, limitStakes, mixedBots(2), false)
+	state.clientPlayer = 0
+	state.newRound()
+	state.Round = variant5CardDraw.DrawRound
+
+	active := state.ActivePlayer
+	before := append([]card{}, state.Players[active].cards...)
+
+	if moves := state.getValidMoves(); len(moves) != 1 || moves[0].Move != "DR" {
+		t.Fatalf("draw round moves = %v, expected only DR", moves)
+	}
+
+	for _, invalid := range []string{"DR6", "DR11", "DR1234", "CH"} {
+		if state.performMove(invalid, true) {
+			t.Errorf("performMove(%q) should fail", invalid)
+		}
+	}
+
+	if !state.performMove("DR25", true) {
+		t.Fatalf("performMove(DR25) failed")
+	}
+
+	after := state.Players[active].cards
+	if after[0] != before[0] || after[2] != before[2] || after[1] == before[1] || after[4] == before[4] {
+		t.Errorf("draw replaced the wrong cards: %s -> %s", cardsToString(before), cardsToString(after))
+	}
+
+	if state.Players[active].Move != "DRAW 2" {
+		t.Errorf("move = %q, expected DRAW 2", state.Players[active].Move)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// Sit-and-go tournaments - a tournament table is created with /create?tournament=1. Players register by
+// sitting down, and the tournament starts once every seat is taken. Everyone starts with the same stack of
+// tournament chips, which are kept apart from the bankroll. The stakes go up a level every few hands or
+// minutes, players are eliminated when they run out of chips, and the last player standing wins.
+// The top finishers win a share of a prize pool. Nobody buys in with their bankroll, so prizes are only
+// shown in the standings and never paid to it.
+
+const TOURNAMENT_DEFAULT_LEVEL_HANDS = 10
+const TOURNAMENT_MAX_LEVEL_HANDS = 100
+const TOURNAMENT_MAX_LEVEL_MINUTES = 60
+const TOURNAMENT_PRIZE_PER_SEAT = 100
+
+// Stakes of each level, as a multiple of the stakes of the table
+var tournamentLevels = []int{1, 2, 3, 5, 8, 10, 15, 20, 30, 50}
+
+type TournamentStatus int
+
+const (
+	TOURNAMENT_NONE        TournamentStatus = 0
+	TOURNAMENT_REGISTERING TournamentStatus = 1
+	TOURNAMENT_PLAYING     TournamentStatus = 2
+	TOURNAMENT_FINISHED    TournamentStatus = 3
+)
+
+// The tournament of a table. Seats is 0 on a cash game table
+type Tournament struct {
+	Seats      int              `json:"seats"`
+	LevelHands int              `json:"levelHands,omitempty"` // Hands per level, or
+	LevelTime  time.Duration    `json:"levelTime,omitempty"`  // time per level
+	Base       BettingStructure `json:"base"`                 // Stakes of the first level
+	Started    time.Time        `json:"started"`
+	Level      int              `json:"level"` // Index in tournamentLevels
+	Hands      int              `json:"hands"`
+	Finished   bool             `json:"finished"`
+	Standings  []Standing       `json:"standings"`
+}
+
+// Final place of a player that was eliminated or won
+type Standing struct {
+	Name  string `json:"n"`
+	Place int    `json:"p"`
+	Prize int    `json:"w"` // Share of the prize pool, not chips
+}
+
+// Creates a tournament for the given number of seats, levels lasting levelHands hands or, if set, levelTime
+func newTournament(seats int, levelHands int, levelTime time.Duration, betting BettingStructure) Tournament {
+	return Tournament{Seats: seats, LevelHands: levelHands, LevelTime: levelTime, Base: betting}
+}
+
+func (state *GameState) isTournament() bool {
+	return state.tournament.Seats > 0
+}
+
+func (state *GameState) tournamentStatus() TournamentStatus {
+	switch {
+	case !state.isTournament():
+		return TOURNAMENT_NONE
+	case state.tournament.Finished:
+		return TOURNAMENT_FINISHED
+	case !state.tournament.Started.IsZero():
+		return TOURNAMENT_PLAYING
+	}
+	return TOURNAMENT_REGISTERING
+}
+
+// Once a tournament started, nobody else can sit down
+func (state *GameState) registrationClosed() bool {
+	return state.isTournament() && !state.tournament.Started.IsZero()
+}
+
+// Seats at the table, fewer than the variant allows for a smaller tournament
+func (state *GameState) maxPlayers() int {
+	if state.isTournament() {
+		return state.tournament.Seats
+	}
+	return state.variant.MaxPlayers
+}
+
+// Starts the tournament once every seat is taken. Returns false while players are still registering
+func (state *GameState) startTournament() bool {
+	if state.tournamentStatus() != TOURNAMENT_REGISTERING {
+		return true
+	}
+
+	if len(state.Players) < state.tournament.Seats {
+		state.LastResult = fmt.Sprintf("Waiting for %d more players", state.tournament.Seats-len(state.Players))
+		return false
+	}
+
+	state.tournament.Started = state.now()
+	state.LastResult = ""
+	log.Printf("Tournament started at table %s", state.table)
+	return true
+}
+
+// Sets the stakes of the current level at the start of a game
+func (state *GameState) updateLevel() {
+	if state.tournamentStatus() != TOURNAMENT_PLAYING {
+		return
+	}
+
+	tournament := &state.tournament
+	level := 0
+	if tournament.LevelTime > 0 {
+		level = int(state.now().Sub(tournament.Started) / tournament.LevelTime)
+	} else {
+		level = tournament.Hands / tournament.LevelHands
+	}
+	if level >= len(tournamentLevels) {
+		level = len(tournamentLevels) - 1
+	}
+	tournament.Hands++
+
+	multiple := tournamentLevels[level]
+	state.betting = tournament.Base
+	state.betting.Ante *= multiple
+	state.betting.BringIn *= multiple
+	state.betting.Low *= multiple
+	state.betting.High *= multiple
+
+	if level != tournament.Level {
+		tournament.Level = level
+		state.record("Level %d: %s, ante %d", level+1, state.betting.String(), state.betting.Ante)
+	}
+}
+
+// Hands, or for levels by time whole minutes, until the next level. 0 at the last level
+func (state *GameState) nextLevelIn() int {
+	tournament := state.tournament
+	if state.tournamentStatus() != TOURNAMENT_PLAYING || tournament.Level >= len(tournamentLevels)-1 {
+		return 0
+	}
+
+	if tournament.LevelTime > 0 {
+		left := tournament.Started.Add(time.Duration(tournament.Level+1) * tournament.LevelTime).Sub(state.now())
+		if left < 0 {
+			return 0
+		}
+		return int((left + time.Minute - 1) / time.Minute)
+	}
+	return (tournament.Level+1)*tournament.LevelHands - tournament.Hands
+}
+
+// Number of players that are still in the tournament
+func (state *GameState) playersRemaining() int {
+	return state.tournament.Seats - len(state.tournament.Standings)
+}
+
+func (state *GameState) isEliminated(name string) bool {
+	for _, standing := range state.tournament.Standings {
+		if standing.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Eliminates the player, in the last place still open
+func (state *GameState) eliminate(player *Player) {
+	if state.tournamentStatus() != TOURNAMENT_PLAYING || state.isEliminated(player.Name) {
+		return
+	}
+
+	place := state.playersRemaining()
+	state.tournament.Standings = append(append([]Standing{}, state.tournament.Standings...), Standing{Name: player.Name, Place: place})
+	state.record("%s is eliminated in place %d", player.Name, place)
+}
+
+// At the end of a game, eliminates the players that ran out of chips
+func (state *GameState) eliminateBustedPlayers() {
+	if state.tournamentStatus() != TOURNAMENT_PLAYING {
+		return
+	}
+
+	// A player that started the game with fewer chips finishes below the others that busted with them
+	busted := []int{}
+	for i, player := range state.Players {
+		if player.Purse == 0 && !state.isEliminated(player.Name) {
+			busted = append(busted, i)
+		}
+	}
+	sort.SliceStable(busted, func(i, j int) bool { return state.Players[busted[i]].totalBet < state.Players[busted[j]].totalBet })
+
+	for _, index := range busted {
+		player := &state.Players[index]
+		state.eliminate(player)
+		player.Status = STATUS_LEFT
+		player.Move = "OUT"
+	}
+
+	state.checkTournamentWinner()
+}
+
+// Finishes the tournament once a single player is left
+func (state *GameState) checkTournamentWinner() {
+	if state.tournamentStatus() != TOURNAMENT_PLAYING || state.playersRemaining() > 1 {
+		return
+	}
+
+	for i := range state.Players {
+		if player := &state.Players[i]; !state.isEliminated(player.Name) {
+			state.eliminate(player)
+			state.LastResult = player.Name + " won the tournament"
+		}
+	}
+	state.finishTournament()
+}
+
+// Works out the prizes of the top finishers
+func (state *GameState) finishTournament() {
+	tournament := &state.tournament
+	tournament.Finished = true
+
+	payouts := tournamentPayouts(tournament.Seats)
+	pool := tournament.Seats * TOURNAMENT_PRIZE_PER_SEAT
+	for i := range tournament.Standings {
+		standing := &tournament.Standings[i]
+		if standing.Place > len(payouts) {
+			continue
+		}
+		standing.Prize = pool * payouts[standing.Place-1] / 100
+	}
+
+	state.record("Tournament finished. %s", state.LastResult)
+	log.Printf("Tournament finished at table %s. %s", state.table, state.LastResult)
+}
+
+// Percentages of the prize pool paid to each place
+func tournamentPayouts(seats int) []int {
+	switch {
+	case seats <= 3:
+		return []int{100}
+	case seats <= 6:
+		return []int{65, 35}
+	}
+	return []int{50, 30, 20}
+}
+
+// Standings from first place down
+func (state *GameState) standings() []Standing {
+	standings := append([]Standing{}, state.tournament.Standings...)
+	sort.Slice(standings, func(i, j int) bool { return standings[i].Place < standings[j].Place })
+	return standings
+}
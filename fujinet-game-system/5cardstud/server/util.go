@@ -1,6 +1,8 @@
 package main
 
 import (
+	"encoding/binary"
+	"fmt"
 	"net/http"
 	"strings"
 
@@ -46,7 +48,133 @@ func serializeResults(c *gin.Context, obj any) {
 
 		c.String(http.StatusOK, jsonResult)
 
+	} else if c.Query("bin") == "1" {
+		var buf []byte
+		bigEndian := c.Query("be") == "1"
+
+		appendValue := func(val int) {
+			if val > 0xFFFF {
+				val = 0xFFFF
+			}
+			if bigEndian {
+				buf = binary.BigEndian.AppendUint16(buf, uint16(val))
+			} else {
+				buf = binary.LittleEndian.AppendUint16(buf, uint16(val))
+			}
+		}
+
+		// Binary version of Leaderboard
+		if o, ok := obj.(Leaderboard); ok {
+			buf = append(buf, BIN_VERSION)
+			for _, list := range [][]LeaderboardValue{o.Purses, o.HandsWon} {
+				buf = append(buf, byte(len(list)))
+				for _, entry := range list {
+					buf = appendFixedLengthString(buf, entry.Name, 8)
+					appendValue(entry.Value)
+				}
+			}
+			buf = append(buf, byte(len(o.BestHands)))
+			for _, entry := range o.BestHands {
+				buf = appendFixedLengthString(buf, entry.Name, 8)
+				buf = appendFixedLengthString(buf, entry.Hand, 32)
+				buf = appendFixedLengthString(buf, entry.Cards, 10)
+			}
+		}
+
+		// Binary version of player stats
+		if o, ok := obj.(StatsSummary); ok {
+			buf = append(buf, BIN_VERSION)
+			buf = appendFixedLengthString(buf, o.Name, 8)
+			appendValue(o.Hands)
+			buf = append(buf, byte(o.VPIP), byte(o.FoldRate))
+			appendValue(o.Showdowns)
+			buf = append(buf, byte(o.ShowdownWin))
+			appendValue(o.BiggestPot)
+		}
+
+		// Binary version of Table list
+		if tables, ok := obj.([]GameTable); ok {
+			buf = append(buf, BIN_VERSION, byte(len(tables)))
+			for _, o := range tables {
+				buf = appendFixedLengthString(buf, o.Table, 8)
+				buf = appendFixedLengthString(buf, o.Name, 20)
+				buf = appendFixedLengthString(buf, fmt.Sprintf("%d / %d", o.CurPlayers, o.MaxPlayers), 5)
+				buf = appendFixedLengthString(buf, o.Stakes, 16)
+				buf = append(buf, byte(o.Spectators), boolToByte(o.Password), byte(o.Tournament))
+			}
+		}
+
+		// Binary version of GameState
+		if o, ok := obj.(*GameState); ok {
+			buf = append(buf, BIN_VERSION)
+			buf = appendFixedLengthString(buf, o.LastResult, 60)
+			buf = append(buf, byte(o.Round))
+			appendValue(o.Pot)
+			buf = append(buf,
+				byte(o.ActivePlayer),
+				byte(o.MoveTime),
+				byte(o.Viewing))
+			buf = appendFixedLengthString(buf, o.Board, 10)
+			buf = appendFixedLengthString(buf, o.Token, 8)
+			buf = appendFixedLengthString(buf, o.Hash, 20)
+
+			buf = append(buf, byte(len(o.ValidMoves)))
+			for _, move := range o.ValidMoves {
+				buf = appendFixedLengthString(buf, move.Move, 2)
+				buf = appendFixedLengthString(buf, move.Name, 15)
+			}
+
+			buf = append(buf, byte(len(o.Players)))
+			for _, player := range o.Players {
+				buf = appendFixedLengthString(buf, player.Name, 8)
+				buf = append(buf, byte(player.Status))
+				appendValue(player.Bet)
+				buf = appendFixedLengthString(buf, player.Move, 8)
+				appendValue(player.Purse)
+				buf = appendFixedLengthString(buf, player.Hand, 14)
+			}
+
+			buf = append(buf, byte(o.Spectators), byte(o.Queue), byte(len(o.Chat)))
+			for _, message := range o.Chat {
+				buf = appendFixedLengthString(buf, message.Name, 8)
+				buf = appendFixedLengthString(buf, message.Text, CHAT_MESSAGE_LENGTH)
+			}
+		}
+
+		c.Data(http.StatusOK, "application/octet-stream", buf)
 	} else {
 		c.JSON(http.StatusOK, obj)
 	}
 }
+
+// Version of the binary layouts of the table list, state, leaderboard and player stats (bin=1), sent as their first byte
+const BIN_VERSION = 3
+
+// 1 for true, 0 for false
+func boolToByte(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Returns a byte slice equal to the maxLen+1, padded with zeros
+// The extra byte is added to terminate the string
+func appendFixedLengthString(buf []byte, s string, maxLen int) []byte {
+
+	// Truncate string to honor contract
+	if len(s) > maxLen {
+		s = s[:maxLen]
+	}
+
+	// Convert to lowercase
+	s = strings.ToLower(s)
+
+	buf = append(buf, s...)
+	maxLen -= len(s)
+	for maxLen >= 0 {
+		buf = append(buf, 0)
+		maxLen--
+	}
+	return buf
+}
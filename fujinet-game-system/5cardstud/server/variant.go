@@ -0,0 +1,109 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/ericcarrgh/cardrank"
+)
+
+// Poker variants - every table plays one of them on the same engine.
+// A variant decides how cards are dealt each round, which cards the other players see,
+// who acts first and how the final hands are evaluated. The betting (ante, bring-in, low/high bets)
+// is shared by all of them.
+
+type Variant struct {
+	Code       string        // Used to select the variant, e.g. /tables?variant=7cs
+	Name       string        // Friendly name
+	Rounds     int           // Number of betting rounds. The game is over at Rounds+1
+	Deal       []string      // Cards dealt at the start of each round: d = down card, u = up card, b = board (community) card
+	Eval       cardrank.Type // Used to rank the final hands
+	BringIn    bool          // Stud: lowest up card must bring-in, best visible hand acts first. Otherwise a dealer button rotates
+	Blinds     bool          // Small and big blind are posted instead of an ante
+	DrawRound  int           // Round where players discard and draw instead of betting, 0 if none
+	MaxPlayers int
+	MaxDiscard int // Max cards a player may discard in the draw round
+
+	down []bool // If the card at each index is dealt face down
+}
+
+var variant5CardStud = &Variant{
+	Code:       "5cs",
+	Name:       "5 Card Stud",
+	Rounds:     4,
+	Deal:       []string{"du", "u", "u", "u"},
+	Eval:       cardrank.StudFive,
+	BringIn:    true,
+	MaxPlayers: 8,
+}
+
+var variant7CardStud = &Variant{
+	Code:       "7cs",
+	Name:       "7 Card Stud",
+	Rounds:     5,
+	Deal:       []string{"ddu", "u", "u", "u", "d"},
+	Eval:       cardrank.Stud,
+	BringIn:    true,
+	MaxPlayers: 7, // 7 players x 7 cards fit in a deck
+}
+
+var variant5CardDraw = &Variant{
+	Code:       "5cd",
+	Name:       "5 Card Draw",
+	Rounds:     3,
+	Deal:       []string{"ddddd", "", ""},
+	Eval:       cardrank.Draw,
+	DrawRound:  2,
+	MaxPlayers: 8,
+	MaxDiscard: 3,
+}
+
+var variantHoldem = &Variant{
+	Code:       "holdem",
+	Name:       "Texas Hold'em",
+	Rounds:     4,
+	Deal:       []string{"dd", "bbb", "b", "b"},
+	Eval:       cardrank.Holdem,
+	Blinds:     true,
+	MaxPlayers: 8,
+}
+
+var variants = []*Variant{variant5CardStud, variant7CardStud, variant5CardDraw, variantHoldem}
+
+// The variant of tables that do not say otherwise, and the only one 8-bit lobby clients know
+var defaultVariant = variant5CardStud
+
+func init() {
+	for _, variant := range variants {
+		for _, deal := range variant.Deal {
+			for _, c := range deal {
+				if c != 'b' {
+					variant.down = append(variant.down, c == 'd')
+				}
+			}
+		}
+	}
+}
+
+// Returns the variant with the given code, or nil if there is none
+func getVariant(code string) *Variant {
+	if code == "" {
+		return defaultVariant
+	}
+
+	for _, variant := range variants {
+		if strings.EqualFold(variant.Code, code) {
+			return variant
+		}
+	}
+	return nil
+}
+
+// If the card at index of a player's hand is only visible to that player
+func (variant *Variant) isDown(index int) bool {
+	return index < len(variant.down) && variant.down[index]
+}
+
+// The round where the game is over and the pot is awarded
+func (variant *Variant) endRound() int {
+	return variant.Rounds + 1
+}